@@ -107,6 +107,7 @@ var symbolTableBackend = make(map[string]Symbol_Asm)
 //###############################################################################
 
 type Top_Level_Asm interface {
+	allocateRegisters()
 	replacePseudoregisters(nameToOffset map[string]int32)
 	fixInvalidInstr()
 	topLevelEmitAsm(file *os.File)
@@ -119,6 +120,10 @@ type Function_Asm struct {
 	global       bool
 	instructions []Instruction_Asm
 	stackSize    int32
+	// the callee saved registers that the register allocator used, they get saved in the prologue
+	calleeSavedRegisters []RegisterTypeAsm
+	// the locals are found from RSP, so RBP isn't saved and set up in the prologue
+	omitFramePointer bool
 }
 
 /////////////////////////////////////////////////////////////////////////////////
@@ -193,8 +198,9 @@ type Move_Zero_Extend_Instruction_Asm struct {
 
 // Load Effective Address
 type Lea_Instruction_Asm struct {
-	src Operand_Asm
-	dst Operand_Asm
+	asmTyp AssemblyTypeEnum
+	src    Operand_Asm
+	dst    Operand_Asm
 }
 
 /////////////////////////////////////////////////////////////////////////////////
@@ -256,6 +262,15 @@ type Divide_Instruction_Asm struct {
 
 /////////////////////////////////////////////////////////////////////////////////
 
+// one operand imul or mul, AX is multiplied by src and the high half of the result goes in DX
+type Multiply_High_Instruction_Asm struct {
+	signed bool
+	asmTyp AssemblyTypeEnum
+	src    Operand_Asm
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
 type CDQ_Sign_Extend_Instruction_Asm struct {
 	asmTyp AssemblyTypeEnum
 }
@@ -282,6 +297,15 @@ type Set_Conditional_Instruction_Asm struct {
 
 /////////////////////////////////////////////////////////////////////////////////
 
+type Conditional_Move_Instruction_Asm struct {
+	code   ConditionalCodeAsm
+	asmTyp AssemblyTypeEnum
+	src    Operand_Asm
+	dst    Operand_Asm
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
 type Label_Instruction_Asm struct {
 	name string
 }
@@ -294,6 +318,13 @@ type Push_Instruction_Asm struct {
 
 /////////////////////////////////////////////////////////////////////////////////
 
+// only used to restore the callee saved registers before returning
+type Pop_Instruction_Asm struct {
+	reg RegisterTypeAsm
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
 type Call_Function_Asm struct {
 	name string
 }
@@ -301,6 +332,16 @@ type Call_Function_Asm struct {
 /////////////////////////////////////////////////////////////////////////////////
 
 type Ret_Instruction_Asm struct {
+	// there's no saved RBP to restore, the stack was already put back by the instructions before
+	omitFramePointer bool
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// Jumps to a function instead of calling it, after tearing down our stack frame, so it returns straight to our caller.
+type Tail_Call_Function_Asm struct {
+	name             string
+	omitFramePointer bool
 }
 
 //###############################################################################
@@ -345,6 +386,9 @@ const (
 	AND_OPERATOR_ASM
 	OR_OPERATOR_ASM
 	XOR_OPERATOR_ASM
+	SHIFT_LEFT_OPERATOR_ASM
+	SHIFT_RIGHT_ARITHMETIC_OPERATOR_ASM
+	SHIFT_RIGHT_LOGICAL_OPERATOR_ASM
 )
 
 func convertBinaryOpToAsm(binOp BinaryOperatorType) BinaryOperatorTypeAsm {
@@ -376,7 +420,7 @@ func opIsBigImm(op Operand_Asm) bool {
 	}
 	// TODO: ParseUint?
 	integer, _ := strconv.ParseInt(imm.value, 10, 64)
-	if integer > math.MaxInt32 {
+	if (integer > math.MaxInt32) || (integer < math.MinInt32) {
 		return true
 	} else {
 		return false
@@ -427,6 +471,15 @@ type Data_Operand_Asm struct {
 	name string
 }
 
+/////////////////////////////////////////////////////////////////////////////////
+
+// base + index * scale, only used by lea after the registers are allocated
+type Indexed_Operand_Asm struct {
+	base  RegisterTypeAsm
+	index RegisterTypeAsm
+	scale int32
+}
+
 //###############################################################################
 //###############################################################################
 //###############################################################################
@@ -498,6 +551,11 @@ const (
 	R9_REGISTER_ASM
 	R10_REGISTER_ASM
 	R11_REGISTER_ASM
+	BX_REGISTER_ASM
+	R12_REGISTER_ASM
+	R13_REGISTER_ASM
+	R14_REGISTER_ASM
+	R15_REGISTER_ASM
 	SP_REGISTER_ASM
 	BP_REGISTER_ASM
 	XMM0_REGISTER_ASM
@@ -508,6 +566,12 @@ const (
 	XMM5_REGISTER_ASM
 	XMM6_REGISTER_ASM
 	XMM7_REGISTER_ASM
+	XMM8_REGISTER_ASM
+	XMM9_REGISTER_ASM
+	XMM10_REGISTER_ASM
+	XMM11_REGISTER_ASM
+	XMM12_REGISTER_ASM
+	XMM13_REGISTER_ASM
 	XMM14_REGISTER_ASM
 	XMM15_REGISTER_ASM
 )
@@ -541,8 +605,12 @@ func doAssemblyGen(tacky Program_Tacky) Program_Asm {
 		symbolTableBackend[name] = symAsm
 	}
 
+	asm.allocateRegisters()
+	printAssemblyIfRequested("register-allocation", &asm)
 	asm.replacePseudoregisters()
 	asm.instructionFixup()
+	printAssemblyIfRequested("instruction-fixup", &asm)
+	doAssemblyOptimization(&asm)
 
 	return asm
 }
@@ -597,7 +665,28 @@ func (fn *Function_Definition_Tacky) topLevelToAsm() Top_Level_Asm {
 		}
 	}
 
-	for _, instrTacky := range fn.body {
+	// a call whose result is returned right away can reuse our stack frame, unless a pointer into the frame got out
+	allowTailCalls := isOptimizationEnabled("optimize-sibling-calls") && !hasAddressTakenLocals(fn.body)
+	labels := makeLabelIndexes(fn.body)
+
+	unreachable := false
+	for index, instrTacky := range fn.body {
+		_, isLabel := instrTacky.(*Label_Instruction_Tacky)
+		if unreachable && !isLabel {
+			continue
+		}
+		unreachable = false
+
+		call, isCall := instrTacky.(*Function_Call_Tacky)
+		if allowTailCalls && isCall && isInTailPosition(fn.body, index, labels) {
+			_, _, stackArgs := classifyParameters(call.args)
+			if len(stackArgs) == 0 {
+				// nothing after the jump runs until the next label, other paths could still jump there
+				instructions = append(instructions, call.tailCallToAsm()...)
+				unreachable = true
+				continue
+			}
+		}
 		convertedInstructions := instrTacky.instructionToAsm()
 		instructions = append(instructions, convertedInstructions...)
 	}
@@ -783,6 +872,28 @@ func (instr *Unary_Instruction_Tacky) instructionToAsm() []Instruction_Asm {
 /////////////////////////////////////////////////////////////////////////////////
 
 func (instr *Binary_Instruction_Tacky) instructionToAsm() []Instruction_Asm {
+	if isOptimizationEnabled("strength-reduction") {
+		instructions := instr.strengthReduceToAsm()
+		if instructions != nil {
+			return instructions
+		}
+	}
+
+	// the arithmetic below writes src1 to the dst before it reads src2, so src2 can't be the dst. The optimizations
+	// can make instructions like x = y - x.
+	isArithmetic := (instr.binOp == ADD_OPERATOR) || (instr.binOp == SUBTRACT_OPERATOR) || (instr.binOp == MULTIPLY_OPERATOR) ||
+		((instr.binOp == DIVIDE_OPERATOR) && (instr.dst.getAssemblyType() == DOUBLE_ASM_TYPE))
+	if isArithmetic && isSameValue(instr.dst, instr.src2) && !isSameValue(instr.src1, instr.src2) {
+		if (instr.binOp == ADD_OPERATOR) || (instr.binOp == MULTIPLY_OPERATOR) {
+			swapped := Binary_Instruction_Tacky{binOp: instr.binOp, src1: instr.src2, src2: instr.src1, dst: instr.dst}
+			return swapped.instructionToAsm()
+		}
+		temp := makeTackyVariable(instr.dst.getDataType())
+		toTemp := Binary_Instruction_Tacky{binOp: instr.binOp, src1: instr.src1, src2: instr.src2, dst: &temp}
+		copyInstr := Copy_Instruction_Tacky{src: &temp, dst: instr.dst}
+		return append(toTemp.instructionToAsm(), copyInstr.instructionToAsm()...)
+	}
+
 	if instr.binOp == ADD_OPERATOR || instr.binOp == SUBTRACT_OPERATOR || instr.binOp == MULTIPLY_OPERATOR {
 		src1 := instr.src1.valueToAsm()
 		dst := instr.dst.valueToAsm()
@@ -859,8 +970,32 @@ func (instr *Copy_Instruction_Tacky) instructionToAsm() []Instruction_Asm {
 
 /////////////////////////////////////////////////////////////////////////////////
 
+// cmp, then mov src2 to dst, then cmov src1 to dst. The mov goes after the cmp in case dst is one of the operands
+// being compared, and mov doesn't change the flags.
+func (instr *Conditional_Copy_Instruction_Tacky) instructionToAsm() []Instruction_Asm {
+	code := convertBinaryOpToCondition(instr.binOp, instr.left.isSigned())
+	src1 := instr.src1
+	src2 := instr.src2
+	if isSameValue(instr.dst, src1) && !isSameValue(src1, src2) {
+		// the mov would overwrite src1, so flip the condition and mov src1 instead, which does nothing
+		code = invertConditionalCode(code)
+		src1, src2 = src2, src1
+	}
+
+	cmp := Compare_Instruction_Asm{asmTyp: instr.left.getAssemblyType(), op1: instr.right.valueToAsm(), op2: instr.left.valueToAsm()}
+	instructions := []Instruction_Asm{&cmp}
+	if !isSameValue(instr.dst, src2) {
+		mov := Mov_Instruction_Asm{asmTyp: instr.dst.getAssemblyType(), src: src2.valueToAsm(), dst: instr.dst.valueToAsm()}
+		instructions = append(instructions, &mov)
+	}
+	cmov := Conditional_Move_Instruction_Asm{code: code, asmTyp: instr.dst.getAssemblyType(), src: src1.valueToAsm(), dst: instr.dst.valueToAsm()}
+	return append(instructions, &cmov)
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
 func (instr *Get_Address_Instruction_Tacky) instructionToAsm() []Instruction_Asm {
-	lea := Lea_Instruction_Asm{src: instr.src.valueToAsm(), dst: instr.dst.valueToAsm()}
+	lea := Lea_Instruction_Asm{asmTyp: QUADWORD_ASM_TYPE, src: instr.src.valueToAsm(), dst: instr.dst.valueToAsm()}
 	return []Instruction_Asm{&lea}
 }
 
@@ -923,6 +1058,14 @@ func (instr *Jump_If_Not_Zero_Instruction_Tacky) instructionToAsm() []Instructio
 
 /////////////////////////////////////////////////////////////////////////////////
 
+func (instr *Jump_If_Compare_Instruction_Tacky) instructionToAsm() []Instruction_Asm {
+	cmp := Compare_Instruction_Asm{asmTyp: instr.src1.getAssemblyType(), op1: instr.src2.valueToAsm(), op2: instr.src1.valueToAsm()}
+	jmpC := Jump_Conditional_Instruction_Asm{code: convertBinaryOpToCondition(instr.binOp, instr.src1.isSigned()), target: instr.target}
+	return []Instruction_Asm{&cmp, &jmpC}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
 func (instr *Label_Instruction_Tacky) instructionToAsm() []Instruction_Asm {
 	label := Label_Instruction_Asm{instr.name}
 	return []Instruction_Asm{&label}
@@ -952,21 +1095,7 @@ func (instr *Function_Call_Tacky) instructionToAsm() []Instruction_Asm {
 		instructions = append(instructions, &instr)
 	}
 
-	// pass some args in general purpose registers
-	for index, arg := range intRegArgs {
-		src := arg.valueToAsm()
-		dst := Register_Operand_Asm{INT_ARG_REGISTERS[index]}
-		mov := Mov_Instruction_Asm{asmTyp: arg.getAssemblyType(), src: src, dst: &dst}
-		instructions = append(instructions, &mov)
-	}
-
-	// pass some args in the floating point registers
-	for index, arg := range doubleRegArgs {
-		src := arg.valueToAsm()
-		dst := Register_Operand_Asm{DOUBLE_ARG_REGISTERS[index]}
-		mov := Mov_Instruction_Asm{asmTyp: DOUBLE_ASM_TYPE, src: src, dst: &dst}
-		instructions = append(instructions, &mov)
-	}
+	instructions = append(instructions, passArgsInRegisters(intRegArgs, doubleRegArgs)...)
 
 	// pass some args on the stack
 	for index := len(stackArgs) - 1; index >= 0; index-- {
@@ -1012,6 +1141,39 @@ func (instr *Function_Call_Tacky) instructionToAsm() []Instruction_Asm {
 
 /////////////////////////////////////////////////////////////////////////////////
 
+// Only used when all the args fit in registers. The return value is already in the right register for our caller.
+func (instr *Function_Call_Tacky) tailCallToAsm() []Instruction_Asm {
+	intRegArgs, doubleRegArgs, _ := classifyParameters(instr.args)
+	instructions := passArgsInRegisters(intRegArgs, doubleRegArgs)
+	return append(instructions, &Tail_Call_Function_Asm{name: instr.funcName})
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func passArgsInRegisters(intRegArgs []Value_Tacky, doubleRegArgs []Value_Tacky) []Instruction_Asm {
+	instructions := []Instruction_Asm{}
+
+	// pass some args in general purpose registers
+	for index, arg := range intRegArgs {
+		src := arg.valueToAsm()
+		dst := Register_Operand_Asm{INT_ARG_REGISTERS[index]}
+		mov := Mov_Instruction_Asm{asmTyp: arg.getAssemblyType(), src: src, dst: &dst}
+		instructions = append(instructions, &mov)
+	}
+
+	// pass some args in the floating point registers
+	for index, arg := range doubleRegArgs {
+		src := arg.valueToAsm()
+		dst := Register_Operand_Asm{DOUBLE_ARG_REGISTERS[index]}
+		mov := Mov_Instruction_Asm{asmTyp: DOUBLE_ASM_TYPE, src: src, dst: &dst}
+		instructions = append(instructions, &mov)
+	}
+
+	return instructions
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
 func classifyParameters[T any](params []T) ([]T, []T, []T) {
 	intRegParams := []T{}
 	doubleRegParams := []T{}
@@ -1141,6 +1303,10 @@ func (st *Static_Constant_Asm) replacePseudoregisters(nameToOffset map[string]in
 /////////////////////////////////////////////////////////////////////////////////
 
 func (fn *Function_Asm) replacePseudoregisters(nameToOffset map[string]int32) {
+	if isOptimizationEnabled("share-stack-slots") {
+		fn.shareStackSlots(nameToOffset)
+	}
+
 	for index, _ := range fn.instructions {
 		switch convertedInstr := fn.instructions[index].(type) {
 		case *Mov_Instruction_Asm:
@@ -1180,6 +1346,9 @@ func (fn *Function_Asm) replacePseudoregisters(nameToOffset map[string]int32) {
 		case *Divide_Instruction_Asm:
 			convertedInstr.divisor = replaceIfPseudoregister(convertedInstr.divisor, &fn.stackSize, nameToOffset)
 			fn.instructions[index] = convertedInstr
+		case *Multiply_High_Instruction_Asm:
+			convertedInstr.src = replaceIfPseudoregister(convertedInstr.src, &fn.stackSize, nameToOffset)
+			fn.instructions[index] = convertedInstr
 		case *Compare_Instruction_Asm:
 			convertedInstr.op1 = replaceIfPseudoregister(convertedInstr.op1, &fn.stackSize, nameToOffset)
 			convertedInstr.op2 = replaceIfPseudoregister(convertedInstr.op2, &fn.stackSize, nameToOffset)
@@ -1187,6 +1356,10 @@ func (fn *Function_Asm) replacePseudoregisters(nameToOffset map[string]int32) {
 		case *Set_Conditional_Instruction_Asm:
 			convertedInstr.dst = replaceIfPseudoregister(convertedInstr.dst, &fn.stackSize, nameToOffset)
 			fn.instructions[index] = convertedInstr
+		case *Conditional_Move_Instruction_Asm:
+			convertedInstr.src = replaceIfPseudoregister(convertedInstr.src, &fn.stackSize, nameToOffset)
+			convertedInstr.dst = replaceIfPseudoregister(convertedInstr.dst, &fn.stackSize, nameToOffset)
+			fn.instructions[index] = convertedInstr
 		case *Push_Instruction_Asm:
 			convertedInstr.op = replaceIfPseudoregister(convertedInstr.op, &fn.stackSize, nameToOffset)
 			fn.instructions[index] = convertedInstr
@@ -1245,20 +1418,76 @@ func (pr *Program_Asm) instructionFixup() {
 /////////////////////////////////////////////////////////////////////////////////
 
 func (fn *Function_Asm) fixInvalidInstr() {
-	// round up the stack size to the nearest multiple of 16, although we're actually rounding down since it's negative...
-	newStackSize := fn.stackSize
-	remainder := newStackSize % 16
-	if remainder != 0 {
-		newStackSize = (newStackSize/16)*16 - 16
+	calleeSavedSize := int32(8 * len(fn.calleeSavedRegisters))
+	localsSize := -fn.stackSize
+	fn.omitFramePointer = isOptimizationEnabled("omit-frame-pointer")
+	// With a frame pointer the callee saved registers would be pushed over the red zone, so it's only used when
+	// there aren't any.
+	useRedZone := isOptimizationEnabled("use-red-zone") && fn.isLeaf() && (localsSize <= RED_ZONE_SIZE) &&
+		(fn.omitFramePointer || (calleeSavedSize == 0))
+
+	if useRedZone {
+		// the locals fit below the stack pointer, so nothing has to be allocated
+		fn.stackSize = 0
+		if fn.omitFramePointer {
+			// the callee saved registers are pushed first and the locals go below them
+			fn.addressFromStackPointer(0, calleeSavedSize)
+		}
+	} else if fn.omitFramePointer {
+		// RSP is 8 bytes off from a multiple of 16 when we start because of the return address, so the frame has to
+		// be 8 bytes off too for RSP to be a multiple of 16 at every call
+		frameSize := localsSize + calleeSavedSize
+		frameSize = ((frameSize+8+15)/16)*16 - 8
+		fn.stackSize = -(frameSize - calleeSavedSize)
+		fn.addressFromStackPointer(frameSize, frameSize)
+	} else {
+		// Round up the stack size to the nearest multiple of 16, although we're actually rounding down since it's negative...
+		// The callee saved registers get pushed right after, so they are included when rounding.
+		newStackSize := fn.stackSize - calleeSavedSize
+		remainder := newStackSize % 16
+		if remainder != 0 {
+			newStackSize = (newStackSize/16)*16 - 16
+		}
+		fn.stackSize = newStackSize + calleeSavedSize
 	}
-	fn.stackSize = newStackSize
 
 	// insert instruction to allocate space on the stack
-	src := Immediate_Int_Operand_Asm{strconv.FormatInt(int64(-fn.stackSize), 10)}
-	dst := Register_Operand_Asm{SP_REGISTER_ASM}
-	firstInstr := Binary_Instruction_Asm{binOp: SUB_OPERATOR_ASM, asmTyp: QUADWORD_ASM_TYPE, src: &src, dst: &dst}
-	instructions := []Instruction_Asm{&firstInstr}
-	fn.instructions = append(instructions, fn.instructions...)
+	instructions := []Instruction_Asm{}
+	if fn.stackSize != 0 {
+		src := Immediate_Int_Operand_Asm{strconv.FormatInt(int64(-fn.stackSize), 10)}
+		dst := Register_Operand_Asm{SP_REGISTER_ASM}
+		firstInstr := Binary_Instruction_Asm{binOp: SUB_OPERATOR_ASM, asmTyp: QUADWORD_ASM_TYPE, src: &src, dst: &dst}
+		instructions = append(instructions, &firstInstr)
+	}
+
+	// save the callee saved registers, and restore them in reverse order before every return or tail call
+	for _, reg := range fn.calleeSavedRegisters {
+		instructions = append(instructions, &Push_Instruction_Asm{&Register_Operand_Asm{reg}})
+	}
+	for _, instr := range fn.instructions {
+		ret, isRet := instr.(*Ret_Instruction_Asm)
+		tailCall, isTailCall := instr.(*Tail_Call_Function_Asm)
+		if isRet || isTailCall {
+			for index := len(fn.calleeSavedRegisters) - 1; index >= 0; index-- {
+				instructions = append(instructions, &Pop_Instruction_Asm{fn.calleeSavedRegisters[index]})
+			}
+			// without a frame pointer the stack is put back by hand instead of from RBP
+			if fn.omitFramePointer && (fn.stackSize != 0) {
+				src := Immediate_Int_Operand_Asm{strconv.FormatInt(int64(-fn.stackSize), 10)}
+				dst := Register_Operand_Asm{SP_REGISTER_ASM}
+				restore := Binary_Instruction_Asm{binOp: ADD_OPERATOR_ASM, asmTyp: QUADWORD_ASM_TYPE, src: &src, dst: &dst}
+				instructions = append(instructions, &restore)
+			}
+		}
+		if isRet {
+			ret.omitFramePointer = fn.omitFramePointer
+		}
+		if isTailCall {
+			tailCall.omitFramePointer = fn.omitFramePointer
+		}
+		instructions = append(instructions, instr)
+	}
+	fn.instructions = instructions
 
 	// rewrite invalid instructions, they can't have both operands be memory addresses
 	instructions = []Instruction_Asm{}
@@ -1296,6 +1525,9 @@ func (fn *Function_Asm) fixInvalidInstr() {
 		case *Compare_Instruction_Asm:
 			newInstrs := convertedInstr.fixInvalidInstr()
 			instructions = append(instructions, newInstrs...)
+		case *Conditional_Move_Instruction_Asm:
+			newInstrs := convertedInstr.fixInvalidInstr()
+			instructions = append(instructions, newInstrs...)
 		case *Push_Instruction_Asm:
 			newInstrs := convertedInstr.fixInvalidInstr()
 			instructions = append(instructions, newInstrs...)
@@ -1406,8 +1638,8 @@ func (instr *Lea_Instruction_Asm) fixInvalidInstr() []Instruction_Asm {
 
 	if !dstIsReg {
 		r11 := Register_Operand_Asm{R11_REGISTER_ASM}
-		lea := Lea_Instruction_Asm{src: instr.src, dst: &r11}
-		mov := Mov_Instruction_Asm{asmTyp: QUADWORD_ASM_TYPE, src: &r11, dst: instr.dst}
+		lea := Lea_Instruction_Asm{asmTyp: instr.asmTyp, src: instr.src, dst: &r11}
+		mov := Mov_Instruction_Asm{asmTyp: instr.asmTyp, src: &r11, dst: instr.dst}
 		return []Instruction_Asm{&lea, &mov}
 	}
 
@@ -1578,6 +1810,30 @@ func (instr *Compare_Instruction_Asm) fixInvalidInstr() []Instruction_Asm {
 
 /////////////////////////////////////////////////////////////////////////////////
 
+// cmov can't take an immediate value and the dst has to be a register
+func (instr *Conditional_Move_Instruction_Asm) fixInvalidInstr() []Instruction_Asm {
+	instructions := []Instruction_Asm{}
+	src := instr.src
+	_, srcIsConstant := instr.src.(*Immediate_Int_Operand_Asm)
+	if srcIsConstant {
+		r10 := Register_Operand_Asm{R10_REGISTER_ASM}
+		instructions = append(instructions, &Mov_Instruction_Asm{asmTyp: instr.asmTyp, src: instr.src, dst: &r10})
+		src = &r10
+	}
+
+	_, dstIsReg := instr.dst.(*Register_Operand_Asm)
+	if dstIsReg {
+		return append(instructions, &Conditional_Move_Instruction_Asm{code: instr.code, asmTyp: instr.asmTyp, src: src, dst: instr.dst})
+	}
+	r11 := Register_Operand_Asm{R11_REGISTER_ASM}
+	load := Mov_Instruction_Asm{asmTyp: instr.asmTyp, src: instr.dst, dst: &r11}
+	cmov := Conditional_Move_Instruction_Asm{code: instr.code, asmTyp: instr.asmTyp, src: src, dst: &r11}
+	store := Mov_Instruction_Asm{asmTyp: instr.asmTyp, src: &r11, dst: instr.dst}
+	return append(instructions, &load, &cmov, &store)
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
 func (instr *Push_Instruction_Asm) fixInvalidInstr() []Instruction_Asm {
 	// page 268 of the book, can't push immediate values that are too big, need to put the values in a register first
 	if opIsBigImm(instr.op) {
@@ -28,12 +28,9 @@ func truncateDoubleToInteger(input string) string {
 
 func roundDouble(input string) string {
 	value, err := strconv.ParseFloat(input, 64)
-	if err != nil {
-		if (value == math.Inf(1)) || (value == math.Inf(-1)) {
-			fmt.Println("Warning:", input, "rounded to", value)
-		} else {
-			fail("Could not parse double:", err.Error())
-		}
+	if (err != nil) && !math.IsInf(value, 0) {
+		// out of range values are rounded to infinity, the parser warns about them
+		fail("Could not parse double:", err.Error())
 	}
 	// Go does the rounding for us when we call ParseFloat and then FormatFloat
 	result := strconv.FormatFloat(value, 'G', 24, 64)
@@ -75,8 +72,10 @@ func (fn *Function_Asm) topLevelEmitAsm(file *os.File) {
 	file.WriteString(string(fn.name) + ":\n")
 
 	// include the function prologue instructions for preparing the stack
-	file.WriteString("\t" + "pushq" + "\t" + "%rbp" + "\n")
-	file.WriteString("\t" + "movq" + "\t" + "%rsp, %rbp" + "\n")
+	if !fn.omitFramePointer {
+		file.WriteString("\t" + "pushq" + "\t" + "%rbp" + "\n")
+		file.WriteString("\t" + "movq" + "\t" + "%rsp, %rbp" + "\n")
+	}
 
 	for _, instr := range fn.instructions {
 		instr.instrEmitAsm(file)
@@ -157,8 +156,9 @@ func (instr *Move_Zero_Extend_Instruction_Asm) instrEmitAsm(file *os.File) {
 /////////////////////////////////////////////////////////////////////////////////
 
 func (instr *Lea_Instruction_Asm) instrEmitAsm(file *os.File) {
-	file.WriteString("\t" + "leaq" + "\t" + instr.src.getOperandString(QUADWORD_ASM_TYPE) + ", " +
-		instr.dst.getOperandString(QUADWORD_ASM_TYPE) + "\n")
+	// the address is always 64 bits, but the result can be 32 bits
+	file.WriteString("\t" + "lea" + getInstructionSuffix(instr.asmTyp) + "\t" + instr.src.getOperandString(QUADWORD_ASM_TYPE) + ", " +
+		instr.dst.getOperandString(instr.asmTyp) + "\n")
 }
 
 /////////////////////////////////////////////////////////////////////////////////
@@ -216,6 +216,16 @@ func (instr *Divide_Instruction_Asm) instrEmitAsm(file *os.File) {
 
 /////////////////////////////////////////////////////////////////////////////////
 
+func (instr *Multiply_High_Instruction_Asm) instrEmitAsm(file *os.File) {
+	mulStr := "mul"
+	if instr.signed {
+		mulStr = "imul"
+	}
+	file.WriteString("\t" + mulStr + getInstructionSuffix(instr.asmTyp) + "\t" + instr.src.getOperandString(instr.asmTyp) + "\n")
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
 func (instr *CDQ_Sign_Extend_Instruction_Asm) instrEmitAsm(file *os.File) {
 	if instr.asmTyp == QUADWORD_ASM_TYPE {
 		file.WriteString("\t" + "cqo" + "\n")
@@ -244,6 +254,14 @@ func (instr *Set_Conditional_Instruction_Asm) instrEmitAsm(file *os.File) {
 
 /////////////////////////////////////////////////////////////////////////////////
 
+// no suffix since the dst is always a register, and a suffix would make cmovl ambiguous
+func (instr *Conditional_Move_Instruction_Asm) instrEmitAsm(file *os.File) {
+	file.WriteString("\t" + "cmov" + getConditionalCodeString(instr.code) + "\t" + instr.src.getOperandString(instr.asmTyp) + ", " +
+		instr.dst.getOperandString(instr.asmTyp) + "\n")
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
 func (instr *Label_Instruction_Asm) instrEmitAsm(file *os.File) {
 	file.WriteString(".L" + instr.name + ":" + "\n")
 }
@@ -256,15 +274,38 @@ func (instr *Push_Instruction_Asm) instrEmitAsm(file *os.File) {
 
 /////////////////////////////////////////////////////////////////////////////////
 
+func (instr *Pop_Instruction_Asm) instrEmitAsm(file *os.File) {
+	file.WriteString("\t" + "popq" + "\t" + "%" + getRegisterString(instr.reg, QUADWORD_ASM_TYPE) + "\n")
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
 func (instr *Call_Function_Asm) instrEmitAsm(file *os.File) {
+	file.WriteString("\t" + "call" + "\t" + getFunctionTarget(instr.name) + "\n")
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func (instr *Tail_Call_Function_Asm) instrEmitAsm(file *os.File) {
+	// same epilogue as a return, the function we jump to returns to our caller
+	if !instr.omitFramePointer {
+		file.WriteString("\t" + "movq" + "\t" + "%rbp, %rsp" + "\n")
+		file.WriteString("\t" + "popq" + "\t" + "%rbp" + "\n")
+	}
+	file.WriteString("\t" + "jmp" + "\t" + getFunctionTarget(instr.name) + "\n")
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func getFunctionTarget(name string) string {
 	// need to find if the function we are calling is in the current binary object file or somewhere else
-	entry, inTable := symbolTableBackend[instr.name]
+	entry, inTable := symbolTableBackend[name]
 	if inTable && entry.defined {
 		// It must be in the table and have a definition to use this calling method. If it's in the table
 		// but not defined then it's just a function declaration so the definition is elsewhere.
-		file.WriteString("\t" + "call" + "\t" + instr.name + "\n")
+		return name
 	} else {
-		file.WriteString("\t" + "call" + "\t" + instr.name + "@PLT" + "\n")
+		return name + "@PLT"
 	}
 }
 
@@ -272,8 +313,10 @@ func (instr *Call_Function_Asm) instrEmitAsm(file *os.File) {
 
 func (instr *Ret_Instruction_Asm) instrEmitAsm(file *os.File) {
 	// include the function epilogue instructions for restoring the stack
-	file.WriteString("\t" + "movq" + "\t" + "%rbp, %rsp" + "\n")
-	file.WriteString("\t" + "popq" + "\t" + "%rbp" + "\n")
+	if !instr.omitFramePointer {
+		file.WriteString("\t" + "movq" + "\t" + "%rbp, %rsp" + "\n")
+		file.WriteString("\t" + "popq" + "\t" + "%rbp" + "\n")
+	}
 	file.WriteString("\t" + "ret" + "\n")
 }
 
@@ -324,6 +367,12 @@ func getBinaryOperatorString(binOp BinaryOperatorTypeAsm, asmTyp AssemblyTypeEnu
 		} else {
 			return "xor" + getInstructionSuffix(asmTyp)
 		}
+	case SHIFT_LEFT_OPERATOR_ASM:
+		return "shl" + getInstructionSuffix(asmTyp)
+	case SHIFT_RIGHT_ARITHMETIC_OPERATOR_ASM:
+		return "sar" + getInstructionSuffix(asmTyp)
+	case SHIFT_RIGHT_LOGICAL_OPERATOR_ASM:
+		return "shr" + getInstructionSuffix(asmTyp)
 	default:
 		fail("unknown binary operator")
 	}
@@ -366,6 +415,13 @@ func (op *Data_Operand_Asm) getOperandString(asmTyp AssemblyTypeEnum) string {
 	return op.name + "(%rip)"
 }
 
+/////////////////////////////////////////////////////////////////////////////////
+
+func (op *Indexed_Operand_Asm) getOperandString(asmTyp AssemblyTypeEnum) string {
+	return "(%" + getRegisterString(op.base, QUADWORD_ASM_TYPE) + ", %" + getRegisterString(op.index, QUADWORD_ASM_TYPE) + ", " +
+		strconv.FormatInt(int64(op.scale), 10) + ")"
+}
+
 //###############################################################################
 //###############################################################################
 //###############################################################################
@@ -439,6 +495,16 @@ func getRegisterString(reg RegisterTypeAsm, asmTyp AssemblyTypeEnum) string {
 		return "r10" + getScratchRegisterSuffix(asmTyp)
 	case R11_REGISTER_ASM:
 		return "r11" + getScratchRegisterSuffix(asmTyp)
+	case BX_REGISTER_ASM:
+		return getRegisterPrefix(asmTyp) + "b" + getXRegisterSuffix(asmTyp)
+	case R12_REGISTER_ASM:
+		return "r12" + getScratchRegisterSuffix(asmTyp)
+	case R13_REGISTER_ASM:
+		return "r13" + getScratchRegisterSuffix(asmTyp)
+	case R14_REGISTER_ASM:
+		return "r14" + getScratchRegisterSuffix(asmTyp)
+	case R15_REGISTER_ASM:
+		return "r15" + getScratchRegisterSuffix(asmTyp)
 	case SP_REGISTER_ASM:
 		return "rsp"
 	case BP_REGISTER_ASM:
@@ -459,6 +525,18 @@ func getRegisterString(reg RegisterTypeAsm, asmTyp AssemblyTypeEnum) string {
 		return "xmm6"
 	case XMM7_REGISTER_ASM:
 		return "xmm7"
+	case XMM8_REGISTER_ASM:
+		return "xmm8"
+	case XMM9_REGISTER_ASM:
+		return "xmm9"
+	case XMM10_REGISTER_ASM:
+		return "xmm10"
+	case XMM11_REGISTER_ASM:
+		return "xmm11"
+	case XMM12_REGISTER_ASM:
+		return "xmm12"
+	case XMM13_REGISTER_ASM:
+		return "xmm13"
 	case XMM14_REGISTER_ASM:
 		return "xmm14"
 	case XMM15_REGISTER_ASM:
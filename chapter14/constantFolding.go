@@ -0,0 +1,400 @@
+package main
+
+import (
+	"math"
+	"strconv"
+)
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+func foldConstants(body []Instruction_Tacky) []Instruction_Tacky {
+	newBody := []Instruction_Tacky{}
+
+	for _, instr := range body {
+		switch convertedInstr := instr.(type) {
+		case *Jump_If_Zero_Instruction_Tacky:
+			constant, isConst := convertedInstr.condition.(*Constant_Value_Tacky)
+			if !isConst {
+				newBody = append(newBody, instr)
+			} else if isZeroConstant(constant) {
+				newBody = append(newBody, &Jump_Instruction_Tacky{target: convertedInstr.target})
+			}
+			// otherwise the jump is never taken so it's removed
+		case *Jump_If_Not_Zero_Instruction_Tacky:
+			constant, isConst := convertedInstr.condition.(*Constant_Value_Tacky)
+			if !isConst {
+				newBody = append(newBody, instr)
+			} else if !isZeroConstant(constant) {
+				newBody = append(newBody, &Jump_Instruction_Tacky{target: convertedInstr.target})
+			}
+		case *Jump_If_Compare_Instruction_Tacky:
+			taken, ok := foldJumpIfCompare(convertedInstr)
+			if !ok {
+				newBody = append(newBody, instr)
+			} else if taken {
+				newBody = append(newBody, &Jump_Instruction_Tacky{target: convertedInstr.target})
+			}
+		case *Conditional_Copy_Instruction_Tacky:
+			src, ok := foldConditionalCopy(convertedInstr)
+			if ok {
+				newBody = append(newBody, &Copy_Instruction_Tacky{src: src, dst: convertedInstr.dst})
+			} else {
+				newBody = append(newBody, instr)
+			}
+		default:
+			folded, dst, ok := foldInstruction(instr)
+			if ok {
+				newBody = append(newBody, &Copy_Instruction_Tacky{src: folded, dst: dst})
+			} else {
+				newBody = append(newBody, instr)
+			}
+		}
+	}
+
+	return newBody
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// returns the value the instruction computes if all of its sources are constants, and the dst to copy it to.
+// Anything that is undefined behavior at run time, like dividing by zero, is left alone.
+func foldInstruction(instr Instruction_Tacky) (*Constant_Value_Tacky, Value_Tacky, bool) {
+	switch convertedInstr := instr.(type) {
+	case *Unary_Instruction_Tacky:
+		src, isConst := convertedInstr.src.(*Constant_Value_Tacky)
+		if isConst {
+			result, ok := foldUnary(convertedInstr.unOp, src, convertedInstr.dst.getDataType())
+			return result, convertedInstr.dst, ok
+		}
+	case *Binary_Instruction_Tacky:
+		src1, isConst1 := convertedInstr.src1.(*Constant_Value_Tacky)
+		src2, isConst2 := convertedInstr.src2.(*Constant_Value_Tacky)
+		if isConst1 && isConst2 {
+			result, ok := foldBinary(convertedInstr.binOp, src1, src2, convertedInstr.dst.getDataType())
+			return result, convertedInstr.dst, ok
+		}
+	case *Sign_Extend_Instruction_Tacky:
+		src, isConst := convertedInstr.src.(*Constant_Value_Tacky)
+		if isConst {
+			// getIntegerBits already sign extends the signed types
+			return makeIntegerConstant(convertedInstr.dst.getDataType(), getIntegerBits(src)), convertedInstr.dst, true
+		}
+	case *Zero_Extend_Instruction_Tacky, *Truncate_Instruction_Tacky:
+		src := getInstructionUses(instr)[0]
+		constant, isConst := src.(*Constant_Value_Tacky)
+		if isConst {
+			// the unsigned types are already zero extended, and makeIntegerConstant truncates to the new size
+			dst := getInstructionDst(instr)
+			return makeIntegerConstant(dst.getDataType(), getIntegerBits(constant)), dst, true
+		}
+	case *Int_To_Double_Instruction_Tacky:
+		src, isConst := convertedInstr.src.(*Constant_Value_Tacky)
+		if isConst {
+			return makeDoubleConstant(float64(int64(getIntegerBits(src)))), convertedInstr.dst, true
+		}
+	case *UInt_To_Double_Instruction_Tacky:
+		src, isConst := convertedInstr.src.(*Constant_Value_Tacky)
+		if isConst {
+			return makeDoubleConstant(float64(getIntegerBits(src))), convertedInstr.dst, true
+		}
+	case *Double_To_Int_Instruction_Tacky, *Double_To_UInt_Instruction_Tacky:
+		src := getInstructionUses(instr)[0]
+		constant, isConst := src.(*Constant_Value_Tacky)
+		if isConst {
+			dst := getInstructionDst(instr)
+			result, ok := foldDoubleToInteger(getDoubleValue(constant), dst.getDataType())
+			return result, dst, ok
+		}
+	}
+
+	return nil, nil, false
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// returns whether the jump is taken when both sides of the comparison are constants
+func foldJumpIfCompare(instr *Jump_If_Compare_Instruction_Tacky) (bool, bool) {
+	src1, isConst1 := instr.src1.(*Constant_Value_Tacky)
+	src2, isConst2 := instr.src2.(*Constant_Value_Tacky)
+	if !isConst1 || !isConst2 {
+		return false, false
+	}
+	result, ok := foldBinary(instr.binOp, src1, src2, INT_TYPE)
+	if !ok {
+		return false, false
+	}
+	return !isZeroConstant(result), true
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// returns the src that a conditional copy picks when both sides of the comparison are constants
+func foldConditionalCopy(instr *Conditional_Copy_Instruction_Tacky) (Value_Tacky, bool) {
+	left, isConst1 := instr.left.(*Constant_Value_Tacky)
+	right, isConst2 := instr.right.(*Constant_Value_Tacky)
+	if !isConst1 || !isConst2 {
+		return nil, false
+	}
+	result, ok := foldBinary(instr.binOp, left, right, INT_TYPE)
+	if !ok {
+		return nil, false
+	}
+	if isZeroConstant(result) {
+		return instr.src2, true
+	}
+	return instr.src1, true
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func foldUnary(unOp UnaryOperatorType, src *Constant_Value_Tacky, dstTyp DataTypeEnum) (*Constant_Value_Tacky, bool) {
+	if src.typ == DOUBLE_TYPE {
+		value := getDoubleValue(src)
+		switch unOp {
+		case NEGATE_OPERATOR:
+			return makeDoubleConstant(-value), true
+		case NOT_OPERATOR:
+			return makeBoolConstant(value == 0), true
+		}
+		return nil, false
+	}
+
+	bits := getIntegerBits(src)
+	switch unOp {
+	case NEGATE_OPERATOR:
+		// two's complement negation, the same as what the neg instruction does
+		return makeIntegerConstant(dstTyp, -bits), true
+	case COMPLEMENT_OPERATOR:
+		return makeIntegerConstant(dstTyp, ^bits), true
+	case NOT_OPERATOR:
+		return makeBoolConstant(bits == 0), true
+	}
+	return nil, false
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func foldBinary(binOp BinaryOperatorType, src1 *Constant_Value_Tacky, src2 *Constant_Value_Tacky,
+	dstTyp DataTypeEnum) (*Constant_Value_Tacky, bool) {
+
+	if src1.typ == DOUBLE_TYPE {
+		return foldDoubleBinary(binOp, getDoubleValue(src1), getDoubleValue(src2))
+	}
+
+	// The operands have the same type after type checking. Signed values are sign extended to 64 bits and unsigned
+	// values are zero extended, so doing the math with 64 bits and then truncating to the size of the type gives the
+	// same result as doing it at the size of the type.
+	bits1 := getIntegerBits(src1)
+	bits2 := getIntegerBits(src2)
+	if isSigned(src1.typ) {
+		a, b := int64(bits1), int64(bits2)
+		switch binOp {
+		case ADD_OPERATOR:
+			return makeIntegerConstant(dstTyp, uint64(a+b)), true
+		case SUBTRACT_OPERATOR:
+			return makeIntegerConstant(dstTyp, uint64(a-b)), true
+		case MULTIPLY_OPERATOR:
+			return makeIntegerConstant(dstTyp, uint64(a*b)), true
+		case DIVIDE_OPERATOR, REMAINDER_OPERATOR:
+			// dividing by zero or the most negative value divided by -1 would crash at run time, keep that behavior
+			if (b == 0) || ((b == -1) && isMostNegative(a, src1.typ)) {
+				return nil, false
+			}
+			if binOp == DIVIDE_OPERATOR {
+				return makeIntegerConstant(dstTyp, uint64(a/b)), true
+			}
+			return makeIntegerConstant(dstTyp, uint64(a%b)), true
+		case IS_EQUAL_OPERATOR:
+			return makeBoolConstant(a == b), true
+		case NOT_EQUAL_OPERATOR:
+			return makeBoolConstant(a != b), true
+		case LESS_THAN_OPERATOR:
+			return makeBoolConstant(a < b), true
+		case LESS_OR_EQUAL_OPERATOR:
+			return makeBoolConstant(a <= b), true
+		case GREATER_THAN_OPERATOR:
+			return makeBoolConstant(a > b), true
+		case GREATER_OR_EQUAL_OPERATOR:
+			return makeBoolConstant(a >= b), true
+		}
+	} else {
+		a, b := bits1, bits2
+		switch binOp {
+		case ADD_OPERATOR:
+			return makeIntegerConstant(dstTyp, a+b), true
+		case SUBTRACT_OPERATOR:
+			return makeIntegerConstant(dstTyp, a-b), true
+		case MULTIPLY_OPERATOR:
+			return makeIntegerConstant(dstTyp, a*b), true
+		case DIVIDE_OPERATOR, REMAINDER_OPERATOR:
+			if b == 0 {
+				return nil, false
+			}
+			if binOp == DIVIDE_OPERATOR {
+				return makeIntegerConstant(dstTyp, a/b), true
+			}
+			return makeIntegerConstant(dstTyp, a%b), true
+		case IS_EQUAL_OPERATOR:
+			return makeBoolConstant(a == b), true
+		case NOT_EQUAL_OPERATOR:
+			return makeBoolConstant(a != b), true
+		case LESS_THAN_OPERATOR:
+			return makeBoolConstant(a < b), true
+		case LESS_OR_EQUAL_OPERATOR:
+			return makeBoolConstant(a <= b), true
+		case GREATER_THAN_OPERATOR:
+			return makeBoolConstant(a > b), true
+		case GREATER_OR_EQUAL_OPERATOR:
+			return makeBoolConstant(a >= b), true
+		}
+	}
+
+	return nil, false
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func foldDoubleBinary(binOp BinaryOperatorType, a float64, b float64) (*Constant_Value_Tacky, bool) {
+	// Go follows IEEE 754 the same way the SSE instructions do, including comparisons with NaN
+	var result float64
+	switch binOp {
+	case ADD_OPERATOR:
+		result = a + b
+	case SUBTRACT_OPERATOR:
+		result = a - b
+	case MULTIPLY_OPERATOR:
+		result = a * b
+	case DIVIDE_OPERATOR:
+		result = a / b
+	case IS_EQUAL_OPERATOR:
+		return makeBoolConstant(a == b), true
+	case NOT_EQUAL_OPERATOR:
+		return makeBoolConstant(a != b), true
+	case LESS_THAN_OPERATOR:
+		return makeBoolConstant(a < b), true
+	case LESS_OR_EQUAL_OPERATOR:
+		return makeBoolConstant(a <= b), true
+	case GREATER_THAN_OPERATOR:
+		return makeBoolConstant(a > b), true
+	case GREATER_OR_EQUAL_OPERATOR:
+		return makeBoolConstant(a >= b), true
+	default:
+		return nil, false
+	}
+
+	// the assembler can't read infinity or NaN in a .double directive, so leave those for run time
+	if math.IsInf(result, 0) || math.IsNaN(result) {
+		return nil, false
+	}
+	return makeDoubleConstant(result), true
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func foldDoubleToInteger(value float64, dstTyp DataTypeEnum) (*Constant_Value_Tacky, bool) {
+	// converting a double that is out of range for the integer type is undefined behavior, so only fold the ones in range
+	value = math.Trunc(value)
+	switch dstTyp {
+	case INT_TYPE:
+		if (value >= math.MinInt32) && (value <= math.MaxInt32) {
+			return makeIntegerConstant(dstTyp, uint64(int64(value))), true
+		}
+	case LONG_TYPE:
+		if (value >= math.MinInt64) && (value < math.MaxInt64) {
+			return makeIntegerConstant(dstTyp, uint64(int64(value))), true
+		}
+	case UNSIGNED_INT_TYPE:
+		if (value >= 0) && (value <= math.MaxUint32) {
+			return makeIntegerConstant(dstTyp, uint64(value)), true
+		}
+	case UNSIGNED_LONG_TYPE:
+		if (value >= 0) && (value < math.MaxUint64) {
+			return makeIntegerConstant(dstTyp, uint64(value)), true
+		}
+	}
+	return nil, false
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// the value as 64 bits, sign extended for the signed types and zero extended for the unsigned types
+func getIntegerBits(constant *Constant_Value_Tacky) uint64 {
+	if isSigned(constant.typ) {
+		value, err := strconv.ParseInt(constant.value, 10, 64)
+		if err != nil {
+			fail("Could not parse integer constant:", err.Error())
+		}
+		if constant.typ == INT_TYPE {
+			value = int64(int32(value))
+		}
+		return uint64(value)
+	}
+
+	value, err := strconv.ParseUint(constant.value, 10, 64)
+	if err != nil {
+		fail("Could not parse unsigned integer constant:", err.Error())
+	}
+	if constant.typ == UNSIGNED_INT_TYPE {
+		value = uint64(uint32(value))
+	}
+	return value
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func getDoubleValue(constant *Constant_Value_Tacky) float64 {
+	value, err := strconv.ParseFloat(constant.value, 64)
+	if err != nil {
+		fail("Could not parse double constant:", err.Error())
+	}
+	return value
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// truncates the bits to the size of the type, this is how wrap around works for every integer type
+func makeIntegerConstant(typ DataTypeEnum, bits uint64) *Constant_Value_Tacky {
+	value := ""
+	switch typ {
+	case INT_TYPE:
+		value = strconv.FormatInt(int64(int32(bits)), 10)
+	case LONG_TYPE:
+		value = strconv.FormatInt(int64(bits), 10)
+	case UNSIGNED_INT_TYPE:
+		value = strconv.FormatUint(uint64(uint32(bits)), 10)
+	default:
+		// unsigned long and pointers
+		value = strconv.FormatUint(bits, 10)
+	}
+	return &Constant_Value_Tacky{typ: typ, value: value}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func makeDoubleConstant(value float64) *Constant_Value_Tacky {
+	// same format that roundDouble uses, so that identical doubles share a static constant
+	return &Constant_Value_Tacky{typ: DOUBLE_TYPE, value: strconv.FormatFloat(value, 'G', 24, 64)}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// comparisons and logical not always have a result type of int
+func makeBoolConstant(value bool) *Constant_Value_Tacky {
+	if value {
+		return &Constant_Value_Tacky{typ: INT_TYPE, value: "1"}
+	}
+	return &Constant_Value_Tacky{typ: INT_TYPE, value: "0"}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func isMostNegative(value int64, typ DataTypeEnum) bool {
+	if typ == INT_TYPE {
+		return value == math.MinInt32
+	}
+	return value == math.MinInt64
+}
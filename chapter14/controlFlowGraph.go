@@ -0,0 +1,197 @@
+package main
+
+import "strconv"
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+type Basic_Block struct {
+	id           int
+	instructions []Instruction_Tacky
+	preds        []*Basic_Block
+	succs        []*Basic_Block
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+type Control_Flow_Graph struct {
+	entry *Basic_Block
+	exit  *Basic_Block
+	// in the same order as the original instructions, the entry and exit blocks are not in this list
+	blocks []*Basic_Block
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+func makeControlFlowGraph(instructions []Instruction_Tacky) *Control_Flow_Graph {
+	cfg := Control_Flow_Graph{entry: &Basic_Block{id: 0}}
+	cfg.blocks = partitionIntoBlocks(instructions)
+	cfg.exit = &Basic_Block{id: len(cfg.blocks) + 1}
+	cfg.addEdges()
+	return &cfg
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// Finds the edges from the jumps at the end of each block, the old edges are thrown away first. A pass that changes
+// the jumps while the blocks are kept (like SCCP in SSA) can call this again. Instructions can come before a block's
+// label, so the label isn't always the first instruction.
+func (cfg *Control_Flow_Graph) addEdges() {
+	cfg.entry.succs = nil
+	cfg.exit.preds = nil
+	labelToBlock := make(map[string]*Basic_Block)
+	for _, block := range cfg.blocks {
+		block.preds = nil
+		block.succs = nil
+		for _, instr := range block.instructions {
+			lbl, isLabel := instr.(*Label_Instruction_Tacky)
+			if isLabel {
+				labelToBlock[lbl.name] = block
+				break
+			}
+		}
+	}
+
+	if len(cfg.blocks) == 0 {
+		addEdge(cfg.entry, cfg.exit)
+		return
+	}
+	addEdge(cfg.entry, cfg.blocks[0])
+
+	for index, block := range cfg.blocks {
+		var nextBlock *Basic_Block
+		if (index + 1) < len(cfg.blocks) {
+			nextBlock = cfg.blocks[index+1]
+		} else {
+			nextBlock = cfg.exit
+		}
+
+		// a block can be left empty after its jump is removed, then it falls through
+		var lastInstr Instruction_Tacky
+		if len(block.instructions) > 0 {
+			lastInstr = block.instructions[len(block.instructions)-1]
+		}
+		switch convertedInstr := lastInstr.(type) {
+		case *Return_Instruction_Tacky:
+			addEdge(block, cfg.exit)
+		case *Jump_Instruction_Tacky:
+			addEdge(block, labelToBlock[convertedInstr.target])
+		case *Jump_If_Zero_Instruction_Tacky:
+			// a constant condition always goes the same way, ex: while (1) { ... }
+			constant, isConst := convertedInstr.condition.(*Constant_Value_Tacky)
+			if !isConst || isZeroConstant(constant) {
+				addEdge(block, labelToBlock[convertedInstr.target])
+			}
+			if !isConst || !isZeroConstant(constant) {
+				addEdge(block, nextBlock)
+			}
+		case *Jump_If_Not_Zero_Instruction_Tacky:
+			constant, isConst := convertedInstr.condition.(*Constant_Value_Tacky)
+			if !isConst || !isZeroConstant(constant) {
+				addEdge(block, labelToBlock[convertedInstr.target])
+			}
+			if !isConst || isZeroConstant(constant) {
+				addEdge(block, nextBlock)
+			}
+		case *Jump_If_Compare_Instruction_Tacky:
+			taken, isConst := foldJumpIfCompare(convertedInstr)
+			if !isConst || taken {
+				addEdge(block, labelToBlock[convertedInstr.target])
+			}
+			if !isConst || !taken {
+				addEdge(block, nextBlock)
+			}
+		default:
+			addEdge(block, nextBlock)
+		}
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// a basic block starts at a label and ends after a jump or return
+func partitionIntoBlocks(instructions []Instruction_Tacky) []*Basic_Block {
+	blocks := []*Basic_Block{}
+	current := []Instruction_Tacky{}
+
+	finishBlock := func() {
+		if len(current) > 0 {
+			blocks = append(blocks, &Basic_Block{id: len(blocks) + 1, instructions: current})
+			current = []Instruction_Tacky{}
+		}
+	}
+
+	for _, instr := range instructions {
+		switch instr.(type) {
+		case *Label_Instruction_Tacky:
+			finishBlock()
+			current = append(current, instr)
+		case *Jump_Instruction_Tacky, *Jump_If_Zero_Instruction_Tacky, *Jump_If_Not_Zero_Instruction_Tacky,
+			*Jump_If_Compare_Instruction_Tacky, *Return_Instruction_Tacky:
+			current = append(current, instr)
+			finishBlock()
+		default:
+			current = append(current, instr)
+		}
+	}
+	finishBlock()
+
+	return blocks
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func addEdge(from *Basic_Block, to *Basic_Block) {
+	if to == nil {
+		fail("Jump to a label that doesn't exist")
+	}
+
+	for _, succ := range from.succs {
+		if succ == to {
+			// both branches of a conditional jump can go to the same block
+			return
+		}
+	}
+	from.succs = append(from.succs, to)
+	to.preds = append(to.preds, from)
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func isZeroConstant(constant *Constant_Value_Tacky) bool {
+	// ParseFloat also works for all of the integer types since we only care about zero
+	value, err := strconv.ParseFloat(constant.value, 64)
+	return (err == nil) && (value == 0)
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func (cfg *Control_Flow_Graph) findReachableBlocks() map[*Basic_Block]bool {
+	reachable := make(map[*Basic_Block]bool)
+
+	stack := []*Basic_Block{cfg.entry}
+	for len(stack) > 0 {
+		block := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if reachable[block] {
+			continue
+		}
+		reachable[block] = true
+		stack = append(stack, block.succs...)
+	}
+
+	return reachable
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func (cfg *Control_Flow_Graph) toInstructions() []Instruction_Tacky {
+	instructions := []Instruction_Tacky{}
+	for _, block := range cfg.blocks {
+		instructions = append(instructions, block.instructions...)
+	}
+	return instructions
+}
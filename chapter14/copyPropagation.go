@@ -0,0 +1,310 @@
+package main
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// A copy dst = src kills every other copy to dst, so there is never more than one reaching copy for the same
+// dst. The copies are stored as dst name -> src.
+type Reaching_Copies map[string]Value_Tacky
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func propagateCopies(body []Instruction_Tacky) []Instruction_Tacky {
+	cfg := makeControlFlowGraph(body)
+	aliased := findAliasedVariables(body)
+	blockIn := findReachingCopies(cfg, aliased)
+
+	newBody := []Instruction_Tacky{}
+	for _, block := range cfg.blocks {
+		current := copyReachingCopies(blockIn[block])
+		for _, instr := range block.instructions {
+			// a copy that gets removed doesn't change anything, so it doesn't need to go through the transfer function
+			newInstr, keep := rewriteWithReachingCopies(instr, current)
+			if keep {
+				transferReachingCopies(newInstr, current, aliased)
+				newBody = append(newBody, newInstr)
+			}
+		}
+	}
+
+	return newBody
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// Static variables can be changed by any function we call, and a variable that has its address taken can be changed
+// through a pointer. Either way the copies they are part of have to be killed by calls and stores.
+func findAliasedVariables(body []Instruction_Tacky) map[string]bool {
+	aliased := make(map[string]bool)
+	for _, instr := range body {
+		getAddr, isGetAddr := instr.(*Get_Address_Instruction_Tacky)
+		if isGetAddr {
+			v, isVar := getAddr.src.(*Variable_Value_Tacky)
+			if isVar {
+				aliased[v.name] = true
+			}
+		}
+		values := []Value_Tacky{getInstructionDst(instr)}
+		values = append(values, getInstructionUses(instr)...)
+		for _, val := range values {
+			v, isVar := val.(*Variable_Value_Tacky)
+			if isVar && (symbolTable[v.name].attrs == STATIC_ATTRIBUTES) {
+				aliased[v.name] = true
+			}
+		}
+	}
+	return aliased
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// forward dataflow analysis, returns the copies that reach the start of each block
+func findReachingCopies(cfg *Control_Flow_Graph, aliased map[string]bool) map[*Basic_Block]Reaching_Copies {
+	blockIn := make(map[*Basic_Block]Reaching_Copies)
+	blockOut := make(map[*Basic_Block]Reaching_Copies)
+	blockOut[cfg.entry] = make(Reaching_Copies)
+
+	worklist := make([]*Basic_Block, len(cfg.blocks))
+	copy(worklist, cfg.blocks)
+	for len(worklist) > 0 {
+		block := worklist[0]
+		worklist = worklist[1:]
+
+		// The meet operator is intersection since a copy only reaches a point if it reaches it along every path.
+		// Blocks that haven't been visited yet are skipped, which is the same as them having every copy.
+		var in Reaching_Copies
+		for _, pred := range block.preds {
+			predOut, visited := blockOut[pred]
+			if !visited {
+				continue
+			}
+			if in == nil {
+				in = copyReachingCopies(predOut)
+				continue
+			}
+			for dst, src := range in {
+				predSrc, found := predOut[dst]
+				if !found || !isSameValue(src, predSrc) {
+					delete(in, dst)
+				}
+			}
+		}
+		if in == nil {
+			in = make(Reaching_Copies)
+		}
+		blockIn[block] = in
+
+		out := copyReachingCopies(in)
+		for _, instr := range block.instructions {
+			transferReachingCopies(instr, out, aliased)
+		}
+
+		oldOut, visited := blockOut[block]
+		if !visited || !isSameReachingCopies(out, oldOut) {
+			blockOut[block] = out
+			for _, succ := range block.succs {
+				if succ != cfg.exit {
+					worklist = append(worklist, succ)
+				}
+			}
+		}
+	}
+
+	return blockIn
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func transferReachingCopies(instr Instruction_Tacky, current Reaching_Copies, aliased map[string]bool) {
+	switch convertedInstr := instr.(type) {
+	case *Function_Call_Tacky, *Store_Instruction_Tacky:
+		// we don't know what the function or the pointer changes, so any aliased variable could be different now
+		for dst, src := range current {
+			srcVar, srcIsVar := src.(*Variable_Value_Tacky)
+			if aliased[dst] || (srcIsVar && aliased[srcVar.name]) {
+				delete(current, dst)
+			}
+		}
+	case *Copy_Instruction_Tacky:
+		dst := convertedInstr.dst.(*Variable_Value_Tacky)
+		killCopiesOf(dst.name, current)
+
+		srcVar, srcIsVar := convertedInstr.src.(*Variable_Value_Tacky)
+		if srcIsVar && (srcVar.name == dst.name) {
+			return
+		}
+		// a copy between signed and unsigned variables reinterprets the bits, so replacing dst with src would
+		// change which instructions get used. Constants can be converted to the type of dst instead.
+		if srcIsVar && (srcVar.getDataType() != dst.getDataType()) {
+			return
+		}
+		current[dst.name] = convertedInstr.src
+		return
+	}
+
+	dst, isVar := getInstructionDst(instr).(*Variable_Value_Tacky)
+	if isVar {
+		killCopiesOf(dst.name, current)
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// when a variable gets a new value, every copy to it or from it is out of date
+func killCopiesOf(name string, current Reaching_Copies) {
+	for dst, src := range current {
+		srcVar, srcIsVar := src.(*Variable_Value_Tacky)
+		if (dst == name) || (srcIsVar && (srcVar.name == name)) {
+			delete(current, dst)
+		}
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// Replaces the values the instruction reads with the src of the copies that reach it. Returns false if the instruction
+// is a copy that is already known to be true, so it can be removed.
+func rewriteWithReachingCopies(instr Instruction_Tacky, current Reaching_Copies) (Instruction_Tacky, bool) {
+	copyInstr, isCopy := instr.(*Copy_Instruction_Tacky)
+	if isCopy {
+		dst := copyInstr.dst.(*Variable_Value_Tacky)
+		srcVar, srcIsVar := copyInstr.src.(*Variable_Value_Tacky)
+		if srcIsVar && (srcVar.name == dst.name) {
+			return instr, false
+		}
+		// both x = y and y = x mean that x and y already have the same value
+		existing, found := current[dst.name]
+		if found && isSameValue(existing, copyInstr.src) {
+			return instr, false
+		}
+		if srcIsVar {
+			existing, found = current[srcVar.name]
+			if found && isSameValue(existing, dst) {
+				return instr, false
+			}
+		}
+	}
+
+	replace := func(val Value_Tacky) Value_Tacky {
+		v, isVar := val.(*Variable_Value_Tacky)
+		if !isVar {
+			return val
+		}
+		src, found := current[v.name]
+		if !found {
+			return val
+		}
+		constant, isConst := src.(*Constant_Value_Tacky)
+		if isConst && (constant.typ != v.getDataType()) {
+			return makeIntegerConstant(v.getDataType(), getIntegerBits(constant))
+		}
+		return src
+	}
+
+	return replaceInstructionUses(instr, replace), true
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// returns a new instruction with each value that is read passed through replace, or the same instruction if nothing changed
+func replaceInstructionUses(instr Instruction_Tacky, replace func(Value_Tacky) Value_Tacky) Instruction_Tacky {
+	uses := getInstructionUses(instr)
+	changed := false
+	newUses := make([]Value_Tacky, len(uses))
+	for index, _ := range uses {
+		newUses[index] = replace(uses[index])
+		if newUses[index] != uses[index] {
+			changed = true
+		}
+	}
+	if !changed {
+		return instr
+	}
+
+	switch convertedInstr := instr.(type) {
+	case *Return_Instruction_Tacky:
+		return &Return_Instruction_Tacky{val: newUses[0]}
+	case *Sign_Extend_Instruction_Tacky:
+		return &Sign_Extend_Instruction_Tacky{src: newUses[0], dst: convertedInstr.dst}
+	case *Truncate_Instruction_Tacky:
+		return &Truncate_Instruction_Tacky{src: newUses[0], dst: convertedInstr.dst}
+	case *Zero_Extend_Instruction_Tacky:
+		return &Zero_Extend_Instruction_Tacky{src: newUses[0], dst: convertedInstr.dst}
+	case *Double_To_Int_Instruction_Tacky:
+		return &Double_To_Int_Instruction_Tacky{src: newUses[0], dst: convertedInstr.dst}
+	case *Double_To_UInt_Instruction_Tacky:
+		return &Double_To_UInt_Instruction_Tacky{src: newUses[0], dst: convertedInstr.dst}
+	case *Int_To_Double_Instruction_Tacky:
+		return &Int_To_Double_Instruction_Tacky{src: newUses[0], dst: convertedInstr.dst}
+	case *UInt_To_Double_Instruction_Tacky:
+		return &UInt_To_Double_Instruction_Tacky{src: newUses[0], dst: convertedInstr.dst}
+	case *Unary_Instruction_Tacky:
+		return &Unary_Instruction_Tacky{unOp: convertedInstr.unOp, src: newUses[0], dst: convertedInstr.dst}
+	case *Binary_Instruction_Tacky:
+		return &Binary_Instruction_Tacky{binOp: convertedInstr.binOp, src1: newUses[0], src2: newUses[1], dst: convertedInstr.dst}
+	case *Copy_Instruction_Tacky:
+		return &Copy_Instruction_Tacky{src: newUses[0], dst: convertedInstr.dst}
+	case *Conditional_Copy_Instruction_Tacky:
+		return &Conditional_Copy_Instruction_Tacky{binOp: convertedInstr.binOp, left: newUses[0], right: newUses[1],
+			src1: newUses[2], src2: newUses[3], dst: convertedInstr.dst}
+	case *Load_Instruction_Tacky:
+		return &Load_Instruction_Tacky{srcPtr: newUses[0], dst: convertedInstr.dst}
+	case *Store_Instruction_Tacky:
+		return &Store_Instruction_Tacky{src: newUses[0], dstPtr: newUses[1]}
+	case *Jump_If_Zero_Instruction_Tacky:
+		return &Jump_If_Zero_Instruction_Tacky{condition: newUses[0], target: convertedInstr.target}
+	case *Jump_If_Not_Zero_Instruction_Tacky:
+		return &Jump_If_Not_Zero_Instruction_Tacky{condition: newUses[0], target: convertedInstr.target}
+	case *Jump_If_Compare_Instruction_Tacky:
+		return &Jump_If_Compare_Instruction_Tacky{binOp: convertedInstr.binOp, src1: newUses[0], src2: newUses[1], target: convertedInstr.target}
+	case *Function_Call_Tacky:
+		return &Function_Call_Tacky{funcName: convertedInstr.funcName, args: newUses, returnVal: convertedInstr.returnVal}
+	case *Phi_Instruction_Tacky:
+		args := []Phi_Argument{}
+		for index, arg := range convertedInstr.args {
+			args = append(args, Phi_Argument{pred: arg.pred, val: newUses[index]})
+		}
+		return &Phi_Instruction_Tacky{dst: convertedInstr.dst, args: args}
+	}
+	return instr
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func isSameValue(a Value_Tacky, b Value_Tacky) bool {
+	switch convertedA := a.(type) {
+	case *Constant_Value_Tacky:
+		convertedB, isConst := b.(*Constant_Value_Tacky)
+		return isConst && (convertedA.typ == convertedB.typ) && (convertedA.value == convertedB.value)
+	case *Variable_Value_Tacky:
+		convertedB, isVar := b.(*Variable_Value_Tacky)
+		return isVar && (convertedA.name == convertedB.name)
+	}
+	return false
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func copyReachingCopies(input Reaching_Copies) Reaching_Copies {
+	output := make(Reaching_Copies)
+	for dst, src := range input {
+		output[dst] = src
+	}
+	return output
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func isSameReachingCopies(a Reaching_Copies, b Reaching_Copies) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for dst, src := range a {
+		other, found := b[dst]
+		if !found || !isSameValue(src, other) {
+			return false
+		}
+	}
+	return true
+}
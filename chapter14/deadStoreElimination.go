@@ -0,0 +1,149 @@
+package main
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+type Live_Variables map[string]bool
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func eliminateDeadStores(body []Instruction_Tacky) []Instruction_Tacky {
+	cfg := makeControlFlowGraph(body)
+	aliased := findAliasedVariables(body)
+	blockOut := findLiveVariables(cfg, aliased)
+
+	for _, block := range cfg.blocks {
+		// go backwards through the block so that current is what is live right after each instruction
+		current := copyLiveVariables(blockOut[block])
+		keptInstructions := []Instruction_Tacky{}
+		for index := len(block.instructions) - 1; index >= 0; index-- {
+			instr := block.instructions[index]
+			if isDeadStore(instr, current) {
+				continue
+			}
+			transferLiveVariables(instr, current, aliased)
+			keptInstructions = append(keptInstructions, instr)
+		}
+
+		for left, right := 0, len(keptInstructions)-1; left < right; left, right = left+1, right-1 {
+			keptInstructions[left], keptInstructions[right] = keptInstructions[right], keptInstructions[left]
+		}
+		block.instructions = keptInstructions
+	}
+
+	return cfg.toInstructions()
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// Function calls are never removed because they can have side effects, and stores write through a pointer so they
+// don't have a dst that can be dead.
+func isDeadStore(instr Instruction_Tacky, live Live_Variables) bool {
+	switch instr.(type) {
+	case *Function_Call_Tacky, *Store_Instruction_Tacky:
+		return false
+	}
+
+	dst, isVar := getInstructionDst(instr).(*Variable_Value_Tacky)
+	return isVar && !live[dst.name]
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// backward dataflow analysis, returns the variables that are live at the end of each block
+func findLiveVariables(cfg *Control_Flow_Graph, aliased map[string]bool) map[*Basic_Block]Live_Variables {
+	blockIn := make(map[*Basic_Block]Live_Variables)
+	blockOut := make(map[*Basic_Block]Live_Variables)
+
+	// static variables can be read after the function returns, and keep the aliased ones to be safe
+	exitIn := make(Live_Variables)
+	for name, _ := range aliased {
+		exitIn[name] = true
+	}
+	blockIn[cfg.exit] = exitIn
+
+	worklist := make([]*Basic_Block, len(cfg.blocks))
+	for index, block := range cfg.blocks {
+		worklist[len(cfg.blocks)-1-index] = block
+	}
+	for len(worklist) > 0 {
+		block := worklist[0]
+		worklist = worklist[1:]
+
+		// the meet operator is union since a variable is live if it can be read along any path
+		out := make(Live_Variables)
+		for _, succ := range block.succs {
+			for name, _ := range blockIn[succ] {
+				out[name] = true
+			}
+		}
+		blockOut[block] = out
+
+		in := copyLiveVariables(out)
+		for index := len(block.instructions) - 1; index >= 0; index-- {
+			transferLiveVariables(block.instructions[index], in, aliased)
+		}
+
+		oldIn, visited := blockIn[block]
+		if !visited || !isSameLiveVariables(in, oldIn) {
+			blockIn[block] = in
+			for _, pred := range block.preds {
+				if pred != cfg.entry {
+					worklist = append(worklist, pred)
+				}
+			}
+		}
+	}
+
+	return blockOut
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// updates the live variables from right after the instruction to right before it
+func transferLiveVariables(instr Instruction_Tacky, live Live_Variables, aliased map[string]bool) {
+	dst, isVar := getInstructionDst(instr).(*Variable_Value_Tacky)
+	if isVar {
+		delete(live, dst.name)
+	}
+
+	for _, use := range getInstructionUses(instr) {
+		v, isVar := use.(*Variable_Value_Tacky)
+		if isVar {
+			live[v.name] = true
+		}
+	}
+
+	switch instr.(type) {
+	case *Function_Call_Tacky, *Load_Instruction_Tacky:
+		// the function or the pointer could read any variable that is static or has had its address taken
+		for name, _ := range aliased {
+			live[name] = true
+		}
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func copyLiveVariables(input Live_Variables) Live_Variables {
+	output := make(Live_Variables)
+	for name, _ := range input {
+		output[name] = true
+	}
+	return output
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func isSameLiveVariables(a Live_Variables, b Live_Variables) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, _ := range a {
+		if !b[name] {
+			return false
+		}
+	}
+	return true
+}
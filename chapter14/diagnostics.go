@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+type DiagnosticsFormatEnum int
+
+const (
+	TEXT_DIAGNOSTICS DiagnosticsFormatEnum = iota
+	JSON_DIAGNOSTICS
+	SARIF_DIAGNOSTICS
+)
+
+var diagnosticsFormat = TEXT_DIAGNOSTICS
+
+type SeverityEnum int
+
+const (
+	NONE_SEVERITY SeverityEnum = iota
+	ERROR_SEVERITY
+	WARNING_SEVERITY
+	NOTE_SEVERITY
+)
+
+var severityNames = map[SeverityEnum]string{
+	ERROR_SEVERITY:   "error",
+	WARNING_SEVERITY: "warning",
+	NOTE_SEVERITY:    "note",
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+type Diagnostic struct {
+	severity SeverityEnum
+	// the option that controls a warning (ex: -Wshadow), or the compiler step that failed for errors (ex: parser)
+	category string
+	message  string
+	// the range of source code the diagnostic is about, finish is the last column and not one past it
+	start  Source_Location
+	finish Source_Location
+	notes  []Diagnostic
+	fixIts []Fix_It
+}
+
+// replace the code from start to finish with the replacement, if finish comes before start then it's an insertion
+type Fix_It struct {
+	start       Source_Location
+	finish      Source_Location
+	replacement string
+}
+
+// the diagnostics that will be printed all together at the end for the json and sarif formats
+var allDiagnostics = []Diagnostic{}
+
+// which step the compiler is on, used as the category of errors
+var currentCompilerStep = "driver"
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// returns false if the option is not -fdiagnostics-format or has a format we don't support
+func parseDiagnosticsFormatOption(option string) bool {
+	format, found := strings.CutPrefix(option, "-fdiagnostics-format=")
+	if !found {
+		return false
+	}
+
+	switch format {
+	case "text":
+		diagnosticsFormat = TEXT_DIAGNOSTICS
+	case "json":
+		diagnosticsFormat = JSON_DIAGNOSTICS
+	case "sarif":
+		diagnosticsFormat = SARIF_DIAGNOSTICS
+	default:
+		return false
+	}
+	return true
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// the location of a whole token, used for errors about a specific token
+func makeTokenDiagnostic(severity SeverityEnum, token Token, msg string) Diagnostic {
+	finish := token.loc
+	if len(token.word) > 0 {
+		finish.col += len(token.word) - 1
+	}
+	return Diagnostic{severity: severity, category: currentCompilerStep, message: msg, start: token.loc, finish: finish}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func reportDiagnostic(diag Diagnostic) {
+	if diag.finish.line == 0 {
+		diag.finish = diag.start
+	}
+	for index, _ := range diag.notes {
+		if diag.notes[index].finish.line == 0 {
+			diag.notes[index].finish = diag.notes[index].start
+		}
+	}
+
+	if diagnosticsFormat != TEXT_DIAGNOSTICS {
+		allDiagnostics = append(allDiagnostics, diag)
+		return
+	}
+
+	// errors have always been printed to stdout, warnings go to stderr like gcc
+	output := os.Stderr
+	if (diag.severity == ERROR_SEVERITY) && !strings.HasPrefix(diag.category, "-W") {
+		output = os.Stdout
+	}
+	fmt.Fprintln(output, formatDiagnosticText(diag))
+	for _, note := range diag.notes {
+		fmt.Fprintln(output, formatDiagnosticText(note))
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func formatDiagnosticText(diag Diagnostic) string {
+	text := diag.message
+	if diag.start.line > 0 {
+		text = diag.start.String() + ": " + severityNames[diag.severity] + ": " + text
+	}
+	if strings.HasPrefix(diag.category, "-W") {
+		text += " [" + diag.category + "]"
+	}
+	return text
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// all exits should go through here so the json and sarif output is written first
+func exitCompiler(code int) {
+	flushDiagnostics()
+	os.Exit(code)
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func flushDiagnostics() {
+	var output any
+	switch diagnosticsFormat {
+	case JSON_DIAGNOSTICS:
+		output = makeJsonDiagnostics(allDiagnostics)
+	case SARIF_DIAGNOSTICS:
+		output = makeSarifLog(allDiagnostics)
+	default:
+		return
+	}
+
+	data, err := json.MarshalIndent(output, "", "  ")
+	if err != nil {
+		fmt.Println("Could not write diagnostics:", err.Error())
+		return
+	}
+	fmt.Fprintln(os.Stderr, string(data))
+	allDiagnostics = []Diagnostic{}
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// the json package can only write exported fields, so these mirror the structs above
+
+type Json_Position struct {
+	File   string `json:"file"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+type Json_Range struct {
+	Start  Json_Position `json:"start"`
+	Finish Json_Position `json:"finish"`
+}
+
+type Json_Fix_It struct {
+	Range       Json_Range `json:"range"`
+	Replacement string     `json:"replacement"`
+}
+
+type Json_Diagnostic struct {
+	Severity string            `json:"severity"`
+	Category string            `json:"category,omitempty"`
+	Message  string            `json:"message"`
+	Range    *Json_Range       `json:"range,omitempty"`
+	Notes    []Json_Diagnostic `json:"notes,omitempty"`
+	FixIts   []Json_Fix_It     `json:"fixits,omitempty"`
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func makeJsonDiagnostics(diags []Diagnostic) []Json_Diagnostic {
+	output := []Json_Diagnostic{}
+
+	for _, diag := range diags {
+		jsonDiag := Json_Diagnostic{Severity: severityNames[diag.severity], Category: diag.category, Message: diag.message}
+		if diag.start.line > 0 {
+			jsonDiag.Range = &Json_Range{Start: makeJsonPosition(diag.start), Finish: makeJsonPosition(diag.finish)}
+		}
+		jsonDiag.Notes = makeJsonDiagnostics(diag.notes)
+		for _, fix := range diag.fixIts {
+			jsonRange := Json_Range{Start: makeJsonPosition(fix.start), Finish: makeJsonPosition(fix.finish)}
+			jsonDiag.FixIts = append(jsonDiag.FixIts, Json_Fix_It{Range: jsonRange, Replacement: fix.replacement})
+		}
+		output = append(output, jsonDiag)
+	}
+
+	return output
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func makeJsonPosition(loc Source_Location) Json_Position {
+	return Json_Position{File: loc.file, Line: loc.line, Column: loc.col}
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// a small subset of SARIF 2.1.0, which is what code scanning dashboards accept
+
+type Sarif_Log struct {
+	Schema  string      `json:"$schema"`
+	Version string      `json:"version"`
+	Runs    []Sarif_Run `json:"runs"`
+}
+
+type Sarif_Run struct {
+	Tool    Sarif_Tool     `json:"tool"`
+	Results []Sarif_Result `json:"results"`
+}
+
+type Sarif_Tool struct {
+	Driver Sarif_Driver `json:"driver"`
+}
+
+type Sarif_Driver struct {
+	Name  string       `json:"name"`
+	Rules []Sarif_Rule `json:"rules"`
+}
+
+type Sarif_Rule struct {
+	Id string `json:"id"`
+}
+
+type Sarif_Message struct {
+	Text string `json:"text"`
+}
+
+type Sarif_Result struct {
+	RuleId           string           `json:"ruleId,omitempty"`
+	Level            string           `json:"level"`
+	Message          Sarif_Message    `json:"message"`
+	Locations        []Sarif_Location `json:"locations,omitempty"`
+	RelatedLocations []Sarif_Location `json:"relatedLocations,omitempty"`
+	Fixes            []Sarif_Fix      `json:"fixes,omitempty"`
+}
+
+type Sarif_Location struct {
+	Id               *int                    `json:"id,omitempty"`
+	PhysicalLocation Sarif_Physical_Location `json:"physicalLocation"`
+	Message          *Sarif_Message          `json:"message,omitempty"`
+}
+
+type Sarif_Physical_Location struct {
+	ArtifactLocation Sarif_Artifact_Location `json:"artifactLocation"`
+	Region           Sarif_Region            `json:"region"`
+}
+
+type Sarif_Artifact_Location struct {
+	Uri string `json:"uri"`
+}
+
+// sarif columns start at 1 and endColumn is one past the last column
+type Sarif_Region struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+	EndLine     int `json:"endLine"`
+	EndColumn   int `json:"endColumn"`
+}
+
+type Sarif_Fix struct {
+	ArtifactChanges []Sarif_Artifact_Change `json:"artifactChanges"`
+}
+
+type Sarif_Artifact_Change struct {
+	ArtifactLocation Sarif_Artifact_Location `json:"artifactLocation"`
+	Replacements     []Sarif_Replacement     `json:"replacements"`
+}
+
+type Sarif_Replacement struct {
+	DeletedRegion   Sarif_Region  `json:"deletedRegion"`
+	InsertedContent Sarif_Message `json:"insertedContent"`
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func makeSarifLog(diags []Diagnostic) Sarif_Log {
+	run := Sarif_Run{Tool: Sarif_Tool{Driver: Sarif_Driver{Name: "goc", Rules: []Sarif_Rule{}}}, Results: []Sarif_Result{}}
+
+	seenRules := make(map[string]bool)
+	for _, diag := range diags {
+		result := Sarif_Result{Level: severityNames[diag.severity], Message: Sarif_Message{diag.message}}
+
+		if strings.HasPrefix(diag.category, "-W") {
+			// -Werror=shadow and -Wshadow are the same rule
+			result.RuleId = strings.TrimPrefix(strings.TrimPrefix(diag.category, "-Werror="), "-W")
+			if !seenRules[result.RuleId] {
+				seenRules[result.RuleId] = true
+				run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, Sarif_Rule{result.RuleId})
+			}
+		}
+
+		if diag.start.line > 0 {
+			result.Locations = []Sarif_Location{{PhysicalLocation: makeSarifPhysicalLocation(diag.start, diag.finish)}}
+		}
+
+		for index, note := range diag.notes {
+			if note.start.line == 0 {
+				continue
+			}
+			id := index
+			related := Sarif_Location{Id: &id, PhysicalLocation: makeSarifPhysicalLocation(note.start, note.finish),
+				Message: &Sarif_Message{note.message}}
+			result.RelatedLocations = append(result.RelatedLocations, related)
+		}
+
+		for _, fix := range diag.fixIts {
+			replacement := Sarif_Replacement{DeletedRegion: makeSarifRegion(fix.start, fix.finish),
+				InsertedContent: Sarif_Message{fix.replacement}}
+			change := Sarif_Artifact_Change{ArtifactLocation: Sarif_Artifact_Location{fix.start.file},
+				Replacements: []Sarif_Replacement{replacement}}
+			result.Fixes = append(result.Fixes, Sarif_Fix{[]Sarif_Artifact_Change{change}})
+		}
+
+		run.Results = append(run.Results, result)
+	}
+
+	return Sarif_Log{Schema: "https://json.schemastore.org/sarif-2.1.0.json", Version: "2.1.0", Runs: []Sarif_Run{run}}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func makeSarifPhysicalLocation(start Source_Location, finish Source_Location) Sarif_Physical_Location {
+	return Sarif_Physical_Location{ArtifactLocation: Sarif_Artifact_Location{start.file}, Region: makeSarifRegion(start, finish)}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func makeSarifRegion(start Source_Location, finish Source_Location) Sarif_Region {
+	if finish.line == 0 {
+		finish = start
+	}
+	// an insertion has finish before start, sarif wants an empty region where the text goes
+	if (finish.line < start.line) || ((finish.line == start.line) && (finish.col < start.col)) {
+		return Sarif_Region{StartLine: start.line, StartColumn: start.col, EndLine: start.line, EndColumn: start.col}
+	}
+	return Sarif_Region{StartLine: start.line, StartColumn: start.col, EndLine: finish.line, EndColumn: finish.col + 1}
+}
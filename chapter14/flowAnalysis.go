@@ -0,0 +1,336 @@
+package main
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// maps the first instruction of a statement or declaration to where it is in the source code,
+// so that the analysis done on tacky can still point at the original code
+var statementLocations = make(map[Instruction_Tacky]Source_Location)
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func recordStatementLocation(instructions []Instruction_Tacky, loc Source_Location) {
+	if len(instructions) > 0 {
+		statementLocations[instructions[0]] = loc
+	}
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// C only defines what happens when main falls off the end, it returns 0. Other functions that fall off the end
+// get a warning, and we still return zero of the right type so that the caller doesn't get a garbage value.
+func addImplicitReturn(fn *Function_Declaration, body []Instruction_Tacky) []Instruction_Tacky {
+	cfg := makeControlFlowGraph(body)
+	reachable := cfg.findReachableBlocks()
+
+	warnUnreachableStatements(cfg, reachable)
+
+	if !canFallOffEnd(cfg, reachable) {
+		return body
+	}
+
+	if fn.name != "main" {
+		warn(RETURN_TYPE_WARNING, fn.body.endLoc, "control reaches end of non-void function")
+	}
+
+	returnTyp := symbolTable[fn.name].dataTyp.returnType
+	ret := Return_Instruction_Tacky{&Constant_Value_Tacky{typ: returnTyp.typ, value: "0"}}
+	return append(body, &ret)
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func canFallOffEnd(cfg *Control_Flow_Graph, reachable map[*Basic_Block]bool) bool {
+	for _, pred := range cfg.exit.preds {
+		if !reachable[pred] {
+			continue
+		}
+		if len(pred.instructions) == 0 {
+			// the entry block goes straight to the exit when the body is empty
+			return true
+		}
+		_, isReturn := pred.instructions[len(pred.instructions)-1].(*Return_Instruction_Tacky)
+		if !isReturn {
+			return true
+		}
+	}
+	return false
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// only the first statement in each stretch of unreachable code gets a warning
+func warnUnreachableStatements(cfg *Control_Flow_Graph, reachable map[*Basic_Block]bool) {
+	alreadyWarned := false
+
+	for _, block := range cfg.blocks {
+		if reachable[block] {
+			alreadyWarned = false
+			continue
+		}
+
+		for _, instr := range block.instructions {
+			loc, startsStatement := statementLocations[instr]
+			if startsStatement && !alreadyWarned {
+				warn(UNREACHABLE_CODE_WARNING, loc, "code will never be executed")
+				alreadyWarned = true
+			}
+		}
+	}
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// the values that an instruction reads, the src of Get_Address isn't read so it's not included
+func getInstructionUses(instr Instruction_Tacky) []Value_Tacky {
+	switch convertedInstr := instr.(type) {
+	case *Return_Instruction_Tacky:
+		return []Value_Tacky{convertedInstr.val}
+	case *Sign_Extend_Instruction_Tacky:
+		return []Value_Tacky{convertedInstr.src}
+	case *Truncate_Instruction_Tacky:
+		return []Value_Tacky{convertedInstr.src}
+	case *Zero_Extend_Instruction_Tacky:
+		return []Value_Tacky{convertedInstr.src}
+	case *Double_To_Int_Instruction_Tacky:
+		return []Value_Tacky{convertedInstr.src}
+	case *Double_To_UInt_Instruction_Tacky:
+		return []Value_Tacky{convertedInstr.src}
+	case *Int_To_Double_Instruction_Tacky:
+		return []Value_Tacky{convertedInstr.src}
+	case *UInt_To_Double_Instruction_Tacky:
+		return []Value_Tacky{convertedInstr.src}
+	case *Unary_Instruction_Tacky:
+		return []Value_Tacky{convertedInstr.src}
+	case *Binary_Instruction_Tacky:
+		return []Value_Tacky{convertedInstr.src1, convertedInstr.src2}
+	case *Copy_Instruction_Tacky:
+		return []Value_Tacky{convertedInstr.src}
+	case *Conditional_Copy_Instruction_Tacky:
+		return []Value_Tacky{convertedInstr.left, convertedInstr.right, convertedInstr.src1, convertedInstr.src2}
+	case *Load_Instruction_Tacky:
+		return []Value_Tacky{convertedInstr.srcPtr}
+	case *Store_Instruction_Tacky:
+		return []Value_Tacky{convertedInstr.src, convertedInstr.dstPtr}
+	case *Jump_If_Zero_Instruction_Tacky:
+		return []Value_Tacky{convertedInstr.condition}
+	case *Jump_If_Not_Zero_Instruction_Tacky:
+		return []Value_Tacky{convertedInstr.condition}
+	case *Jump_If_Compare_Instruction_Tacky:
+		return []Value_Tacky{convertedInstr.src1, convertedInstr.src2}
+	case *Function_Call_Tacky:
+		return convertedInstr.args
+	case *Phi_Instruction_Tacky:
+		// the args are really read at the end of each predecessor, but this is enough for counting uses
+		uses := []Value_Tacky{}
+		for _, arg := range convertedInstr.args {
+			uses = append(uses, arg.val)
+		}
+		return uses
+	}
+	return []Value_Tacky{}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// the value that an instruction writes to, or nil if it doesn't write to one. Store writes through a pointer
+// so it doesn't have a dst value.
+func getInstructionDst(instr Instruction_Tacky) Value_Tacky {
+	switch convertedInstr := instr.(type) {
+	case *Sign_Extend_Instruction_Tacky:
+		return convertedInstr.dst
+	case *Truncate_Instruction_Tacky:
+		return convertedInstr.dst
+	case *Zero_Extend_Instruction_Tacky:
+		return convertedInstr.dst
+	case *Double_To_Int_Instruction_Tacky:
+		return convertedInstr.dst
+	case *Double_To_UInt_Instruction_Tacky:
+		return convertedInstr.dst
+	case *Int_To_Double_Instruction_Tacky:
+		return convertedInstr.dst
+	case *UInt_To_Double_Instruction_Tacky:
+		return convertedInstr.dst
+	case *Unary_Instruction_Tacky:
+		return convertedInstr.dst
+	case *Binary_Instruction_Tacky:
+		return convertedInstr.dst
+	case *Copy_Instruction_Tacky:
+		return convertedInstr.dst
+	case *Conditional_Copy_Instruction_Tacky:
+		return convertedInstr.dst
+	case *Get_Address_Instruction_Tacky:
+		return convertedInstr.dst
+	case *Load_Instruction_Tacky:
+		return convertedInstr.dst
+	case *Function_Call_Tacky:
+		return convertedInstr.returnVal
+	case *Phi_Instruction_Tacky:
+		return convertedInstr.dst
+	}
+	return nil
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// maps each use of a variable in the source code to where it is, filled in during tacky generation
+var valueLocations = make(map[*Variable_Value_Tacky]Source_Location)
+
+// Reaching definitions only needs to know two things about each variable to find uninitialized uses: whether the
+// "no definition" at the start of the function can reach a point, and whether a real definition can reach it.
+const (
+	UNINITIALIZED_REACHES = 1 << iota
+	INITIALIZED_REACHES
+)
+
+type Reaching_Definitions map[string]int
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func warnUninitializedVariables(body []Instruction_Tacky) {
+	cfg := makeControlFlowGraph(body)
+
+	// only check the automatic local variables, parameters and static variables always have a value
+	tracked := make(map[string]bool)
+	for _, instr := range body {
+		for _, use := range getInstructionUses(instr) {
+			v, isVar := use.(*Variable_Value_Tacky)
+			if !isVar {
+				continue
+			}
+			usage, isLocal := localIdentifierUsage[v.name]
+			if isLocal && !usage.isParam && (symbolTable[v.name].attrs == LOCAL_ATTRIBUTES) {
+				tracked[v.name] = true
+			}
+		}
+	}
+	if len(tracked) == 0 {
+		return
+	}
+
+	blockIn := findReachingDefinitions(cfg, tracked)
+
+	warned := make(map[string]bool)
+	for _, block := range cfg.blocks {
+		current := copyReachingDefinitions(blockIn[block])
+		for _, instr := range block.instructions {
+			for _, use := range getInstructionUses(instr) {
+				v, isVar := use.(*Variable_Value_Tacky)
+				if !isVar || !tracked[v.name] || warned[v.name] {
+					continue
+				}
+				loc, hasLoc := valueLocations[v]
+				if !hasLoc {
+					continue
+				}
+				name := localIdentifierUsage[v.name].originalName
+				switch current[v.name] {
+				case UNINITIALIZED_REACHES:
+					warn(UNINITIALIZED_WARNING, loc, "variable '"+name+"' is used uninitialized")
+					warned[v.name] = true
+				case UNINITIALIZED_REACHES | INITIALIZED_REACHES:
+					warn(MAYBE_UNINITIALIZED_WARNING, loc, "variable '"+name+"' may be used uninitialized")
+					warned[v.name] = true
+				}
+			}
+			transferReachingDefinitions(instr, current, tracked)
+		}
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// forward dataflow analysis, returns what reaches the start of each block
+func findReachingDefinitions(cfg *Control_Flow_Graph, tracked map[string]bool) map[*Basic_Block]Reaching_Definitions {
+	blockIn := make(map[*Basic_Block]Reaching_Definitions)
+	blockOut := make(map[*Basic_Block]Reaching_Definitions)
+
+	entryOut := make(Reaching_Definitions)
+	for name, _ := range tracked {
+		entryOut[name] = UNINITIALIZED_REACHES
+	}
+	blockOut[cfg.entry] = entryOut
+
+	worklist := make([]*Basic_Block, len(cfg.blocks))
+	copy(worklist, cfg.blocks)
+	for len(worklist) > 0 {
+		block := worklist[0]
+		worklist = worklist[1:]
+
+		// the meet operator is union since a definition reaches a point if it can reach it along any path
+		in := make(Reaching_Definitions)
+		for _, pred := range block.preds {
+			for name, bits := range blockOut[pred] {
+				in[name] |= bits
+			}
+		}
+		blockIn[block] = in
+
+		out := copyReachingDefinitions(in)
+		for _, instr := range block.instructions {
+			transferReachingDefinitions(instr, out, tracked)
+		}
+
+		if !isSameReachingDefinitions(out, blockOut[block]) {
+			blockOut[block] = out
+			for _, succ := range block.succs {
+				if succ != cfg.exit {
+					worklist = append(worklist, succ)
+				}
+			}
+		}
+	}
+
+	return blockIn
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func transferReachingDefinitions(instr Instruction_Tacky, current Reaching_Definitions, tracked map[string]bool) {
+	getAddr, isGetAddr := instr.(*Get_Address_Instruction_Tacky)
+	if isGetAddr {
+		// once the address escapes, the variable can be written through a pointer that we don't track,
+		// so treat it like it was initialized here
+		v, isVar := getAddr.src.(*Variable_Value_Tacky)
+		if isVar && tracked[v.name] {
+			current[v.name] = INITIALIZED_REACHES
+		}
+	}
+
+	v, isVar := getInstructionDst(instr).(*Variable_Value_Tacky)
+	if isVar && tracked[v.name] {
+		// a definition kills every other definition of the same variable, including the uninitialized one
+		current[v.name] = INITIALIZED_REACHES
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func copyReachingDefinitions(input Reaching_Definitions) Reaching_Definitions {
+	output := make(Reaching_Definitions)
+	for name, bits := range input {
+		output[name] = bits
+	}
+	return output
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func isSameReachingDefinitions(a Reaching_Definitions, b Reaching_Definitions) bool {
+	if (b == nil) || (len(a) != len(b)) {
+		return false
+	}
+	for name, bits := range a {
+		if b[name] != bits {
+			return false
+		}
+	}
+	return true
+}
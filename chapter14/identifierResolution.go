@@ -10,6 +10,28 @@ type Identifier_Info struct {
 
 /////////////////////////////////////////////////////////////////////////////////
 
+// tracks whether a local variable or parameter is ever referenced, so we can warn about the unused ones
+type Identifier_Usage struct {
+	originalName string
+	loc          Source_Location
+	isParam      bool
+	used         bool
+}
+
+// key = globally unique name of a local variable or parameter
+var localIdentifierUsage = make(map[string]*Identifier_Usage)
+
+// unique names of the locals and parameters in the order they were declared, for the function being resolved
+var declaredLocals = []string{}
+
+// names of functions that were called or declared static, used to find static functions that are never called
+var usedFunctions = make(map[string]bool)
+var staticFunctions = make(map[string]bool)
+var definedFunctions = make(map[string]Source_Location)
+var functionOrder = []string{}
+
+/////////////////////////////////////////////////////////////////////////////////
+
 func copyIdentifierMap(input map[string]Identifier_Info) map[string]Identifier_Info {
 	output := make(map[string]Identifier_Info)
 
@@ -34,11 +56,64 @@ func doIdentifierResolution(ast Program) Program {
 		ast.decls[index] = resolveFileScopeDeclaration(ast.decls[index], identifierMap)
 	}
 
+	warnUnusedStaticFunctions()
+
 	return ast
 }
 
 /////////////////////////////////////////////////////////////////////////////////
 
+func warnUnusedStaticFunctions() {
+	for _, name := range functionOrder {
+		if staticFunctions[name] && !usedFunctions[name] {
+			warn(UNUSED_FUNCTION_WARNING, definedFunctions[name], "'"+name+"' defined but not used")
+		}
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func warnUnusedLocals(uniqueNames []string) {
+	for _, name := range uniqueNames {
+		usage := localIdentifierUsage[name]
+		if usage.used {
+			continue
+		}
+		if usage.isParam {
+			warn(UNUSED_PARAMETER_WARNING, usage.loc, "unused parameter '"+usage.originalName+"'")
+		} else {
+			warn(UNUSED_VARIABLE_WARNING, usage.loc, "unused variable '"+usage.originalName+"'")
+		}
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func warnIfShadowing(name string, loc Source_Location, identifierMap map[string]Identifier_Info) {
+	prevEntry, nameExists := identifierMap[name]
+	if !nameExists || prevEntry.fromCurrentScope {
+		return
+	}
+
+	prevUsage, isLocal := localIdentifierUsage[prevEntry.uniqueName]
+	if !isLocal {
+		warn(SHADOW_WARNING, loc, "declaration of '"+name+"' shadows a global declaration")
+		return
+	}
+
+	// like gcc, point at the declaration that is being shadowed
+	note := Diagnostic{severity: NOTE_SEVERITY, message: "shadowed declaration is here", start: prevUsage.loc}
+	if prevUsage.isParam {
+		warnDiagnostic(SHADOW_WARNING, Diagnostic{message: "declaration of '" + name + "' shadows a parameter", start: loc,
+			notes: []Diagnostic{note}})
+	} else {
+		warnDiagnostic(SHADOW_WARNING, Diagnostic{message: "declaration of '" + name + "' shadows a previous local", start: loc,
+			notes: []Diagnostic{note}})
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
 func resolveFileScopeDeclaration(decl Declaration, identifierMap map[string]Identifier_Info) Declaration {
 	switch convertedDecl := decl.(type) {
 	case *Function_Declaration:
@@ -57,35 +132,57 @@ func resolveFunctionDeclaration(decl Function_Declaration, identifierMap map[str
 	prevEntry, funcExists := identifierMap[decl.name]
 	if funcExists {
 		if prevEntry.fromCurrentScope && !prevEntry.hasLinkage {
-			fail("Semantic error. Duplicate function declaration:", decl.name)
+			failAt(decl.loc, "Semantic error. Duplicate function declaration:", decl.name)
 		}
 	}
 
 	identifierMap[decl.name] = Identifier_Info{uniqueName: decl.name, fromCurrentScope: true, hasLinkage: true}
 
+	if decl.storageClass == STATIC_STORAGE_CLASS {
+		staticFunctions[decl.name] = true
+	}
+	if decl.body != nil {
+		definedFunctions[decl.name] = decl.loc
+		functionOrder = append(functionOrder, decl.name)
+	}
+
 	// the list of function parameters in a declaration starts a new scope, so we need a copy of the map to track them
 	innerMap := copyIdentifierMap(identifierMap)
+	firstLocal := len(declaredLocals)
 	newParams := []string{}
-	for _, param := range decl.paramNames {
-		newParam := resolveParam(param, innerMap)
+	for index, param := range decl.paramNames {
+		if decl.body != nil {
+			warnIfShadowing(param, decl.paramLocs[index], innerMap)
+		}
+		newParam := resolveParam(param, decl.paramLocs[index], innerMap)
 		newParams = append(newParams, newParam)
+
+		// only the parameters of a function definition can be unused
+		if decl.body != nil {
+			localIdentifierUsage[newParam] = &Identifier_Usage{originalName: param, loc: decl.paramLocs[index], isParam: true}
+			declaredLocals = append(declaredLocals, newParam)
+		}
 	}
 
 	var newBody *Block = nil
 	if decl.body != nil {
 		tempBody := resolveBlock(*decl.body, innerMap)
 		newBody = &tempBody
+
+		warnUnusedLocals(declaredLocals[firstLocal:])
+		declaredLocals = declaredLocals[:firstLocal]
 	}
-	return Function_Declaration{name: decl.name, paramNames: newParams, body: newBody, dTyp: decl.dTyp, storageClass: decl.storageClass}
+	return Function_Declaration{name: decl.name, paramNames: newParams, body: newBody, dTyp: decl.dTyp, storageClass: decl.storageClass,
+		loc: decl.loc, paramLocs: decl.paramLocs, isInline: decl.isInline}
 }
 
 /////////////////////////////////////////////////////////////////////////////////
 
-func resolveParam(param string, identifierMap map[string]Identifier_Info) string {
+func resolveParam(param string, loc Source_Location, identifierMap map[string]Identifier_Info) string {
 	idInfo, nameExists := identifierMap[param]
 
 	if nameExists && idInfo.fromCurrentScope {
-		fail("Semantic error. Variable", param, "declared more than once in same scope.")
+		failAt(loc, "Semantic error. Variable", param, "declared more than once in same scope.")
 	}
 
 	uniqueName := makeTempVarName(param)
@@ -108,7 +205,7 @@ func resolveLocalVariableDeclaration(decl Variable_Declaration, identifierMap ma
 
 	if nameExists && prevEntry.fromCurrentScope {
 		if (!prevEntry.hasLinkage) || (decl.storageClass != EXTERN_STORAGE_CLASS) {
-			fail("Semantic error. Conflicting local declarations of variable", decl.name)
+			failAt(decl.loc, "Semantic error. Conflicting local declarations of variable", decl.name)
 		}
 	}
 
@@ -116,15 +213,19 @@ func resolveLocalVariableDeclaration(decl Variable_Declaration, identifierMap ma
 		identifierMap[decl.name] = Identifier_Info{uniqueName: decl.name, fromCurrentScope: true, hasLinkage: true}
 		return decl
 	} else {
+		warnIfShadowing(decl.name, decl.loc, identifierMap)
+
 		uniqueName := makeTempVarName(decl.name)
 		identifierMap[decl.name] = Identifier_Info{uniqueName: uniqueName, fromCurrentScope: true, hasLinkage: false}
+		localIdentifierUsage[uniqueName] = &Identifier_Usage{originalName: decl.name, loc: decl.loc}
+		declaredLocals = append(declaredLocals, uniqueName)
 
 		var init Expression = nil
 		if decl.initializer != nil {
 			init = resolveExpression(decl.initializer, identifierMap)
 		}
 
-		return Variable_Declaration{name: uniqueName, initializer: init, dTyp: decl.dTyp, storageClass: decl.storageClass}
+		return Variable_Declaration{name: uniqueName, initializer: init, dTyp: decl.dTyp, storageClass: decl.storageClass, loc: decl.loc}
 	}
 }
 
@@ -147,7 +248,7 @@ func resolveBlockItem(existingItem Block_Item, identifierMap map[string]Identifi
 	switch convertedItem := existingItem.(type) {
 	case *Block_Statement:
 		newStatement := resolveStatement(convertedItem.st, identifierMap)
-		return &Block_Statement{newStatement}
+		return &Block_Statement{st: newStatement, loc: convertedItem.loc}
 	case *Block_Declaration:
 		decl, isVarDecl := convertedItem.decl.(*Variable_Declaration)
 		if isVarDecl {
@@ -156,10 +257,10 @@ func resolveBlockItem(existingItem Block_Item, identifierMap map[string]Identifi
 		} else {
 			funcDecl := convertedItem.decl.(*Function_Declaration)
 			if funcDecl.body != nil {
-				fail("Semantic error. Local function declaration can not have a body:", funcDecl.name)
+				failAt(funcDecl.loc, "Semantic error. Local function declaration can not have a body:", funcDecl.name)
 			}
 			if funcDecl.storageClass == STATIC_STORAGE_CLASS {
-				fail("Semantic error. Block scope function declaration can not be static:", funcDecl.name)
+				failAt(funcDecl.loc, "Semantic error. Block scope function declaration can not be static:", funcDecl.name)
 			}
 			newDecl := resolveFunctionDeclaration(*funcDecl, identifierMap)
 			return &Block_Declaration{&newDecl}
@@ -252,48 +353,53 @@ func resolveExpression(exp Expression, identifierMap map[string]Identifier_Info)
 	case *Variable_Expression:
 		idInfo, varExists := identifierMap[convertedExp.name]
 		if varExists {
-			return &Variable_Expression{name: idInfo.uniqueName}
+			usage, isLocal := localIdentifierUsage[idInfo.uniqueName]
+			if isLocal {
+				usage.used = true
+			}
+			return &Variable_Expression{name: idInfo.uniqueName, loc: convertedExp.loc}
 		} else {
-			fail("Semantic error. Undeclared variable:", convertedExp.name)
+			failAt(convertedExp.loc, "Semantic error. Undeclared variable:", convertedExp.name)
 		}
 	case *Cast_Expression:
 		newExp := resolveExpression(convertedExp.innerExp, identifierMap)
-		return &Cast_Expression{targetType: convertedExp.targetType, innerExp: newExp}
+		return &Cast_Expression{targetType: convertedExp.targetType, innerExp: newExp, loc: convertedExp.loc}
 	case *Unary_Expression:
 		newInner := resolveExpression(convertedExp.innerExp, identifierMap)
-		return &Unary_Expression{unOp: convertedExp.unOp, innerExp: newInner}
+		return &Unary_Expression{unOp: convertedExp.unOp, innerExp: newInner, loc: convertedExp.loc}
 	case *Binary_Expression:
 		newFirst := resolveExpression(convertedExp.firstExp, identifierMap)
 		newSecond := resolveExpression(convertedExp.secExp, identifierMap)
-		return &Binary_Expression{binOp: convertedExp.binOp, firstExp: newFirst, secExp: newSecond}
+		return &Binary_Expression{binOp: convertedExp.binOp, firstExp: newFirst, secExp: newSecond, loc: convertedExp.loc}
 	case *Assignment_Expression:
 		newLvalue := resolveExpression(convertedExp.lvalue, identifierMap)
 		newRightExp := resolveExpression(convertedExp.rightExp, identifierMap)
-		return &Assignment_Expression{lvalue: newLvalue, rightExp: newRightExp}
+		return &Assignment_Expression{lvalue: newLvalue, rightExp: newRightExp, loc: convertedExp.loc}
 	case *Conditional_Expression:
 		newCond := resolveExpression(convertedExp.condition, identifierMap)
 		newMiddle := resolveExpression(convertedExp.middleExp, identifierMap)
 		newRight := resolveExpression(convertedExp.rightExp, identifierMap)
-		return &Conditional_Expression{condition: newCond, middleExp: newMiddle, rightExp: newRight}
+		return &Conditional_Expression{condition: newCond, middleExp: newMiddle, rightExp: newRight, loc: convertedExp.loc}
 	case *Function_Call_Expression:
 		idInfo, nameExists := identifierMap[convertedExp.functionName]
 		if nameExists {
 			newFuncName := idInfo.uniqueName
+			usedFunctions[newFuncName] = true
 			newArgs := []Expression{}
 			for _, arg := range convertedExp.args {
 				newArg := resolveExpression(arg, identifierMap)
 				newArgs = append(newArgs, newArg)
 			}
-			return &Function_Call_Expression{functionName: newFuncName, args: newArgs}
+			return &Function_Call_Expression{functionName: newFuncName, args: newArgs, loc: convertedExp.loc}
 		} else {
-			fail("Semantic error. Trying to use undeclared function:", convertedExp.functionName)
+			failAt(convertedExp.loc, "Semantic error. Trying to use undeclared function:", convertedExp.functionName)
 		}
 	case *Dereference_Expression:
 		newInner := resolveExpression(convertedExp.innerExp, identifierMap)
-		return &Dereference_Expression{innerExp: newInner}
+		return &Dereference_Expression{innerExp: newInner, loc: convertedExp.loc}
 	case *Address_Of_Expression:
 		newInner := resolveExpression(convertedExp.innerExp, identifierMap)
-		return &Address_Of_Expression{innerExp: newInner}
+		return &Address_Of_Expression{innerExp: newInner, loc: convertedExp.loc}
 	default:
 		fail("unknown Expression type when resolving variables")
 	}
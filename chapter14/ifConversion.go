@@ -0,0 +1,134 @@
+package main
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// Turns the branches that only pick which value to copy into a variable into conditional copies, which become cmov
+// instructions without any jumps. Both of these are changed, and the same with JUMP_IF_NOT_ZERO or JUMP_IF and the
+// copies swapped:
+//
+//	JUMP_IF_ZERO c, else          JUMP_IF_ZERO c, end
+//	x = a                         x = a
+//	JUMP end                      end:
+//	else:
+//	x = b
+//	end:
+//
+// A copy never has side effects, so doing both of them and keeping one is the same as only doing one. Doubles are
+// left alone since cmov only works with the general purpose registers.
+func convertIfsToConditionalCopies(body []Instruction_Tacky) []Instruction_Tacky {
+	targeted := make(map[string]int)
+	for _, instr := range body {
+		switch convertedInstr := instr.(type) {
+		case *Jump_Instruction_Tacky:
+			targeted[convertedInstr.target]++
+		case *Jump_If_Zero_Instruction_Tacky:
+			targeted[convertedInstr.target]++
+		case *Jump_If_Not_Zero_Instruction_Tacky:
+			targeted[convertedInstr.target]++
+		case *Jump_If_Compare_Instruction_Tacky:
+			targeted[convertedInstr.target]++
+		}
+	}
+
+	changed := false
+	newBody := []Instruction_Tacky{}
+	index := 0
+	for index < len(body) {
+		condCopy, count := matchConditionalCopy(body, index, targeted)
+		if count == 0 {
+			newBody = append(newBody, body[index])
+			index++
+			continue
+		}
+		newBody = append(newBody, condCopy)
+		index += count
+		changed = true
+	}
+
+	if !changed {
+		return body
+	}
+	return newBody
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// Returns the conditional copy that replaces the branch at index and how many instructions it replaces, or 0 if
+// it doesn't match. The end label is kept because something else might jump to it.
+func matchConditionalCopy(body []Instruction_Tacky, index int, targeted map[string]int) (Instruction_Tacky, int) {
+	// the jump is taken when (left binOp right) is true, or when it's false if jumpIfTrue isn't set
+	binOp := NOT_EQUAL_OPERATOR
+	var left, right Value_Tacky
+	target := ""
+	jumpIfTrue := false
+	switch convertedInstr := body[index].(type) {
+	case *Jump_If_Zero_Instruction_Tacky:
+		left = convertedInstr.condition
+		right = &Constant_Value_Tacky{typ: left.getDataType(), value: "0"}
+		target = convertedInstr.target
+	case *Jump_If_Not_Zero_Instruction_Tacky:
+		left = convertedInstr.condition
+		right = &Constant_Value_Tacky{typ: left.getDataType(), value: "0"}
+		target = convertedInstr.target
+		jumpIfTrue = true
+	case *Jump_If_Compare_Instruction_Tacky:
+		binOp = convertedInstr.binOp
+		left = convertedInstr.src1
+		right = convertedInstr.src2
+		target = convertedInstr.target
+		jumpIfTrue = true
+	default:
+		return nil, 0
+	}
+	if (left.getAssemblyType() == DOUBLE_ASM_TYPE) || ((index + 2) >= len(body)) {
+		return nil, 0
+	}
+
+	first, isCopy := body[index+1].(*Copy_Instruction_Tacky)
+	if !isCopy || (first.dst.getAssemblyType() == DOUBLE_ASM_TYPE) {
+		return nil, 0
+	}
+
+	// the value copied when the jump isn't taken, the value copied when it is, and how many instructions that was
+	src1, src2 := first.src, first.dst
+	count := 0
+	lbl, isLabel := body[index+2].(*Label_Instruction_Tacky)
+	if isLabel && (lbl.name == target) {
+		count = 2
+	} else if (index + 5) < len(body) {
+		jump, isJump := body[index+2].(*Jump_Instruction_Tacky)
+		elseLabel, isElseLabel := body[index+3].(*Label_Instruction_Tacky)
+		second, isSecondCopy := body[index+4].(*Copy_Instruction_Tacky)
+		endLabel, isEndLabel := body[index+5].(*Label_Instruction_Tacky)
+		if !isJump || !isElseLabel || !isSecondCopy || !isEndLabel {
+			return nil, 0
+		}
+		if (elseLabel.name != target) || (targeted[target] != 1) || (jump.target != endLabel.name) ||
+			!isSameValue(first.dst, second.dst) {
+			return nil, 0
+		}
+		src2 = second.src
+		count = 5
+	} else {
+		return nil, 0
+	}
+	if jumpIfTrue {
+		src1, src2 = src2, src1
+	}
+	condCopy := &Conditional_Copy_Instruction_Tacky{binOp: binOp, left: left, right: right, src1: src1, src2: src2, dst: first.dst}
+
+	// compare the operands directly if the condition was just computed by a comparison
+	zero, isConst := right.(*Constant_Value_Tacky)
+	if (index > 0) && (binOp == NOT_EQUAL_OPERATOR) && isConst && isZeroConstant(zero) {
+		cmp, isBinary := body[index-1].(*Binary_Instruction_Tacky)
+		if isBinary && isComparisonOperator(cmp.binOp) && isSameValue(cmp.dst, left) &&
+			(cmp.src1.getAssemblyType() != DOUBLE_ASM_TYPE) {
+			condCopy.binOp = cmp.binOp
+			condCopy.left = cmp.src1
+			condCopy.right = cmp.src2
+		}
+	}
+	return condCopy, count
+}
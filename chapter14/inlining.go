@@ -0,0 +1,271 @@
+package main
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// the most tacky instructions a function can have and still be inlined, labels don't count
+const INLINE_SIZE_LIMIT = 20
+const INLINE_KEYWORD_SIZE_LIMIT = 60
+
+// with -Os only functions that are about as small as the call itself are inlined
+const INLINE_SIZE_LIMIT_FOR_SIZE = 4
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// Replaces calls to small functions with a copy of the function's body. Returns true if anything changed.
+func inlineFunctions(tacky *Program_Tacky) bool {
+	functions := make(map[string]*Function_Definition_Tacky)
+	for _, item := range tacky.topItems {
+		fn, isFunc := item.(*Function_Definition_Tacky)
+		if isFunc {
+			functions[fn.name] = fn
+		}
+	}
+	recursive := findRecursiveFunctions(functions)
+	callCounts := countCallSites(tacky)
+
+	changed := false
+	for _, item := range tacky.topItems {
+		fn, isFunc := item.(*Function_Definition_Tacky)
+		if !isFunc {
+			continue
+		}
+
+		newBody := []Instruction_Tacky{}
+		for _, instr := range fn.body {
+			call, isCall := instr.(*Function_Call_Tacky)
+			if isCall {
+				callee, defined := functions[call.funcName]
+				if defined && !recursive[callee.name] && shouldInline(callee, callCounts[callee.name]) {
+					newBody = append(newBody, inlineCall(call, callee)...)
+					changed = true
+					continue
+				}
+			}
+			newBody = append(newBody, instr)
+		}
+		fn.body = newBody
+	}
+
+	if removeUnreferencedStaticFunctions(tacky) {
+		changed = true
+	}
+	return changed
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func shouldInline(callee *Function_Definition_Tacky, callCount int) bool {
+	// a static function that is only called once gets removed after it's inlined, so the code doesn't get bigger
+	if !callee.global && (callCount == 1) {
+		return true
+	}
+
+	size := 0
+	for _, instr := range callee.body {
+		_, isLabel := instr.(*Label_Instruction_Tacky)
+		if !isLabel {
+			size++
+		}
+	}
+
+	if optimizationSettings.level == "s" {
+		return size <= INLINE_SIZE_LIMIT_FOR_SIZE
+	}
+	if callee.isInline {
+		return size <= INLINE_KEYWORD_SIZE_LIMIT
+	}
+	return size <= INLINE_SIZE_LIMIT
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// a function is recursive if it can reach itself through the calls it makes, either directly or through other functions
+func findRecursiveFunctions(functions map[string]*Function_Definition_Tacky) map[string]bool {
+	callees := make(map[string][]string)
+	for name, fn := range functions {
+		for _, instr := range fn.body {
+			call, isCall := instr.(*Function_Call_Tacky)
+			if isCall {
+				callees[name] = append(callees[name], call.funcName)
+			}
+		}
+	}
+
+	recursive := make(map[string]bool)
+	for name, _ := range functions {
+		visited := make(map[string]bool)
+		worklist := append([]string{}, callees[name]...)
+		for len(worklist) > 0 {
+			current := worklist[0]
+			worklist = worklist[1:]
+			if current == name {
+				recursive[name] = true
+				break
+			}
+			if visited[current] {
+				continue
+			}
+			visited[current] = true
+			worklist = append(worklist, callees[current]...)
+		}
+	}
+	return recursive
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func countCallSites(tacky *Program_Tacky) map[string]int {
+	callCounts := make(map[string]int)
+	for _, item := range tacky.topItems {
+		fn, isFunc := item.(*Function_Definition_Tacky)
+		if !isFunc {
+			continue
+		}
+		for _, instr := range fn.body {
+			call, isCall := instr.(*Function_Call_Tacky)
+			if isCall {
+				callCounts[call.funcName]++
+			}
+		}
+	}
+	return callCounts
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// Static functions can only be called from this file, so once every call has been inlined they aren't needed. Returns
+// true if any were removed.
+func removeUnreferencedStaticFunctions(tacky *Program_Tacky) bool {
+	callCounts := countCallSites(tacky)
+
+	keptItems := []Top_Level_Tacky{}
+	for _, item := range tacky.topItems {
+		fn, isFunc := item.(*Function_Definition_Tacky)
+		if isFunc && !fn.global && (callCounts[fn.name] == 0) {
+			continue
+		}
+		keptItems = append(keptItems, item)
+	}
+
+	removed := len(keptItems) != len(tacky.topItems)
+	tacky.topItems = keptItems
+	return removed
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// Copies the callee's body in place of the call. The params become copies of the args, and each return becomes a
+// copy to the call's return value and a jump to the end of the inlined body.
+func inlineCall(call *Function_Call_Tacky, callee *Function_Definition_Tacky) []Instruction_Tacky {
+	// every local variable and label gets a new name, so inlining the same function twice doesn't mix them up
+	newVarNames := make(map[string]string)
+	renameValue := func(val Value_Tacky) Value_Tacky {
+		v, isVar := val.(*Variable_Value_Tacky)
+		if !isVar || (symbolTable[v.name].attrs == STATIC_ATTRIBUTES) {
+			return val
+		}
+		newName, found := newVarNames[v.name]
+		if !found {
+			newName = makeTempVarName(v.name)
+			symbolTable[newName] = symbolTable[v.name]
+			newVarNames[v.name] = newName
+		}
+		return &Variable_Value_Tacky{newName}
+	}
+	newLabelNames := make(map[string]string)
+	renameLabel := func(name string) string {
+		newName, found := newLabelNames[name]
+		if !found {
+			newName = makeLabelName(name + ".inlined")
+			newLabelNames[name] = newName
+		}
+		return newName
+	}
+	endLabel := makeLabelName("inline_end_" + callee.name + ".")
+
+	instructions := []Instruction_Tacky{}
+	for index, param := range callee.paramNames {
+		paramVal := renameValue(&Variable_Value_Tacky{param})
+		instructions = append(instructions, &Copy_Instruction_Tacky{src: call.args[index], dst: paramVal})
+	}
+
+	for _, instr := range callee.body {
+		ret, isRet := instr.(*Return_Instruction_Tacky)
+		if isRet {
+			instructions = append(instructions, &Copy_Instruction_Tacky{src: renameValue(ret.val), dst: call.returnVal})
+			instructions = append(instructions, &Jump_Instruction_Tacky{endLabel})
+			continue
+		}
+		instructions = append(instructions, renameInstruction(instr, renameValue, renameLabel))
+	}
+
+	instructions = append(instructions, &Label_Instruction_Tacky{endLabel})
+	return instructions
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// returns a new instruction with every value, including the dst, passed through renameValue and every label passed
+// through renameLabel
+func renameInstruction(instr Instruction_Tacky, renameValue func(Value_Tacky) Value_Tacky,
+	renameLabel func(string) string) Instruction_Tacky {
+
+	switch convertedInstr := instr.(type) {
+	case *Return_Instruction_Tacky:
+		return &Return_Instruction_Tacky{val: renameValue(convertedInstr.val)}
+	case *Sign_Extend_Instruction_Tacky:
+		return &Sign_Extend_Instruction_Tacky{src: renameValue(convertedInstr.src), dst: renameValue(convertedInstr.dst)}
+	case *Truncate_Instruction_Tacky:
+		return &Truncate_Instruction_Tacky{src: renameValue(convertedInstr.src), dst: renameValue(convertedInstr.dst)}
+	case *Zero_Extend_Instruction_Tacky:
+		return &Zero_Extend_Instruction_Tacky{src: renameValue(convertedInstr.src), dst: renameValue(convertedInstr.dst)}
+	case *Double_To_Int_Instruction_Tacky:
+		return &Double_To_Int_Instruction_Tacky{src: renameValue(convertedInstr.src), dst: renameValue(convertedInstr.dst)}
+	case *Double_To_UInt_Instruction_Tacky:
+		return &Double_To_UInt_Instruction_Tacky{src: renameValue(convertedInstr.src), dst: renameValue(convertedInstr.dst)}
+	case *Int_To_Double_Instruction_Tacky:
+		return &Int_To_Double_Instruction_Tacky{src: renameValue(convertedInstr.src), dst: renameValue(convertedInstr.dst)}
+	case *UInt_To_Double_Instruction_Tacky:
+		return &UInt_To_Double_Instruction_Tacky{src: renameValue(convertedInstr.src), dst: renameValue(convertedInstr.dst)}
+	case *Unary_Instruction_Tacky:
+		return &Unary_Instruction_Tacky{unOp: convertedInstr.unOp, src: renameValue(convertedInstr.src), dst: renameValue(convertedInstr.dst)}
+	case *Binary_Instruction_Tacky:
+		return &Binary_Instruction_Tacky{binOp: convertedInstr.binOp, src1: renameValue(convertedInstr.src1),
+			src2: renameValue(convertedInstr.src2), dst: renameValue(convertedInstr.dst)}
+	case *Copy_Instruction_Tacky:
+		return &Copy_Instruction_Tacky{src: renameValue(convertedInstr.src), dst: renameValue(convertedInstr.dst)}
+	case *Conditional_Copy_Instruction_Tacky:
+		return &Conditional_Copy_Instruction_Tacky{binOp: convertedInstr.binOp, left: renameValue(convertedInstr.left),
+			right: renameValue(convertedInstr.right), src1: renameValue(convertedInstr.src1), src2: renameValue(convertedInstr.src2),
+			dst: renameValue(convertedInstr.dst)}
+	case *Get_Address_Instruction_Tacky:
+		return &Get_Address_Instruction_Tacky{src: renameValue(convertedInstr.src), dst: renameValue(convertedInstr.dst)}
+	case *Load_Instruction_Tacky:
+		return &Load_Instruction_Tacky{srcPtr: renameValue(convertedInstr.srcPtr), dst: renameValue(convertedInstr.dst)}
+	case *Store_Instruction_Tacky:
+		return &Store_Instruction_Tacky{src: renameValue(convertedInstr.src), dstPtr: renameValue(convertedInstr.dstPtr)}
+	case *Jump_Instruction_Tacky:
+		return &Jump_Instruction_Tacky{renameLabel(convertedInstr.target)}
+	case *Jump_If_Zero_Instruction_Tacky:
+		return &Jump_If_Zero_Instruction_Tacky{condition: renameValue(convertedInstr.condition), target: renameLabel(convertedInstr.target)}
+	case *Jump_If_Not_Zero_Instruction_Tacky:
+		return &Jump_If_Not_Zero_Instruction_Tacky{condition: renameValue(convertedInstr.condition), target: renameLabel(convertedInstr.target)}
+	case *Jump_If_Compare_Instruction_Tacky:
+		return &Jump_If_Compare_Instruction_Tacky{binOp: convertedInstr.binOp, src1: renameValue(convertedInstr.src1),
+			src2: renameValue(convertedInstr.src2), target: renameLabel(convertedInstr.target)}
+	case *Label_Instruction_Tacky:
+		return &Label_Instruction_Tacky{renameLabel(convertedInstr.name)}
+	case *Function_Call_Tacky:
+		args := []Value_Tacky{}
+		for _, arg := range convertedInstr.args {
+			args = append(args, renameValue(arg))
+		}
+		return &Function_Call_Tacky{funcName: convertedInstr.funcName, args: args, returnVal: renameValue(convertedInstr.returnVal)}
+	}
+	fail("unknown tacky instruction when renaming")
+	return nil
+}
@@ -1,68 +1,12 @@
 package main
 
 import (
-	"regexp"
+	"strconv"
 	"strings"
 )
 
 /////////////////////////////////////////////////////////////////////////////////
 
-// For info on regex syntax:  https://github.com/google/re2/wiki/Syntax
-// * is 0 or more, \b is word boundary, use raw strings to make it easier
-// + is 1 or more, | is or, ? means it's optional
-// \w is the same as [0-9A-Za-z_]
-// \W is the same as [^0-9A-Za-z_] where ^ means not
-// \b means at ASCII word boundary, it won't capture the character that creates this word boundary (like a space),
-// \b means a transition from \w to \W for example
-var regexp_identifier *regexp.Regexp = regexp.MustCompile(`[a-zA-Z_][0-9A-Za-z_]*\b`)
-var regexp_int_constant *regexp.Regexp = regexp.MustCompile(`([0-9]+)[^\w.]`)
-var regexp_int_keyword *regexp.Regexp = regexp.MustCompile(`int\b`)
-var regexp_void_keyword *regexp.Regexp = regexp.MustCompile(`void\b`)
-var regexp_return_keyword *regexp.Regexp = regexp.MustCompile(`return\b`)
-var regexp_open_parenthesis *regexp.Regexp = regexp.MustCompile(`\(`)
-var regexp_close_parenthesis *regexp.Regexp = regexp.MustCompile(`\)`)
-var regexp_open_brace *regexp.Regexp = regexp.MustCompile(`{`)
-var regexp_close_brace *regexp.Regexp = regexp.MustCompile(`}`)
-var regexp_semicolon *regexp.Regexp = regexp.MustCompile(`;`)
-var regexp_tilde *regexp.Regexp = regexp.MustCompile(`~`)
-var regexp_hyphen *regexp.Regexp = regexp.MustCompile(`-`)
-var regexp_two_hyphens *regexp.Regexp = regexp.MustCompile(`--`)
-var regexp_plus *regexp.Regexp = regexp.MustCompile(`\+`)
-var regexp_asterisk *regexp.Regexp = regexp.MustCompile(`\*`)
-var regexp_forward_slash *regexp.Regexp = regexp.MustCompile(`/`)
-var regexp_percent *regexp.Regexp = regexp.MustCompile(`%`)
-var regexp_exclamation *regexp.Regexp = regexp.MustCompile(`!`)
-var regexp_two_ampersands *regexp.Regexp = regexp.MustCompile(`&&`)
-var regexp_two_vertical_bars *regexp.Regexp = regexp.MustCompile(`\|\|`)
-var regexp_two_equal_signs *regexp.Regexp = regexp.MustCompile(`==`)
-var regexp_exclamation_equal *regexp.Regexp = regexp.MustCompile(`!=`)
-var regexp_less_than *regexp.Regexp = regexp.MustCompile(`<`)
-var regexp_greater_than *regexp.Regexp = regexp.MustCompile(`>`)
-var regexp_less_or_equal *regexp.Regexp = regexp.MustCompile(`<=`)
-var regexp_greater_or_equal *regexp.Regexp = regexp.MustCompile(`>=`)
-var regexp_equal *regexp.Regexp = regexp.MustCompile(`=`)
-var regexp_if_keyword *regexp.Regexp = regexp.MustCompile(`if\b`)
-var regexp_else_keyword *regexp.Regexp = regexp.MustCompile(`else\b`)
-var regexp_question *regexp.Regexp = regexp.MustCompile(`\?`)
-var regexp_colon *regexp.Regexp = regexp.MustCompile(`:`)
-var regexp_do_keyword *regexp.Regexp = regexp.MustCompile(`do\b`)
-var regexp_while_keyword *regexp.Regexp = regexp.MustCompile(`while\b`)
-var regexp_for_keyword *regexp.Regexp = regexp.MustCompile(`for\b`)
-var regexp_break_keyword *regexp.Regexp = regexp.MustCompile(`break\b`)
-var regexp_continue_keyword *regexp.Regexp = regexp.MustCompile(`continue\b`)
-var regexp_comma *regexp.Regexp = regexp.MustCompile(`,`)
-var regexp_static_keyword *regexp.Regexp = regexp.MustCompile(`static\b`)
-var regexp_extern_keyword *regexp.Regexp = regexp.MustCompile(`extern\b`)
-var regexp_long_keyword *regexp.Regexp = regexp.MustCompile(`long\b`)
-var regexp_long_constant *regexp.Regexp = regexp.MustCompile(`([0-9]+[lL])[^\w.]`)
-var regexp_signed_keyword *regexp.Regexp = regexp.MustCompile(`signed\b`)
-var regexp_unsigned_keyword *regexp.Regexp = regexp.MustCompile(`unsigned\b`)
-var regexp_unsigned_int_constant *regexp.Regexp = regexp.MustCompile(`([0-9]+[uU])[^\w.]`)
-var regexp_unsigned_long_constant *regexp.Regexp = regexp.MustCompile(`([0-9]+([lL][uU]|[uU][lL]))[^\w.]`)
-var regexp_double_keyword *regexp.Regexp = regexp.MustCompile(`double\b`)
-var regexp_double_constant *regexp.Regexp = regexp.MustCompile(`(([0-9]*\.[0-9]+|[0-9]+\.?)[Ee][+-]?[0-9]+|[0-9]*\.[0-9]+|[0-9]+\.)[^\w.]`)
-var regexp_ampersand *regexp.Regexp = regexp.MustCompile(`&`)
-
 type TokenEnum int
 
 const (
@@ -115,78 +59,137 @@ const (
 	DOUBLE_KEYWORD_TOKEN
 	DOUBLE_CONSTANT_TOKEN
 	AMPERSAND_TOKEN
+	INLINE_KEYWORD_TOKEN
 )
 
 /////////////////////////////////////////////////////////////////////////////////
 
-var allRegexp = map[TokenEnum]*regexp.Regexp{
-	IDENTIFIER_TOKEN:             regexp_identifier,
-	INT_CONSTANT_TOKEN:           regexp_int_constant,
-	INT_KEYWORD_TOKEN:            regexp_int_keyword,
-	VOID_KEYWORD_TOKEN:           regexp_void_keyword,
-	RETURN_KEYWORD_TOKEN:         regexp_return_keyword,
-	OPEN_PARENTHESIS_TOKEN:       regexp_open_parenthesis,
-	CLOSE_PARENTHESIS_TOKEN:      regexp_close_parenthesis,
-	OPEN_BRACE_TOKEN:             regexp_open_brace,
-	CLOSE_BRACE_TOKEN:            regexp_close_brace,
-	SEMICOLON_TOKEN:              regexp_semicolon,
-	TILDE_TOKEN:                  regexp_tilde,
-	HYPHEN_TOKEN:                 regexp_hyphen,
-	TWO_HYPHENS_TOKEN:            regexp_two_hyphens,
-	PLUS_TOKEN:                   regexp_plus,
-	ASTERISK_TOKEN:               regexp_asterisk,
-	FORWARD_SLASH_TOKEN:          regexp_forward_slash,
-	PERCENT_TOKEN:                regexp_percent,
-	EXCLAMATION_TOKEN:            regexp_exclamation,
-	TWO_AMPERSANDS_TOKEN:         regexp_two_ampersands,
-	TWO_VERTICAL_BARS_TOKEN:      regexp_two_vertical_bars,
-	TWO_EQUAL_SIGNS_TOKEN:        regexp_two_equal_signs,
-	EXCLAMATION_EQUAL_TOKEN:      regexp_exclamation_equal,
-	LESS_THAN_TOKEN:              regexp_less_than,
-	GREATER_THAN_TOKEN:           regexp_greater_than,
-	LESS_OR_EQUAL_TOKEN:          regexp_less_or_equal,
-	GREATER_OR_EQUAL_TOKEN:       regexp_greater_or_equal,
-	EQUAL_TOKEN:                  regexp_equal,
-	IF_KEYWORD_TOKEN:             regexp_if_keyword,
-	ELSE_KEYWORD_TOKEN:           regexp_else_keyword,
-	QUESTION_TOKEN:               regexp_question,
-	COLON_TOKEN:                  regexp_colon,
-	DO_KEYWORD_TOKEN:             regexp_do_keyword,
-	WHILE_KEYWORD_TOKEN:          regexp_while_keyword,
-	FOR_KEYWORD_TOKEN:            regexp_for_keyword,
-	BREAK_KEYWORD_TOKEN:          regexp_break_keyword,
-	CONTINUE_KEYWORD_TOKEN:       regexp_continue_keyword,
-	COMMA_TOKEN:                  regexp_comma,
-	STATIC_KEYWORD_TOKEN:         regexp_static_keyword,
-	EXTERN_KEYWORD_TOKEN:         regexp_extern_keyword,
-	LONG_KEYWORD_TOKEN:           regexp_long_keyword,
-	LONG_CONSTANT_TOKEN:          regexp_long_constant,
-	SIGNED_KEYWORD_TOKEN:         regexp_signed_keyword,
-	UNSIGNED_KEYWORD_TOKEN:       regexp_unsigned_keyword,
-	UNSIGNED_INT_CONSTANT_TOKEN:  regexp_unsigned_int_constant,
-	UNSIGNED_LONG_CONSTANT_TOKEN: regexp_unsigned_long_constant,
-	DOUBLE_KEYWORD_TOKEN:         regexp_double_keyword,
-	DOUBLE_CONSTANT_TOKEN:        regexp_double_constant,
-	AMPERSAND_TOKEN:              regexp_ampersand,
+// the text of every token that is always spelled the same way, also used for error messages
+var tokenSpellings = map[TokenEnum]string{
+	INT_KEYWORD_TOKEN:       "int",
+	VOID_KEYWORD_TOKEN:      "void",
+	RETURN_KEYWORD_TOKEN:    "return",
+	OPEN_PARENTHESIS_TOKEN:  "(",
+	CLOSE_PARENTHESIS_TOKEN: ")",
+	OPEN_BRACE_TOKEN:        "{",
+	CLOSE_BRACE_TOKEN:       "}",
+	SEMICOLON_TOKEN:         ";",
+	TILDE_TOKEN:             "~",
+	HYPHEN_TOKEN:            "-",
+	TWO_HYPHENS_TOKEN:       "--",
+	PLUS_TOKEN:              "+",
+	ASTERISK_TOKEN:          "*",
+	FORWARD_SLASH_TOKEN:     "/",
+	PERCENT_TOKEN:           "%",
+	EXCLAMATION_TOKEN:       "!",
+	TWO_AMPERSANDS_TOKEN:    "&&",
+	TWO_VERTICAL_BARS_TOKEN: "||",
+	TWO_EQUAL_SIGNS_TOKEN:   "==",
+	EXCLAMATION_EQUAL_TOKEN: "!=",
+	LESS_THAN_TOKEN:         "<",
+	GREATER_THAN_TOKEN:      ">",
+	LESS_OR_EQUAL_TOKEN:     "<=",
+	GREATER_OR_EQUAL_TOKEN:  ">=",
+	EQUAL_TOKEN:             "=",
+	IF_KEYWORD_TOKEN:        "if",
+	ELSE_KEYWORD_TOKEN:      "else",
+	QUESTION_TOKEN:          "?",
+	COLON_TOKEN:             ":",
+	DO_KEYWORD_TOKEN:        "do",
+	WHILE_KEYWORD_TOKEN:     "while",
+	FOR_KEYWORD_TOKEN:       "for",
+	BREAK_KEYWORD_TOKEN:     "break",
+	CONTINUE_KEYWORD_TOKEN:  "continue",
+	COMMA_TOKEN:             ",",
+	STATIC_KEYWORD_TOKEN:    "static",
+	EXTERN_KEYWORD_TOKEN:    "extern",
+	LONG_KEYWORD_TOKEN:      "long",
+	SIGNED_KEYWORD_TOKEN:    "signed",
+	UNSIGNED_KEYWORD_TOKEN:  "unsigned",
+	DOUBLE_KEYWORD_TOKEN:    "double",
+	AMPERSAND_TOKEN:         "&",
+	INLINE_KEYWORD_TOKEN:    "inline",
+}
+
+var allKeywords = map[string]TokenEnum{
+	"int":      INT_KEYWORD_TOKEN,
+	"void":     VOID_KEYWORD_TOKEN,
+	"return":   RETURN_KEYWORD_TOKEN,
+	"if":       IF_KEYWORD_TOKEN,
+	"else":     ELSE_KEYWORD_TOKEN,
+	"do":       DO_KEYWORD_TOKEN,
+	"while":    WHILE_KEYWORD_TOKEN,
+	"for":      FOR_KEYWORD_TOKEN,
+	"break":    BREAK_KEYWORD_TOKEN,
+	"continue": CONTINUE_KEYWORD_TOKEN,
+	"static":   STATIC_KEYWORD_TOKEN,
+	"extern":   EXTERN_KEYWORD_TOKEN,
+	"long":     LONG_KEYWORD_TOKEN,
+	"signed":   SIGNED_KEYWORD_TOKEN,
+	"unsigned": UNSIGNED_KEYWORD_TOKEN,
+	"double":   DOUBLE_KEYWORD_TOKEN,
+	"inline":   INLINE_KEYWORD_TOKEN,
+}
+
+// the operators that are two characters long, they take priority over the one character operators
+var twoCharOperators = map[string]TokenEnum{
+	"--": TWO_HYPHENS_TOKEN,
+	"&&": TWO_AMPERSANDS_TOKEN,
+	"||": TWO_VERTICAL_BARS_TOKEN,
+	"==": TWO_EQUAL_SIGNS_TOKEN,
+	"!=": EXCLAMATION_EQUAL_TOKEN,
+	"<=": LESS_OR_EQUAL_TOKEN,
+	">=": GREATER_OR_EQUAL_TOKEN,
+}
+
+var oneCharOperators = map[byte]TokenEnum{
+	'(': OPEN_PARENTHESIS_TOKEN,
+	')': CLOSE_PARENTHESIS_TOKEN,
+	'{': OPEN_BRACE_TOKEN,
+	'}': CLOSE_BRACE_TOKEN,
+	';': SEMICOLON_TOKEN,
+	'~': TILDE_TOKEN,
+	'-': HYPHEN_TOKEN,
+	'+': PLUS_TOKEN,
+	'*': ASTERISK_TOKEN,
+	'/': FORWARD_SLASH_TOKEN,
+	'%': PERCENT_TOKEN,
+	'!': EXCLAMATION_TOKEN,
+	'<': LESS_THAN_TOKEN,
+	'>': GREATER_THAN_TOKEN,
+	'=': EQUAL_TOKEN,
+	'?': QUESTION_TOKEN,
+	':': COLON_TOKEN,
+	',': COMMA_TOKEN,
+	'&': AMPERSAND_TOKEN,
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func describeToken(enum TokenEnum) string {
+	spelling, found := tokenSpellings[enum]
+	if found {
+		return spelling
+	}
+
+	switch enum {
+	case IDENTIFIER_TOKEN:
+		return "identifier"
+	case INT_CONSTANT_TOKEN, LONG_CONSTANT_TOKEN, UNSIGNED_INT_CONSTANT_TOKEN, UNSIGNED_LONG_CONSTANT_TOKEN:
+		return "integer constant"
+	case DOUBLE_CONSTANT_TOKEN:
+		return "floating constant"
+	}
+	return "end of file"
 }
 
-var allKeywordRegexp = map[TokenEnum]*regexp.Regexp{
-	INT_KEYWORD_TOKEN:      regexp_int_keyword,
-	VOID_KEYWORD_TOKEN:     regexp_void_keyword,
-	RETURN_KEYWORD_TOKEN:   regexp_return_keyword,
-	IF_KEYWORD_TOKEN:       regexp_if_keyword,
-	ELSE_KEYWORD_TOKEN:     regexp_else_keyword,
-	DO_KEYWORD_TOKEN:       regexp_do_keyword,
-	WHILE_KEYWORD_TOKEN:    regexp_while_keyword,
-	FOR_KEYWORD_TOKEN:      regexp_for_keyword,
-	BREAK_KEYWORD_TOKEN:    regexp_break_keyword,
-	CONTINUE_KEYWORD_TOKEN: regexp_continue_keyword,
-	STATIC_KEYWORD_TOKEN:   regexp_static_keyword,
-	EXTERN_KEYWORD_TOKEN:   regexp_extern_keyword,
-	LONG_KEYWORD_TOKEN:     regexp_long_keyword,
-	SIGNED_KEYWORD_TOKEN:   regexp_signed_keyword,
-	UNSIGNED_KEYWORD_TOKEN: regexp_unsigned_keyword,
-	DOUBLE_KEYWORD_TOKEN:   regexp_double_keyword,
+/////////////////////////////////////////////////////////////////////////////////
+
+// the position of a token in the original source file, lines and columns start at 1
+type Source_Location struct {
+	file string
+	line int
+	col  int
 }
 
 /////////////////////////////////////////////////////////////////////////////////
@@ -194,21 +197,27 @@ var allKeywordRegexp = map[TokenEnum]*regexp.Regexp{
 type Token struct {
 	word      string
 	tokenType TokenEnum
+	loc       Source_Location
 }
 
-func doLexer(fileContents string) []Token {
-	var allTokens []Token
+// the lexer walks through the file one time, each token is found by looking at its first character
+type Lexer struct {
+	contents string
+	pos      int
+	loc      Source_Location
+}
 
-	fileContents, token := getNextToken(fileContents)
-	for token.tokenType != NONE_TOKEN {
-		allTokens = append(allTokens, token)
-		fileContents, token = getNextToken(fileContents)
-	}
+/////////////////////////////////////////////////////////////////////////////////
+
+func doLexer(fileContents string, filename string) []Token {
+	// C code has about one token for every four characters, so the slice rarely has to grow and be copied
+	allTokens := make([]Token, 0, len(fileContents)/4)
 
-	// if there is still data in fileContents then we have data that didn't match a regexp, so generate error
-	finalContents := strings.TrimLeft(fileContents, " \n\r\t")
-	if len(finalContents) > 0 {
-		fail("some data could not be tokenized:", finalContents)
+	lex := Lexer{contents: fileContents, pos: 0, loc: Source_Location{file: filename, line: 1, col: 1}}
+	lex.skipWhitespace()
+	for lex.pos < len(lex.contents) {
+		allTokens = append(allTokens, lex.getNextToken())
+		lex.skipWhitespace()
 	}
 
 	return allTokens
@@ -216,78 +225,212 @@ func doLexer(fileContents string) []Token {
 
 /////////////////////////////////////////////////////////////////////////////////
 
-func getNextToken(contents string) (newContents string, token Token) {
-	// remove whitespace from beginning
-	newContents = strings.TrimLeft(contents, " \n\r\t")
+func (lex *Lexer) getNextToken() Token {
+	start := lex.pos
+	token := Token{loc: lex.loc}
+
+	ch := lex.contents[lex.pos]
+	if isIdentifierStart(ch) {
+		for (lex.pos < len(lex.contents)) && isIdentifierChar(lex.contents[lex.pos]) {
+			lex.pos++
+		}
+		token.word = lex.contents[start:lex.pos]
+
+		// keywords take priority over identifiers
+		keyword, isKeyword := allKeywords[token.word]
+		if isKeyword {
+			token.tokenType = keyword
+		} else {
+			token.tokenType = IDENTIFIER_TOKEN
+		}
+	} else if isDigit(ch) || ((ch == '.') && isDigit(lex.peekAt(1))) {
+		token.tokenType = lex.scanConstant()
+		token.word = lex.contents[start:lex.pos]
+	} else if enum, found := twoCharOperators[lex.contents[start:min(start+2, len(lex.contents))]]; found {
+		lex.pos += 2
+		token.word = lex.contents[start:lex.pos]
+		token.tokenType = enum
+	} else if enum, found := oneCharOperators[ch]; found {
+		lex.pos++
+		token.word = lex.contents[start:lex.pos]
+		token.tokenType = enum
+	} else {
+		lex.failToTokenize(start)
+	}
+
+	// tokens never span multiple lines
+	lex.loc.col += lex.pos - start
+	return token
+}
 
-	// use the regexp to find the longest match at beginning
-	enum, start, end := longestMatchAtStart(newContents)
-	//fmt.Printf("%v %v %v\n", enum, start, end)
+/////////////////////////////////////////////////////////////////////////////////
 
-	// gather the token info
-	token = Token{word: newContents[start:end], tokenType: enum}
+// works like the regular expressions we used to have for each kind of constant:
+// int       [0-9]+
+// long      [0-9]+[lL]
+// unsigned  [0-9]+[uU] or [0-9]+([lL][uU]|[uU][lL])
+// double    ([0-9]*\.[0-9]+|[0-9]+\.?)[Ee][+-]?[0-9]+ or [0-9]*\.[0-9]+ or [0-9]+\.
+// and all of them must be followed by something that's not a letter, digit, underscore, or period
+func (lex *Lexer) scanConstant() TokenEnum {
+	start := lex.pos
+	lex.skipDigits()
 
-	// remove the word from the beginning of the string
-	newContents = newContents[end:]
+	isDouble := false
+	if lex.peekAt(0) == '.' {
+		isDouble = true
+		lex.pos++
+		lex.skipDigits()
+	}
 
-	// the regexp for identifiers might also match keywords, keywords should take priority
-	// so switch the enum from identifier to keyword if necessary
-	if token.tokenType == IDENTIFIER_TOKEN {
-		token.tokenType = keywordOrIdentifier(token.word)
+	// the exponent only counts if there is at least one digit in it
+	if (lex.peekAt(0) == 'e') || (lex.peekAt(0) == 'E') {
+		digitOffset := 1
+		if (lex.peekAt(1) == '+') || (lex.peekAt(1) == '-') {
+			digitOffset = 2
+		}
+		if isDigit(lex.peekAt(digitOffset)) {
+			isDouble = true
+			lex.pos += digitOffset
+			lex.skipDigits()
+		}
 	}
 
-	return newContents, token
+	enum := DOUBLE_CONSTANT_TOKEN
+	if !isDouble {
+		enum = lex.scanIntegerSuffix()
+	}
+
+	next := lex.peekAt(0)
+	if isIdentifierChar(next) || (next == '.') {
+		lex.failToTokenize(start)
+	}
+	return enum
 }
 
 /////////////////////////////////////////////////////////////////////////////////
 
-func longestMatchAtStart(contents string) (TokenEnum, int, int) {
-	start, end := 0, 0
-	groupStart, groupEnd := 0, 0
-	var detectedTokenType TokenEnum = NONE_TOKEN
-
-	for enum, re := range allRegexp {
-		result := re.FindStringSubmatchIndex(contents)
-
-		// if it found something and if the first index is at the beginning of the string
-		if result != nil && result[0] == 0 {
-			// save it if the word is longer than what we've found before
-			if (result[1] - result[0]) > (end - start) {
-				detectedTokenType = enum
-				start = result[0]
-				end = result[1]
-
-				// save group 1 if it was found
-				if len(result) > 2 {
-					groupStart = result[2]
-					groupEnd = result[3]
-				}
-			}
-		}
+func (lex *Lexer) scanIntegerSuffix() TokenEnum {
+	first := lex.peekAt(0)
+	second := lex.peekAt(1)
+	isLong := func(ch byte) bool { return (ch == 'l') || (ch == 'L') }
+	isUnsigned := func(ch byte) bool { return (ch == 'u') || (ch == 'U') }
+
+	if (isLong(first) && isUnsigned(second)) || (isUnsigned(first) && isLong(second)) {
+		lex.pos += 2
+		return UNSIGNED_LONG_CONSTANT_TOKEN
+	} else if isLong(first) {
+		lex.pos++
+		return LONG_CONSTANT_TOKEN
+	} else if isUnsigned(first) {
+		lex.pos++
+		return UNSIGNED_INT_CONSTANT_TOKEN
 	}
+	return INT_CONSTANT_TOKEN
+}
 
-	// send back the group if we found it
-	if groupEnd > 0 {
-		return detectedTokenType, groupStart, groupEnd
-	} else {
-		return detectedTokenType, start, end
+/////////////////////////////////////////////////////////////////////////////////
+
+func (lex *Lexer) skipDigits() {
+	for (lex.pos < len(lex.contents)) && isDigit(lex.contents[lex.pos]) {
+		lex.pos++
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// returns 0 past the end of the file, which isn't part of any token
+func (lex *Lexer) peekAt(offset int) byte {
+	if (lex.pos + offset) < len(lex.contents) {
+		return lex.contents[lex.pos+offset]
 	}
+	return 0
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func (lex *Lexer) failToTokenize(start int) {
+	lineEnd := strings.IndexByte(lex.contents[start:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(lex.contents) - start
+	}
+	badToken := Token{word: lex.contents[start:lex.pos], loc: lex.loc}
+	if len(badToken.word) == 0 {
+		badToken.word = lex.contents[start : start+1]
+	}
+	failWithDiagnostic(makeTokenDiagnostic(ERROR_SEVERITY, badToken, "some data could not be tokenized: "+
+		lex.contents[start:start+lineEnd]))
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func isIdentifierStart(ch byte) bool {
+	return ((ch >= 'a') && (ch <= 'z')) || ((ch >= 'A') && (ch <= 'Z')) || (ch == '_')
+}
+
+func isIdentifierChar(ch byte) bool {
+	return isIdentifierStart(ch) || isDigit(ch)
+}
+
+func isDigit(ch byte) bool {
+	return (ch >= '0') && (ch <= '9')
 }
 
 /////////////////////////////////////////////////////////////////////////////////
 
-func keywordOrIdentifier(word string) TokenEnum {
-	// This function assumes the word has already been labeled an IDENTIFIER.
-	// Loop through all keyword regexp's to see if it matches a keyword.
-	// But the regex must match at the beginning.
-	for enum, re := range allKeywordRegexp {
-		result := re.FindStringIndex(word)
-		// if it found a match and if the first index is at the beginning of the string
-		if result != nil && result[0] == 0 {
-			return enum
+func (lex *Lexer) skipWhitespace() {
+	for lex.pos < len(lex.contents) {
+		switch lex.contents[lex.pos] {
+		case '\n':
+			lex.loc.line++
+			lex.loc.col = 1
+			lex.pos++
+		case ' ', '\r', '\t':
+			lex.loc.col++
+			lex.pos++
+		case '#':
+			// the preprocessor leaves line markers like: # 12 "test.c" 2
+			// they tell us which file and line the next line of code came from
+			lineEnd := strings.IndexByte(lex.contents[lex.pos:], '\n')
+			if lineEnd < 0 {
+				lineEnd = len(lex.contents) - lex.pos
+			}
+			lex.loc = parseLineMarker(lex.contents[lex.pos:lex.pos+lineEnd], lex.loc)
+			lex.pos += lineEnd
+			if lex.pos < len(lex.contents) {
+				// the newline after the marker doesn't count, the marker already gave us the next line number
+				lex.pos++
+			}
+		default:
+			return
 		}
 	}
-	return IDENTIFIER_TOKEN
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func parseLineMarker(marker string, loc Source_Location) Source_Location {
+	fields := strings.Fields(strings.TrimPrefix(marker, "#"))
+	if len(fields) == 0 {
+		// an empty directive, just go to the next line
+		loc.line++
+		loc.col = 1
+		return loc
+	}
+
+	line, err := strconv.Atoi(fields[0])
+	if err != nil {
+		// not a line marker (maybe a #pragma), so it's just skipped
+		loc.line++
+		loc.col = 1
+		return loc
+	}
+
+	loc.line = line
+	loc.col = 1
+	if len(fields) > 1 {
+		loc.file = strings.Trim(fields[1], "\"")
+	}
+	return loc
 }
 
 /////////////////////////////////////////////////////////////////////////////////
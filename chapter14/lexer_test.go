@@ -0,0 +1,269 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// The regular expressions the lexer used to have, kept here to check that the scanner finds the same tokens. They
+// are anchored with ^ so they don't search the whole file for every token, which gives the same matches as the old
+// check that the match started at index 0. Constants have their token in group 1, the rest of the match is the
+// character after the constant.
+var referenceRegexps = []struct {
+	enum TokenEnum
+	re   *regexp.Regexp
+}{
+	{IDENTIFIER_TOKEN, regexp.MustCompile(`^[a-zA-Z_][0-9A-Za-z_]*\b`)},
+	{INT_CONSTANT_TOKEN, regexp.MustCompile(`^([0-9]+)[^\w.]`)},
+	{LONG_CONSTANT_TOKEN, regexp.MustCompile(`^([0-9]+[lL])[^\w.]`)},
+	{UNSIGNED_INT_CONSTANT_TOKEN, regexp.MustCompile(`^([0-9]+[uU])[^\w.]`)},
+	{UNSIGNED_LONG_CONSTANT_TOKEN, regexp.MustCompile(`^([0-9]+([lL][uU]|[uU][lL]))[^\w.]`)},
+	{DOUBLE_CONSTANT_TOKEN, regexp.MustCompile(`^(([0-9]*\.[0-9]+|[0-9]+\.?)[Ee][+-]?[0-9]+|[0-9]*\.[0-9]+|[0-9]+\.)[^\w.]`)},
+	{OPEN_PARENTHESIS_TOKEN, regexp.MustCompile(`^\(`)},
+	{CLOSE_PARENTHESIS_TOKEN, regexp.MustCompile(`^\)`)},
+	{OPEN_BRACE_TOKEN, regexp.MustCompile(`^{`)},
+	{CLOSE_BRACE_TOKEN, regexp.MustCompile(`^}`)},
+	{SEMICOLON_TOKEN, regexp.MustCompile(`^;`)},
+	{TILDE_TOKEN, regexp.MustCompile(`^~`)},
+	{HYPHEN_TOKEN, regexp.MustCompile(`^-`)},
+	{TWO_HYPHENS_TOKEN, regexp.MustCompile(`^--`)},
+	{PLUS_TOKEN, regexp.MustCompile(`^\+`)},
+	{ASTERISK_TOKEN, regexp.MustCompile(`^\*`)},
+	{FORWARD_SLASH_TOKEN, regexp.MustCompile(`^/`)},
+	{PERCENT_TOKEN, regexp.MustCompile(`^%`)},
+	{EXCLAMATION_TOKEN, regexp.MustCompile(`^!`)},
+	{TWO_AMPERSANDS_TOKEN, regexp.MustCompile(`^&&`)},
+	{TWO_VERTICAL_BARS_TOKEN, regexp.MustCompile(`^\|\|`)},
+	{TWO_EQUAL_SIGNS_TOKEN, regexp.MustCompile(`^==`)},
+	{EXCLAMATION_EQUAL_TOKEN, regexp.MustCompile(`^!=`)},
+	{LESS_THAN_TOKEN, regexp.MustCompile(`^<`)},
+	{GREATER_THAN_TOKEN, regexp.MustCompile(`^>`)},
+	{LESS_OR_EQUAL_TOKEN, regexp.MustCompile(`^<=`)},
+	{GREATER_OR_EQUAL_TOKEN, regexp.MustCompile(`^>=`)},
+	{EQUAL_TOKEN, regexp.MustCompile(`^=`)},
+	{QUESTION_TOKEN, regexp.MustCompile(`^\?`)},
+	{COLON_TOKEN, regexp.MustCompile(`^:`)},
+	{COMMA_TOKEN, regexp.MustCompile(`^,`)},
+	{AMPERSAND_TOKEN, regexp.MustCompile(`^&`)},
+}
+
+// the identifier regexp matches keywords too, they were switched to the keyword afterwards
+var referenceKeywordRegexps = map[TokenEnum]*regexp.Regexp{
+	INT_KEYWORD_TOKEN:      regexp.MustCompile(`^int\b`),
+	VOID_KEYWORD_TOKEN:     regexp.MustCompile(`^void\b`),
+	RETURN_KEYWORD_TOKEN:   regexp.MustCompile(`^return\b`),
+	IF_KEYWORD_TOKEN:       regexp.MustCompile(`^if\b`),
+	ELSE_KEYWORD_TOKEN:     regexp.MustCompile(`^else\b`),
+	DO_KEYWORD_TOKEN:       regexp.MustCompile(`^do\b`),
+	WHILE_KEYWORD_TOKEN:    regexp.MustCompile(`^while\b`),
+	FOR_KEYWORD_TOKEN:      regexp.MustCompile(`^for\b`),
+	BREAK_KEYWORD_TOKEN:    regexp.MustCompile(`^break\b`),
+	CONTINUE_KEYWORD_TOKEN: regexp.MustCompile(`^continue\b`),
+	STATIC_KEYWORD_TOKEN:   regexp.MustCompile(`^static\b`),
+	EXTERN_KEYWORD_TOKEN:   regexp.MustCompile(`^extern\b`),
+	LONG_KEYWORD_TOKEN:     regexp.MustCompile(`^long\b`),
+	SIGNED_KEYWORD_TOKEN:   regexp.MustCompile(`^signed\b`),
+	UNSIGNED_KEYWORD_TOKEN: regexp.MustCompile(`^unsigned\b`),
+	DOUBLE_KEYWORD_TOKEN:   regexp.MustCompile(`^double\b`),
+	INLINE_KEYWORD_TOKEN:   regexp.MustCompile(`^inline\b`),
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// the old regexp lexer, returns false if some data couldn't be tokenized
+func doReferenceLexer(contents string, filename string) ([]Token, bool) {
+	tokens := []Token{}
+	loc := Source_Location{file: filename, line: 1, col: 1}
+	for {
+		contents, loc = skipReferenceWhitespace(contents, loc)
+		if len(contents) == 0 {
+			return tokens, true
+		}
+
+		// the longest match wins, and the first one in the list wins a tie
+		enum, start, end := NONE_TOKEN, 0, 0
+		for _, candidate := range referenceRegexps {
+			result := candidate.re.FindStringSubmatchIndex(contents)
+			if (result == nil) || (result[1] <= end) {
+				continue
+			}
+			enum, end = candidate.enum, result[1]
+			start = 0
+			if len(result) > 2 {
+				start, end = result[2], result[3]
+			}
+		}
+		if enum == NONE_TOKEN {
+			return tokens, false
+		}
+
+		token := Token{word: contents[start:end], tokenType: enum, loc: loc}
+		if enum == IDENTIFIER_TOKEN {
+			for keyword, re := range referenceKeywordRegexps {
+				if re.MatchString(token.word) {
+					token.tokenType = keyword
+				}
+			}
+		}
+		tokens = append(tokens, token)
+		contents = contents[end:]
+		loc.col += end
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func skipReferenceWhitespace(contents string, loc Source_Location) (string, Source_Location) {
+	index := 0
+	for index < len(contents) {
+		switch contents[index] {
+		case '\n':
+			loc.line++
+			loc.col = 1
+			index++
+		case ' ', '\r', '\t':
+			loc.col++
+			index++
+		case '#':
+			lineEnd := strings.IndexByte(contents[index:], '\n')
+			if lineEnd < 0 {
+				lineEnd = len(contents) - index
+			}
+			loc = parseLineMarker(contents[index:index+lineEnd], loc)
+			index += lineEnd
+			if index < len(contents) {
+				index++
+			}
+		default:
+			return contents[index:], loc
+		}
+	}
+	return contents[index:], loc
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// The old regexps needed a character after a constant, so every source ends with a newline.
+var lexerTestSources = []struct {
+	name     string
+	contents string
+}{
+	{"keywords and identifiers", "int integer; long longer _int int_ inline inlined do double doubled if iffy\n" +
+		"static extern signed unsigned void return else while for break continue x1 _ __a9\n"},
+	{"integer constants", "0 1 42 2147483648 9223372036854775807 007\n"},
+	{"suffixes", "1l 2L 3u 4U 5ul 6UL 7lu 8LU 9uL 10Lu\n"},
+	{"doubles", "1.0 .5 5. 1e10 1E+10 2.5e-3 .5e1 5.e2 0.0 100.\n"},
+	{"operators", "a--b - -c && & || == = != ! <= < >= > ? : , ; ~ + * / % ( ) { }\n"},
+	{"no spaces", "x=y+1;if(x<=2)return-x;else{z=&x;}\n"},
+	{"line markers", "# 1 \"test.c\"\nint\n# 12 \"other.h\" 1\nlong x;\n#\n#pragma once\n  y = 3;\n# 40 \"test.c\" 2\nz\n"},
+	{"tabs and carriage returns", "\tint\tx = 1;\r\n\t\treturn x;\r\n"},
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func TestLexerMatchesRegexpLexer(t *testing.T) {
+	sources := lexerTestSources
+	// the test programs for each chapter only use things the later chapters still support
+	files, _ := filepath.Glob("../chapter*/*.c")
+	for _, file := range files {
+		contents, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("can't read %v: %v", file, err)
+		}
+		sources = append(sources, struct {
+			name     string
+			contents string
+		}{file, string(contents) + "\n"})
+	}
+
+	for _, source := range sources {
+		t.Run(source.name, func(t *testing.T) {
+			expected, ok := doReferenceLexer(source.contents, "test.c")
+			if !ok {
+				t.Fatalf("the regexp lexer couldn't tokenize the source")
+			}
+			actual := doLexer(source.contents, "test.c")
+
+			if len(actual) != len(expected) {
+				t.Fatalf("got %v tokens, expected %v", len(actual), len(expected))
+			}
+			for index, token := range actual {
+				if token != expected[index] {
+					t.Errorf("token %v is %+v, expected %+v", index, token, expected[index])
+				}
+			}
+		})
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func TestLexerLocations(t *testing.T) {
+	tokens := doLexer("# 7 \"main.c\"\nint x;\n  return 10L;\n", "test.c")
+	expected := []Token{
+		{word: "int", tokenType: INT_KEYWORD_TOKEN, loc: Source_Location{file: "main.c", line: 7, col: 1}},
+		{word: "x", tokenType: IDENTIFIER_TOKEN, loc: Source_Location{file: "main.c", line: 7, col: 5}},
+		{word: ";", tokenType: SEMICOLON_TOKEN, loc: Source_Location{file: "main.c", line: 7, col: 6}},
+		{word: "return", tokenType: RETURN_KEYWORD_TOKEN, loc: Source_Location{file: "main.c", line: 8, col: 3}},
+		{word: "10L", tokenType: LONG_CONSTANT_TOKEN, loc: Source_Location{file: "main.c", line: 8, col: 10}},
+		{word: ";", tokenType: SEMICOLON_TOKEN, loc: Source_Location{file: "main.c", line: 8, col: 13}},
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("got %v tokens, expected %v", len(tokens), len(expected))
+	}
+	for index, token := range tokens {
+		if token != expected[index] {
+			t.Errorf("token %v is %+v, expected %+v", index, token, expected[index])
+		}
+	}
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// repeats a small function until the source is at least size bytes, with a line marker now and then like gcc -E
+func makeLexerBenchmarkSource(size int) string {
+	var builder strings.Builder
+	for index := 0; builder.Len() < size; index++ {
+		if (index % 100) == 0 {
+			builder.WriteString("# " + strconv.Itoa(index) + " \"bench.c\"\n")
+		}
+		name := "function_" + strconv.Itoa(index)
+		builder.WriteString("static long " + name + "(long a, unsigned int b, double d) {\n" +
+			"    long total = 0L;\n" +
+			"    for (int i = 0; i <= 100; i = i + 1) {\n" +
+			"        if ((a != 3ul) && (b >= 42u) || !(d == 1.5e-3)) total = total + i * 2 % 7;\n" +
+			"        else total = total - -a / 4;\n" +
+			"    }\n" +
+			"    return total > 0 ? total : .5;\n" +
+			"}\n")
+	}
+	return builder.String()
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// The scanner looks at each character a fixed number of times, so the MB/s should stay about the same as the input
+// gets bigger. The regexp lexer searched the rest of the file for every token and got slower with each size.
+func BenchmarkLexer(b *testing.B) {
+	for _, megabytes := range []int{1, 2, 4, 8, 16} {
+		source := makeLexerBenchmarkSource(megabytes << 20)
+		b.Run(strconv.Itoa(megabytes)+"MB", func(b *testing.B) {
+			b.SetBytes(int64(len(source)))
+			for b.Loop() {
+				doLexer(source, "bench.c")
+			}
+		})
+	}
+}
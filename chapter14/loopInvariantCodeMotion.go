@@ -0,0 +1,230 @@
+package main
+
+import "sort"
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// A natural loop is found from the jumps back to a block that dominates the jump (the header). The loops that share
+// a header are merged into one.
+type Natural_Loop struct {
+	header *Basic_Block
+	blocks map[*Basic_Block]bool
+	// the blocks that jump back to the header
+	latches []*Basic_Block
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// Moves the instructions that compute the same value every time through a loop to right before the loop. Only loops
+// that have a single block leading into them are changed. Instructions that can crash, like loads and divisions, are
+// only moved if they would have run anyway, and loads are only moved out of loops that can't write to memory.
+func moveLoopInvariants(ssa *SSA_Function) bool {
+	defBlocks := make(map[string]*Basic_Block)
+	for _, block := range ssa.cfg.blocks {
+		for _, instr := range block.instructions {
+			dst, isVar := getInstructionDst(instr).(*Variable_Value_Tacky)
+			if isVar {
+				defBlocks[dst.name] = block
+			}
+		}
+	}
+
+	changed := false
+	for _, loop := range findNaturalLoops(ssa) {
+		if ssa.hoistLoopInvariants(loop, defBlocks) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// the inner loops come first, so what is moved out of them can be moved out of the outer loops too
+func findNaturalLoops(ssa *SSA_Function) []*Natural_Loop {
+	loops := []*Natural_Loop{}
+	headerToLoop := make(map[*Basic_Block]*Natural_Loop)
+	for _, block := range ssa.cfg.blocks {
+		for _, succ := range block.succs {
+			if !ssa.dominates(succ, block) {
+				continue
+			}
+			loop, found := headerToLoop[succ]
+			if !found {
+				loop = &Natural_Loop{header: succ, blocks: map[*Basic_Block]bool{succ: true}}
+				headerToLoop[succ] = loop
+				loops = append(loops, loop)
+			}
+			loop.latches = append(loop.latches, block)
+
+			// everything that can reach the latch without going through the header is in the loop
+			stack := []*Basic_Block{block}
+			for len(stack) > 0 {
+				current := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				if loop.blocks[current] {
+					continue
+				}
+				loop.blocks[current] = true
+				stack = append(stack, current.preds...)
+			}
+		}
+	}
+
+	sort.SliceStable(loops, func(i int, j int) bool {
+		return len(loops[i].blocks) < len(loops[j].blocks)
+	})
+	return loops
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// Returns the block that leads into the loop and a function that puts an instruction between that block and the
+// header, or nil if there isn't exactly one block that leads into the loop. If the block can also go somewhere else,
+// the instructions go before the header's label, which only works when the block falls through to the header.
+func (loop *Natural_Loop) getPreheader(cfg *Control_Flow_Graph) (*Basic_Block, func(Instruction_Tacky)) {
+	var preheader *Basic_Block
+	for _, pred := range loop.header.preds {
+		if loop.blocks[pred] {
+			continue
+		}
+		if preheader != nil {
+			return nil, nil
+		}
+		preheader = pred
+	}
+	if preheader == nil {
+		return nil, nil
+	}
+
+	if len(preheader.succs) == 1 {
+		return preheader, func(instr Instruction_Tacky) { cfg.insertAtEnd(preheader, instr) }
+	}
+	lbl, isLabel := loop.header.instructions[0].(*Label_Instruction_Tacky)
+	if !isLabel {
+		return nil, nil
+	}
+	switch convertedInstr := preheader.instructions[len(preheader.instructions)-1].(type) {
+	case *Jump_If_Zero_Instruction_Tacky:
+		if convertedInstr.target == lbl.name {
+			return nil, nil
+		}
+	case *Jump_If_Not_Zero_Instruction_Tacky:
+		if convertedInstr.target == lbl.name {
+			return nil, nil
+		}
+	case *Jump_If_Compare_Instruction_Tacky:
+		if convertedInstr.target == lbl.name {
+			return nil, nil
+		}
+	default:
+		return nil, nil
+	}
+	return preheader, func(instr Instruction_Tacky) { loop.header.insertBeforeLabel(instr) }
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// an instruction in this block runs every time the loop is entered, before the loop ends or goes around again
+func (ssa *SSA_Function) alwaysRunsInLoop(loop *Natural_Loop, block *Basic_Block) bool {
+	for current, _ := range loop.blocks {
+		leavesLoop := containsBlock(loop.latches, current)
+		for _, succ := range current.succs {
+			if !loop.blocks[succ] {
+				leavesLoop = true
+			}
+		}
+		if leavesLoop && !ssa.dominates(block, current) {
+			return false
+		}
+	}
+	return true
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func (ssa *SSA_Function) hoistLoopInvariants(loop *Natural_Loop, defBlocks map[string]*Basic_Block) bool {
+	preheader, insertInPreheader := loop.getPreheader(ssa.cfg)
+	if preheader == nil {
+		return false
+	}
+
+	writesMemory := false
+	for block, _ := range loop.blocks {
+		for _, instr := range block.instructions {
+			switch instr.(type) {
+			case *Store_Instruction_Tacky, *Function_Call_Tacky:
+				writesMemory = true
+			}
+			dst, isVar := getInstructionDst(instr).(*Variable_Value_Tacky)
+			if isVar && !ssa.isSingleAssignment(dst) {
+				writesMemory = true
+			}
+		}
+	}
+
+	isInvariant := func(val Value_Tacky) bool {
+		v, isVar := val.(*Variable_Value_Tacky)
+		if !isVar {
+			return true
+		}
+		return ssa.isSingleAssignment(v) && !loop.blocks[defBlocks[v.name]]
+	}
+
+	// the instructions are added after the loop is done with the blocks, since the header can get some of them too
+	hoisted := []Instruction_Tacky{}
+	for _, block := range ssa.cfg.getReversePostorder() {
+		if !loop.blocks[block] {
+			continue
+		}
+		kept := []Instruction_Tacky{}
+		for _, instr := range block.instructions {
+			canMove := false
+			switch convertedInstr := instr.(type) {
+			case *Unary_Instruction_Tacky, *Sign_Extend_Instruction_Tacky, *Truncate_Instruction_Tacky,
+				*Zero_Extend_Instruction_Tacky, *Double_To_Int_Instruction_Tacky, *Double_To_UInt_Instruction_Tacky,
+				*Int_To_Double_Instruction_Tacky, *UInt_To_Double_Instruction_Tacky, *Get_Address_Instruction_Tacky:
+				canMove = true
+			case *Binary_Instruction_Tacky:
+				switch convertedInstr.binOp {
+				case DIVIDE_OPERATOR, REMAINDER_OPERATOR:
+					canMove = convertedInstr.dst.getAssemblyType() == DOUBLE_ASM_TYPE
+				default:
+					canMove = true
+				}
+				if !canMove {
+					canMove = ssa.alwaysRunsInLoop(loop, block)
+				}
+			case *Load_Instruction_Tacky:
+				canMove = !writesMemory && ssa.alwaysRunsInLoop(loop, block)
+			}
+
+			dst, isVar := getInstructionDst(instr).(*Variable_Value_Tacky)
+			if canMove {
+				canMove = isVar && ssa.isSingleAssignment(dst)
+			}
+			for _, val := range getInstructionUses(instr) {
+				if !isInvariant(val) {
+					canMove = false
+				}
+			}
+			if !canMove {
+				kept = append(kept, instr)
+				continue
+			}
+
+			hoisted = append(hoisted, instr)
+			defBlocks[dst.name] = preheader
+		}
+		block.instructions = kept
+	}
+
+	for _, instr := range hoisted {
+		insertInPreheader(instr)
+	}
+	return len(hoisted) > 0
+}
@@ -47,7 +47,7 @@ func labelBlockItem(bi Block_Item, currentLabel string) Block_Item {
 	switch convertedBi := bi.(type) {
 	case *Block_Statement:
 		newSt := labelStatement(convertedBi.st, currentLabel)
-		return &Block_Statement{st: newSt}
+		return &Block_Statement{st: newSt, loc: convertedBi.loc}
 	case *Block_Declaration:
 		return bi
 	}
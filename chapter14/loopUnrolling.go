@@ -0,0 +1,513 @@
+package main
+
+import "strings"
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// the most tacky instructions that the copies of a loop's body can have all together, labels, phis and copies don't
+// count
+const UNROLL_SIZE_LIMIT = 64
+
+// how many copies of the body go in each time around a loop that is only partly unrolled
+const UNROLL_FACTOR = 4
+
+// Goes in the header labels of the loops that were already partly unrolled. The copy and the loop left over for the
+// remaining iterations are both still counted loops, so without it they would be unrolled again every time the pass
+// runs. Inlining only adds to the end of a label's name, so the mark stays when the function is inlined.
+const UNROLLED_LABEL_MARK = ".unrolled"
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// A loop like `for (i = init; i < bound; i = i + step)`. The header only has the phis and the JUMP_IF out of the
+// loop, and the rest of the loop comes right after the header and ends with the jump back to it.
+type Counted_Loop struct {
+	loop *Natural_Loop
+	// the header and the last block of the loop in cfg.blocks
+	firstIndex int
+	lastIndex  int
+	// the phi that the comparison reads, and what it is compared to. Leaving SSA can rename them, so getParts finds
+	// them again from the comparison.
+	counter       *Variable_Value_Tacky
+	bound         Value_Tacky
+	counterOnLeft bool
+	// the comparison that keeps the loop going, with the counter on the left
+	binOp    BinaryOperatorType
+	step     *Constant_Value_Tacky
+	countsUp bool
+	// -1 if the number of iterations isn't known
+	tripCount int
+	// the number of instructions in the loop, not counting the labels, or the phis and copies that mostly go away
+	// when leaving SSA
+	size int
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// Small counted loops with a known number of iterations are replaced by that many copies of the body. Other counted
+// loops get a copy that runs UNROLL_FACTOR iterations at a time while at least that many are left, and the original
+// loop does the rest. The loops are copied after leaving SSA, so the pass ends the SSA session when it changes
+// anything.
+func unrollLoops(ssa *SSA_Function) bool {
+	defs := make(map[string]Instruction_Tacky)
+	defBlocks := make(map[string]*Basic_Block)
+	for _, block := range ssa.cfg.blocks {
+		for _, instr := range block.instructions {
+			dst, isVar := getInstructionDst(instr).(*Variable_Value_Tacky)
+			if isVar {
+				defs[dst.name] = instr
+				defBlocks[dst.name] = block
+			}
+		}
+	}
+
+	loops := findNaturalLoops(ssa)
+	countedLoops := make(map[int]*Counted_Loop)
+	for _, loop := range loops {
+		counted := ssa.findCountedLoop(loop, defs, defBlocks)
+		if (counted == nil) || (!counted.canUnrollFully() && !counted.canUnrollPartly(ssa.cfg)) {
+			continue
+		}
+		// only the innermost loops, the size limit would stop most of the outer ones anyway
+		hasInnerLoop := false
+		for _, other := range loops {
+			if (other != loop) && loop.blocks[other.header] {
+				hasInnerLoop = true
+			}
+		}
+		if !hasInnerLoop {
+			countedLoops[counted.firstIndex] = counted
+		}
+	}
+	if len(countedLoops) == 0 {
+		return false
+	}
+
+	// the loops are copied after leaving SSA, since the copies can write to the same variables
+	convertFromSSA(ssa)
+	instructions := []Instruction_Tacky{}
+	for index := 0; index < len(ssa.cfg.blocks); index++ {
+		counted, found := countedLoops[index]
+		if !found {
+			instructions = append(instructions, ssa.cfg.blocks[index].instructions...)
+			continue
+		}
+		if counted.canUnrollFully() {
+			instructions = append(instructions, counted.unrollFully(ssa.cfg)...)
+		} else {
+			instructions = append(instructions, counted.unrollPartly(ssa.cfg)...)
+		}
+		index = counted.lastIndex
+	}
+	ssa.exitBody = instructions
+	return true
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// returns nil if the loop isn't a counted loop
+func (ssa *SSA_Function) findCountedLoop(loop *Natural_Loop, defs map[string]Instruction_Tacky,
+	defBlocks map[string]*Basic_Block) *Counted_Loop {
+
+	counted := Counted_Loop{loop: loop, firstIndex: -1, tripCount: -1}
+	for index, block := range ssa.cfg.blocks {
+		if block == loop.header {
+			counted.firstIndex = index
+		}
+	}
+	counted.lastIndex = counted.firstIndex + len(loop.blocks) - 1
+	if (counted.firstIndex < 0) || (counted.lastIndex >= len(ssa.cfg.blocks)) || (len(loop.latches) != 1) {
+		return nil
+	}
+	for index := counted.firstIndex; index <= counted.lastIndex; index++ {
+		block := ssa.cfg.blocks[index]
+		if !loop.blocks[block] {
+			return nil
+		}
+		for _, instr := range block.instructions {
+			switch instr.(type) {
+			case *Label_Instruction_Tacky, *Phi_Instruction_Tacky, *Copy_Instruction_Tacky:
+			default:
+				counted.size++
+			}
+		}
+		for _, succ := range block.succs {
+			if !loop.blocks[succ] && (block != loop.header) {
+				// the only way out of the loop is the header's comparison
+				return nil
+			}
+		}
+	}
+	latch := ssa.cfg.blocks[counted.lastIndex]
+	headerLabel, isLabel := loop.header.instructions[0].(*Label_Instruction_Tacky)
+	if (latch != loop.latches[0]) || (len(latch.instructions) == 0) || !isLabel ||
+		strings.Contains(headerLabel.name, UNROLLED_LABEL_MARK) {
+		return nil
+	}
+	jump, isJump := latch.instructions[len(latch.instructions)-1].(*Jump_Instruction_Tacky)
+	if !isJump || (jump.target != headerLabel.name) {
+		return nil
+	}
+
+	// the header is the label, the phis and the jump out of the loop
+	instructions := loop.header.instructions
+	if len(instructions) < 2 {
+		return nil
+	}
+	exitJump, isExitJump := instructions[len(instructions)-1].(*Jump_If_Compare_Instruction_Tacky)
+	if !isExitJump {
+		return nil
+	}
+	phis := make(map[string]*Phi_Instruction_Tacky)
+	for _, instr := range instructions[1 : len(instructions)-1] {
+		phi, isPhi := instr.(*Phi_Instruction_Tacky)
+		if !isPhi {
+			return nil
+		}
+		phis[getTackyValueString(phi.dst)] = phi
+	}
+
+	// the loop keeps going while the exit jump isn't taken
+	counted.binOp = getInvertedComparison(exitJump.binOp)
+	counter, isVar := exitJump.src1.(*Variable_Value_Tacky)
+	counted.bound = exitJump.src2
+	counted.counterOnLeft = true
+	if !isVar || (phis[counter.name] == nil) {
+		// the counter is on the right, so the comparison is flipped
+		counter, isVar = exitJump.src2.(*Variable_Value_Tacky)
+		counted.bound = exitJump.src1
+		counted.counterOnLeft = false
+		counted.binOp = getFlippedComparison(counted.binOp)
+	}
+	if !isVar || (phis[counter.name] == nil) {
+		return nil
+	}
+	counted.counter = counter
+	switch counted.binOp {
+	case LESS_THAN_OPERATOR, LESS_OR_EQUAL_OPERATOR, GREATER_THAN_OPERATOR, GREATER_OR_EQUAL_OPERATOR:
+	default:
+		return nil
+	}
+	switch counter.getDataType() {
+	case INT_TYPE, LONG_TYPE, UNSIGNED_INT_TYPE, UNSIGNED_LONG_TYPE:
+	default:
+		return nil
+	}
+	bound, isVar := counted.bound.(*Variable_Value_Tacky)
+	if isVar && (!ssa.isSingleAssignment(bound) || loop.blocks[defBlocks[bound.name]]) {
+		return nil
+	}
+
+	// the counter has to go up or down by the same amount every time around
+	var init Value_Tacky
+	var next Value_Tacky
+	if len(phis[counter.name].args) != 2 {
+		return nil
+	}
+	for _, arg := range phis[counter.name].args {
+		if arg.pred == latch {
+			next = arg.val
+		} else {
+			init = arg.val
+		}
+	}
+	step, isStep := resolveCopies(next, defs).(*Variable_Value_Tacky)
+	if !isStep {
+		return nil
+	}
+	update, isBinary := defs[step.name].(*Binary_Instruction_Tacky)
+	if !isBinary {
+		return nil
+	}
+	switch {
+	case (update.binOp == ADD_OPERATOR) && isSameValue(update.src1, counter):
+		counted.step, _ = update.src2.(*Constant_Value_Tacky)
+		counted.countsUp = true
+	case (update.binOp == ADD_OPERATOR) && isSameValue(update.src2, counter):
+		counted.step, _ = update.src1.(*Constant_Value_Tacky)
+		counted.countsUp = true
+	case (update.binOp == SUBTRACT_OPERATOR) && isSameValue(update.src1, counter):
+		counted.step, _ = update.src2.(*Constant_Value_Tacky)
+	}
+	if (counted.step == nil) || (counted.step.typ != counter.getDataType()) {
+		return nil
+	}
+
+	initConst, isInitConst := resolveCopies(init, defs).(*Constant_Value_Tacky)
+	boundConst, isBoundConst := counted.bound.(*Constant_Value_Tacky)
+	if isInitConst && isBoundConst {
+		counted.tripCount = countIterations(&counted, initConst, boundConst)
+	}
+	return &counted
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// follows the copies back to where the value came from
+func resolveCopies(val Value_Tacky, defs map[string]Instruction_Tacky) Value_Tacky {
+	for {
+		v, isVar := val.(*Variable_Value_Tacky)
+		if !isVar {
+			return val
+		}
+		copyInstr, isCopy := defs[v.name].(*Copy_Instruction_Tacky)
+		if !isCopy || (copyInstr.src.getDataType() != v.getDataType()) {
+			return val
+		}
+		val = copyInstr.src
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// a < b is the same as b > a
+func getFlippedComparison(binOp BinaryOperatorType) BinaryOperatorType {
+	switch binOp {
+	case LESS_THAN_OPERATOR:
+		return GREATER_THAN_OPERATOR
+	case LESS_OR_EQUAL_OPERATOR:
+		return GREATER_OR_EQUAL_OPERATOR
+	case GREATER_THAN_OPERATOR:
+		return LESS_THAN_OPERATOR
+	case GREATER_OR_EQUAL_OPERATOR:
+		return LESS_OR_EQUAL_OPERATOR
+	}
+	return binOp
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// Runs the loop's comparison and update with constant folding, so the counter wraps around the same way it would in
+// the program. Returns -1 if the loop goes around too many times to be fully unrolled.
+func countIterations(counted *Counted_Loop, init *Constant_Value_Tacky, bound *Constant_Value_Tacky) int {
+	updateOp := SUBTRACT_OPERATOR
+	if counted.countsUp {
+		updateOp = ADD_OPERATOR
+	}
+	current := init
+	for count := 0; count <= UNROLL_SIZE_LIMIT; count++ {
+		result, ok := foldBinary(counted.binOp, current, bound, INT_TYPE)
+		if !ok {
+			return -1
+		}
+		if isZeroConstant(result) {
+			return count
+		}
+		current, ok = foldBinary(updateOp, current, counted.step, counted.counter.getDataType())
+		if !ok {
+			return -1
+		}
+	}
+	return -1
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func (counted *Counted_Loop) canUnrollFully() bool {
+	return (counted.tripCount >= 0) && (counted.tripCount*counted.size <= UNROLL_SIZE_LIMIT)
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func (counted *Counted_Loop) canUnrollPartly(cfg *Control_Flow_Graph) bool {
+	if UNROLL_FACTOR*counted.size > UNROLL_SIZE_LIMIT {
+		return false
+	}
+	// the number of iterations left is found by subtracting the counter from the bound, so the counter has to go
+	// toward the bound by a small amount each time
+	if counted.countsUp != ((counted.binOp == LESS_THAN_OPERATOR) || (counted.binOp == LESS_OR_EQUAL_OPERATOR)) {
+		return false
+	}
+	stepBits := getIntegerBits(counted.step)
+	if (stepBits == 0) || (stepBits > 1<<16) {
+		return false
+	}
+	// the copy goes right before the header, so the block before has to fall through to it
+	preheader, _ := counted.loop.getPreheader(cfg)
+	if preheader == cfg.entry {
+		return true
+	}
+	if (counted.firstIndex == 0) || (preheader != cfg.blocks[counted.firstIndex-1]) {
+		return false
+	}
+	if len(preheader.instructions) == 0 {
+		// propagateConstants can leave a block empty when it removes a branch
+		return true
+	}
+	headerLabel := counted.loop.header.instructions[0].(*Label_Instruction_Tacky)
+	switch convertedInstr := preheader.instructions[len(preheader.instructions)-1].(type) {
+	case *Jump_Instruction_Tacky:
+		return convertedInstr.target != headerLabel.name
+	case *Jump_If_Zero_Instruction_Tacky:
+		return convertedInstr.target != headerLabel.name
+	case *Jump_If_Not_Zero_Instruction_Tacky:
+		return convertedInstr.target != headerLabel.name
+	case *Jump_If_Compare_Instruction_Tacky:
+		return convertedInstr.target != headerLabel.name
+	}
+	return true
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// The loop's blocks after leaving SSA, split up so they can be copied. The header can have instructions from before
+// the loop in front of its label.
+type Unrolled_Loop_Parts struct {
+	beforeLabel []Instruction_Tacky
+	label       *Label_Instruction_Tacky
+	// the copies from the phis, without the jump out of the loop
+	header   []Instruction_Tacky
+	exitJump *Jump_If_Compare_Instruction_Tacky
+	body     []Instruction_Tacky
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func (counted *Counted_Loop) getParts(cfg *Control_Flow_Graph) Unrolled_Loop_Parts {
+	parts := Unrolled_Loop_Parts{}
+	header := counted.loop.header.instructions
+	for index, instr := range header {
+		lbl, isLabel := instr.(*Label_Instruction_Tacky)
+		if isLabel {
+			parts.beforeLabel = header[:index]
+			parts.label = lbl
+			parts.header = header[index+1 : len(header)-1]
+			break
+		}
+	}
+	parts.exitJump = header[len(header)-1].(*Jump_If_Compare_Instruction_Tacky)
+	if counted.counterOnLeft {
+		counted.counter, counted.bound = parts.exitJump.src1.(*Variable_Value_Tacky), parts.exitJump.src2
+	} else {
+		counted.counter, counted.bound = parts.exitJump.src2.(*Variable_Value_Tacky), parts.exitJump.src1
+	}
+	for index := counted.firstIndex + 1; index <= counted.lastIndex; index++ {
+		parts.body = append(parts.body, cfg.blocks[index].instructions...)
+	}
+	return parts
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// One time around the loop, without the exit check. The body's labels get new names, and the jump back to the header
+// goes to the end of the copy instead.
+func (parts *Unrolled_Loop_Parts) copyIteration() []Instruction_Tacky {
+	endLabel := makeLabelName(parts.label.name + UNROLLED_LABEL_MARK)
+	newLabelNames := make(map[string]string)
+	for _, instr := range parts.body {
+		lbl, isLabel := instr.(*Label_Instruction_Tacky)
+		if isLabel {
+			newLabelNames[lbl.name] = makeLabelName(lbl.name + UNROLLED_LABEL_MARK)
+		}
+	}
+	newLabelNames[parts.label.name] = endLabel
+	renameValue := func(val Value_Tacky) Value_Tacky {
+		return val
+	}
+	renameLabel := func(name string) string {
+		newName, found := newLabelNames[name]
+		if !found {
+			return name
+		}
+		return newName
+	}
+
+	instructions := []Instruction_Tacky{}
+	for _, instr := range parts.header {
+		instructions = append(instructions, renameInstruction(instr, renameValue, renameLabel))
+	}
+	for _, instr := range parts.body {
+		instructions = append(instructions, renameInstruction(instr, renameValue, renameLabel))
+	}
+	return append(instructions, &Label_Instruction_Tacky{endLabel})
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// each iteration is copied, and the header runs one more time so the variables it sets have their final values
+func (counted *Counted_Loop) unrollFully(cfg *Control_Flow_Graph) []Instruction_Tacky {
+	parts := counted.getParts(cfg)
+	instructions := append([]Instruction_Tacky{}, parts.beforeLabel...)
+	instructions = append(instructions, parts.label)
+	for count := 0; count < counted.tripCount; count++ {
+		instructions = append(instructions, parts.copyIteration()...)
+	}
+	instructions = append(instructions, parts.header...)
+	return append(instructions, &Jump_Instruction_Tacky{parts.exitJump.target})
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// The unrolled copy goes in front of the original loop. It checks the loop's own condition first, so the number of
+// iterations left can be found with an unsigned subtraction that can't overflow, and goes to the original loop once
+// there are fewer than UNROLL_FACTOR iterations left. The original loop gets a new label so both loops are marked.
+func (counted *Counted_Loop) unrollPartly(cfg *Control_Flow_Graph) []Instruction_Tacky {
+	parts := counted.getParts(cfg)
+	startLabel := makeLabelName("unrolledLoop" + UNROLLED_LABEL_MARK)
+	restLabel := &Label_Instruction_Tacky{makeLabelName(parts.label.name + UNROLLED_LABEL_MARK)}
+
+	typ := counted.counter.getDataType()
+	unsignedTyp := typ
+	switch typ {
+	case INT_TYPE:
+		unsignedTyp = UNSIGNED_INT_TYPE
+	case LONG_TYPE:
+		unsignedTyp = UNSIGNED_LONG_TYPE
+	}
+	remaining := makeTackyVariable(typ)
+	unsignedRemaining := makeTackyVariable(unsignedTyp)
+
+	subtract := Binary_Instruction_Tacky{binOp: SUBTRACT_OPERATOR, src1: counted.bound, src2: counted.counter, dst: &remaining}
+	if !counted.countsUp {
+		subtract.src1, subtract.src2 = counted.counter, counted.bound
+	}
+	// goes to the original loop when there aren't enough left, with a <= or >= comparison the last iteration is the
+	// one where nothing is left
+	notEnoughOp := LESS_OR_EQUAL_OPERATOR
+	if (counted.binOp == LESS_OR_EQUAL_OPERATOR) || (counted.binOp == GREATER_OR_EQUAL_OPERATOR) {
+		notEnoughOp = LESS_THAN_OPERATOR
+	}
+	needed := makeIntegerConstant(unsignedTyp, getIntegerBits(counted.step)*(UNROLL_FACTOR-1))
+
+	instructions := append([]Instruction_Tacky{}, parts.beforeLabel...)
+	instructions = append(instructions, &Label_Instruction_Tacky{startLabel})
+	instructions = append(instructions, parts.header...)
+	instructions = append(instructions,
+		&Jump_If_Compare_Instruction_Tacky{binOp: parts.exitJump.binOp, src1: parts.exitJump.src1, src2: parts.exitJump.src2,
+			target: restLabel.name},
+		&subtract,
+		&Copy_Instruction_Tacky{src: &remaining, dst: &unsignedRemaining},
+		&Jump_If_Compare_Instruction_Tacky{binOp: notEnoughOp, src1: &unsignedRemaining, src2: needed, target: restLabel.name})
+	for count := 0; count < UNROLL_FACTOR; count++ {
+		iteration := parts.copyIteration()
+		if count == 0 {
+			// the header already ran for the first iteration
+			iteration = iteration[len(parts.header):]
+		}
+		instructions = append(instructions, iteration...)
+	}
+	instructions = append(instructions, &Jump_Instruction_Tacky{startLabel})
+
+	// only the jump back goes to the header, nothing from outside of the loop does
+	renameValue := func(val Value_Tacky) Value_Tacky {
+		return val
+	}
+	renameLabel := func(name string) string {
+		if name == parts.label.name {
+			return restLabel.name
+		}
+		return name
+	}
+	instructions = append(instructions, restLabel)
+	instructions = append(instructions, parts.header...)
+	instructions = append(instructions, parts.exitJump)
+	for _, instr := range parts.body {
+		instructions = append(instructions, renameInstruction(instr, renameValue, renameLabel))
+	}
+	return instructions
+}
@@ -37,6 +37,14 @@ func doLinux() {
 		fmt.Println("-S will emit an assembly file but will not assemble or link it")
 		fmt.Println("-c will emit an object file but will not link it")
 		fmt.Println("-o is used to specify the executable name. The default is to use the first .c file and remove the .c from the name.")
+		fmt.Println("-Wall and -Wextra turn on groups of warnings, -W<name> and -Wno-<name> turn a single warning on or off")
+		fmt.Println("-Werror turns all warnings into errors, -Werror=<name> turns a single warning into an error")
+		fmt.Println("-fdiagnostics-format=json or =sarif writes the errors and warnings to stderr in a machine-readable format")
+		fmt.Println("-O0, -O1, -O2 and -Os set the optimization level, the default is -O0")
+		fmt.Println("-f<pass> and -fno-<pass> turn a single optimization on or off, ex: -fpropagate-copies")
+		fmt.Println("--print-after=<pass> prints the tacky or assembly after the pass runs")
+		fmt.Println("--fold-constants, --eliminate-unreachable-code, --propagate-copies, --eliminate-dead-stores turn on a single optimization")
+		fmt.Println("--optimize is the same as -O2")
 		os.Exit(1)
 	}
 
@@ -88,6 +96,11 @@ func doLinux() {
 				fmt.Println("creating object file instead of executable")
 				produceObjectFile = true
 				produceExecutable = false
+			case "--fold-constants", "--eliminate-unreachable-code", "--propagate-copies", "--eliminate-dead-stores":
+				// same as -f<pass>
+				parseOptimizationOption("-f" + strings.TrimPrefix(currentArg, "--"))
+			case "--optimize":
+				parseOptimizationOption("-O2")
 			case "-o":
 				// check that index + 1 is valid before using it
 				if (index + 1) < len(os.Args) {
@@ -95,6 +108,28 @@ func doLinux() {
 					index++
 				}
 			default:
+				if strings.HasPrefix(currentArg, "-fdiagnostics-format=") {
+					if !parseDiagnosticsFormatOption(currentArg) {
+						fail("unknown diagnostics format", currentArg)
+					}
+					continue
+				}
+
+				if strings.HasPrefix(currentArg, "-O") || strings.HasPrefix(currentArg, "-f") ||
+					strings.HasPrefix(currentArg, "--print-after=") {
+					if !parseOptimizationOption(currentArg) {
+						fail("unknown optimization option", currentArg)
+					}
+					continue
+				}
+
+				if strings.HasPrefix(currentArg, "-W") {
+					if !parseWarningOption(currentArg) {
+						fail("unknown warning option", currentArg)
+					}
+					continue
+				}
+
 				// it could be a library that we need to link
 				re, _ := regexp.Compile(`-l[a-zA-Z0-9]+`)
 				result := re.FindStringIndex(currentArg)
@@ -111,13 +146,13 @@ func doLinux() {
 
 	allAssemblyFilenames := []string{}
 	for _, filename := range allInputFileNames {
-		// produce preprocessed file
+		// produce preprocessed file, keep the line markers so that warnings can point at the original source
 		preprocessedFilename := strings.TrimSuffix(filename, ".c") + ".i"
-		outBytes, err := exec.Command("gcc", "-E", "-P", filename, "-o", preprocessedFilename).CombinedOutput()
+		outBytes, err := exec.Command("gcc", "-E", filename, "-o", preprocessedFilename).CombinedOutput()
 		if err != nil {
 			fmt.Println("gcc returned error:", err)
 			fmt.Printf("additional info: %s\n", outBytes)
-			os.Exit(1)
+			exitCompiler(1)
 		}
 
 		fileContents := loadFile(preprocessedFilename)
@@ -131,7 +166,7 @@ func doLinux() {
 		// do the compilation and produce an assembly file
 		assemblyFilename := strings.TrimSuffix(filename, ".c") + ".s"
 		allAssemblyFilenames = append(allAssemblyFilenames, assemblyFilename)
-		doCompilerSteps(fileContents, runParser, runSemanticAnalysis, runTackyGeneration, runAssemblyGeneration, runCodeEmission, assemblyFilename)
+		doCompilerSteps(fileContents, filename, runParser, runSemanticAnalysis, runTackyGeneration, runAssemblyGeneration, runCodeEmission, assemblyFilename)
 	}
 
 	if produceObjectFile {
@@ -142,7 +177,7 @@ func doLinux() {
 			if err != nil {
 				fmt.Println("gcc returned error:", err)
 				fmt.Printf("additional info: %s\n", outBytes)
-				os.Exit(1)
+				exitCompiler(1)
 			}
 
 			fmt.Println("object file created:", objectFilename)
@@ -165,7 +200,7 @@ func doLinux() {
 		if err != nil {
 			fmt.Println("gcc returned error:", err)
 			fmt.Printf("additional info: %s\n", outBytes)
-			os.Exit(1)
+			exitCompiler(1)
 		}
 		fmt.Printf("additional info: %s\n", outBytes)
 		fmt.Println("executable created:", outputFilename)
@@ -179,6 +214,7 @@ func doLinux() {
 		}
 	}
 
+	flushDiagnostics()
 }
 
 /////////////////////////////////////////////////////////////////////////////////
@@ -189,12 +225,13 @@ func doWindows() {
 
 	contents := loadFile(filename)
 	assemblyFilename := strings.TrimSuffix(filename, ".c") + ".s"
-	doCompilerSteps(contents, true, true, true, true, true, assemblyFilename)
+	doCompilerSteps(contents, filename, true, true, true, true, true, assemblyFilename)
+	flushDiagnostics()
 }
 
 /////////////////////////////////////////////////////////////////////////////////
 
-func doCompilerSteps(fileContents string, runParser bool, runSemanticAnalysis bool, runTackyGeneration bool,
+func doCompilerSteps(fileContents string, sourceFilename string, runParser bool, runSemanticAnalysis bool, runTackyGeneration bool,
 	runAssemblyGeneration bool, runCodeEmission bool, assemblyFilename string) {
 
 	fmt.Println("running compiler with fileContents:")
@@ -202,53 +239,67 @@ func doCompilerSteps(fileContents string, runParser bool, runSemanticAnalysis bo
 
 	// run lexer
 	fmt.Println("running lexer")
-	tokens := doLexer(fileContents)
+	currentCompilerStep = "lexer"
+	tokens := doLexer(fileContents, sourceFilename)
 	fmt.Println("found tokens:")
 	fmt.Println(tokens)
 
 	if !runParser {
 		fmt.Println("not running parser, done")
-		os.Exit(0)
+		exitCompiler(0)
 	}
 
 	// run parser, get the Abstract Syntax Tree
 	fmt.Println("running parser")
+	currentCompilerStep = "parser"
 	ast := doParser(tokens)
 
 	if !runSemanticAnalysis {
 		fmt.Println("not running semantic analysis, done")
-		os.Exit(0)
+		exitCompiler(0)
 	}
 
 	// run semantic analysis and update the Abstract Syntax Tree
 	fmt.Println("running semantic analysis")
+	currentCompilerStep = "semantic analysis"
 	ast = doIdentifierResolution(ast)
 	ast = doTypeChecking(ast)
 	ast = doLoopLabeling(ast)
+	exitIfWarningErrors()
 
 	if !runTackyGeneration {
 		fmt.Println("not running tacky generation, done")
-		os.Exit(0)
+		exitCompiler(0)
 	}
 
 	// run tacky generation
 	fmt.Println("running tacky generation")
+	currentCompilerStep = "tacky generation"
 	tacky := doTackyGen(ast)
+	exitIfWarningErrors()
+	printTackyIfRequested("tacky-generation", tacky)
+
+	// run the optimizations that were turned on
+	fmt.Println("running optimizations")
+	currentCompilerStep = "optimization"
+	tacky = doOptimization(tacky)
 
 	if !runAssemblyGeneration {
-		os.Exit(0)
+		exitCompiler(0)
 	}
 
 	// run assembly generation
 	fmt.Println("running assembly generation")
+	currentCompilerStep = "assembly generation"
 	asm := doAssemblyGen(tacky)
 
 	if !runCodeEmission {
-		os.Exit(0)
+		exitCompiler(0)
 	}
 
 	//run code emission
 	fmt.Println("running code emission")
+	currentCompilerStep = "code emission"
 	doCodeEmission(asm, assemblyFilename)
 }
 
@@ -263,6 +314,22 @@ func loadFile(filename string) string {
 
 func fail(msg ...string) {
 	joinedMsg := strings.Join(msg, " ")
-	fmt.Println(joinedMsg)
-	os.Exit(1)
+	reportDiagnostic(Diagnostic{severity: ERROR_SEVERITY, category: currentCompilerStep, message: joinedMsg})
+	exitCompiler(1)
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// for errors where we know where in the source code the problem is
+func failWithDiagnostic(diag Diagnostic) {
+	reportDiagnostic(diag)
+	exitCompiler(1)
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// like fail, but for errors about a declaration or expression, the error points at where it starts
+func failAt(loc Source_Location, msg ...string) {
+	failWithDiagnostic(Diagnostic{severity: ERROR_SEVERITY, category: currentCompilerStep, message: strings.Join(msg, " "),
+		start: loc})
 }
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+type PassKindEnum int
+
+const (
+	NONE_PASS PassKindEnum = iota
+	TACKY_PASS
+	// runs on the whole program instead of one function at a time
+	TACKY_PROGRAM_PASS
+	// runs while the function is in SSA, the SSA passes next to each other share one conversion
+	SSA_PASS
+	// changes how tacky is turned into assembly, it doesn't run by itself
+	CODEGEN_PASS
+	ASSEMBLY_PASS
+)
+
+type Optimization_Pass struct {
+	// the name used on the command line, ex: -fpropagate-copies or -fno-propagate-copies
+	name string
+	kind PassKindEnum
+	// the optimization levels that turn the pass on, ex: "2s" means -O2 and -Os
+	levels      string
+	runTacky    func(body []Instruction_Tacky) []Instruction_Tacky
+	runProgram  func(tacky *Program_Tacky) bool
+	runSSA      func(ssa *SSA_Function) bool
+	runAssembly func(fn *Function_Asm)
+}
+
+// the passes run in this order
+var allOptimizationPasses = []Optimization_Pass{
+	{name: "inline-functions", kind: TACKY_PROGRAM_PASS, levels: "2s", runProgram: inlineFunctions},
+	{name: "optimize-sibling-calls", kind: TACKY_PROGRAM_PASS, levels: "2s", runProgram: eliminateTailRecursion},
+	{name: "fold-constants", kind: TACKY_PASS, levels: "12s", runTacky: foldConstants},
+	{name: "eliminate-unreachable-code", kind: TACKY_PASS, levels: "12s", runTacky: eliminateUnreachableCode},
+	{name: "propagate-copies", kind: TACKY_PASS, levels: "2s", runTacky: propagateCopies},
+	{name: "propagate-constants", kind: SSA_PASS, levels: "2s", runSSA: propagateConstants},
+	{name: "eliminate-common-subexpressions", kind: SSA_PASS, levels: "2s", runSSA: eliminateCommonSubexpressions},
+	{name: "move-loop-invariants", kind: SSA_PASS, levels: "2s", runSSA: moveLoopInvariants},
+	// only with -funroll-loops, like gcc. It has to be the last SSA pass since it leaves SSA to copy the loops.
+	{name: "unroll-loops", kind: SSA_PASS, levels: "", runSSA: unrollLoops},
+	{name: "if-conversion", kind: TACKY_PASS, levels: "12s", runTacky: convertIfsToConditionalCopies},
+	{name: "eliminate-dead-stores", kind: TACKY_PASS, levels: "2s", runTacky: eliminateDeadStores},
+	{name: "strength-reduction", kind: CODEGEN_PASS, levels: "12s"},
+	{name: "share-stack-slots", kind: CODEGEN_PASS, levels: "12s"},
+	{name: "omit-frame-pointer", kind: CODEGEN_PASS, levels: "12s"},
+	{name: "use-red-zone", kind: CODEGEN_PASS, levels: "12s"},
+	{name: "peephole", kind: ASSEMBLY_PASS, levels: "12s", runAssembly: func(fn *Function_Asm) { fn.peepholeOptimize() }},
+}
+
+// The passes normally settle after a few rounds, but two of them could keep undoing each other, so the fixed point
+// loops give up after this many rounds. The code is still correct, just not as optimized as it could be.
+const MAX_OPTIMIZATION_ROUNDS = 20
+
+// the steps that always run, they can be used with --print-after too
+var allFixedPasses = []string{"tacky-generation", "register-allocation", "instruction-fixup"}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+type Optimization_Settings struct {
+	// one of 0, 1, 2 or s
+	level      string
+	enabled    map[string]bool // only holds passes that were explicitly turned on or off
+	printAfter map[string]bool
+}
+
+var optimizationSettings = Optimization_Settings{level: "0", enabled: make(map[string]bool), printAfter: make(map[string]bool)}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// returns false if the option is not an optimization option that we know about
+func parseOptimizationOption(option string) bool {
+	switch option {
+	case "-O0", "-O1", "-O2", "-Os":
+		optimizationSettings.level = strings.TrimPrefix(option, "-O")
+		return true
+	case "-O":
+		// same as gcc
+		optimizationSettings.level = "1"
+		return true
+	}
+
+	if name, found := strings.CutPrefix(option, "--print-after="); found {
+		known := isKnownPassName(name)
+		if known {
+			optimizationSettings.printAfter[name] = true
+		}
+		return known
+	} else if name, found := strings.CutPrefix(option, "-fno-"); found {
+		_, known := getOptimizationPass(name)
+		if known {
+			optimizationSettings.enabled[name] = false
+		}
+		return known
+	} else if name, found := strings.CutPrefix(option, "-f"); found {
+		_, known := getOptimizationPass(name)
+		if known {
+			optimizationSettings.enabled[name] = true
+		}
+		return known
+	}
+
+	return false
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func getOptimizationPass(name string) (Optimization_Pass, bool) {
+	for _, pass := range allOptimizationPasses {
+		if pass.name == name {
+			return pass, true
+		}
+	}
+	return Optimization_Pass{}, false
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func isKnownPassName(name string) bool {
+	_, known := getOptimizationPass(name)
+	for _, fixedName := range allFixedPasses {
+		if fixedName == name {
+			known = true
+		}
+	}
+	return known
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// -f<pass> and -fno-<pass> win over the optimization level, no matter which order they are in
+func isPassEnabled(pass Optimization_Pass) bool {
+	enabled, explicit := optimizationSettings.enabled[pass.name]
+	if explicit {
+		return enabled
+	}
+	return strings.Contains(pass.levels, optimizationSettings.level)
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// for the code generator to check the passes that don't run by themselves
+func isOptimizationEnabled(name string) bool {
+	pass, known := getOptimizationPass(name)
+	return known && isPassEnabled(pass)
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+func doOptimization(tacky Program_Tacky) Program_Tacky {
+	// the functions are optimized first so the whole program passes see how small they really are, and then again
+	// after the whole program passes change them
+	for round := 0; round < MAX_OPTIMIZATION_ROUNDS; round++ {
+		for _, item := range tacky.topItems {
+			fn, isFunc := item.(*Function_Definition_Tacky)
+			if isFunc {
+				fn.body = optimizeFunction(fn.name, fn.body)
+			}
+		}
+
+		changed := false
+		for _, pass := range allOptimizationPasses {
+			if (pass.kind != TACKY_PROGRAM_PASS) || !isPassEnabled(pass) {
+				continue
+			}
+			if pass.runProgram(&tacky) {
+				changed = true
+			}
+			printTackyIfRequested(pass.name, tacky)
+		}
+
+		if !changed {
+			break
+		}
+	}
+	return tacky
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func optimizeFunction(name string, body []Instruction_Tacky) []Instruction_Tacky {
+	// each optimization can create more opportunities for the others, so keep going until nothing changes
+	for round := 0; round < MAX_OPTIMIZATION_ROUNDS; round++ {
+		newBody := body
+		for index := 0; index < len(allOptimizationPasses); index++ {
+			pass := allOptimizationPasses[index]
+			if pass.kind == SSA_PASS {
+				end := index
+				for (end < len(allOptimizationPasses)) && (allOptimizationPasses[end].kind == SSA_PASS) {
+					end++
+				}
+				newBody = runSSAPasses(name, newBody, allOptimizationPasses[index:end])
+				index = end - 1
+				continue
+			}
+			if (pass.kind != TACKY_PASS) || !isPassEnabled(pass) {
+				continue
+			}
+			newBody = pass.runTacky(newBody)
+			if optimizationSettings.printAfter[pass.name] {
+				printTackyFunction(pass.name, name, newBody)
+			}
+		}
+
+		if isSameBody(body, newBody) {
+			break
+		}
+		body = newBody
+	}
+	return body
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// Every trip into SSA and back gives the variables new names and adds copies, so the SSA passes share one trip. The
+// body that was passed in is returned if none of them changed anything, otherwise the fixed point loop would never
+// see the same body twice.
+func runSSAPasses(fnName string, body []Instruction_Tacky, passes []Optimization_Pass) []Instruction_Tacky {
+	enabled := []Optimization_Pass{}
+	for _, pass := range passes {
+		if isPassEnabled(pass) {
+			enabled = append(enabled, pass)
+		}
+	}
+	if len(enabled) == 0 {
+		return body
+	}
+
+	ssa := convertToSSA(body)
+	changed := false
+	for _, pass := range enabled {
+		if pass.runSSA(ssa) {
+			changed = true
+		}
+		if ssa.exitBody != nil {
+			if optimizationSettings.printAfter[pass.name] {
+				printTackyFunction(pass.name, fnName, ssa.exitBody)
+			}
+			return ssa.exitBody
+		}
+		if optimizationSettings.printAfter[pass.name] {
+			printTackyFunction(pass.name, fnName, ssa.cfg.toInstructions())
+		}
+	}
+
+	if !changed {
+		return body
+	}
+	return convertFromSSA(ssa)
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// Compares what the instructions do, not the pointers, because a pass can rebuild an instruction without changing it.
+// The printed form has every operand and label in it, which is everything the passes change.
+func isSameBody(body1 []Instruction_Tacky, body2 []Instruction_Tacky) bool {
+	if len(body1) != len(body2) {
+		return false
+	}
+	for index, _ := range body1 {
+		if (body1[index] != body2[index]) && (getTackyInstructionString(body1[index]) != getTackyInstructionString(body2[index])) {
+			return false
+		}
+	}
+	return true
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// the assembly passes run once after instructionFixup, each of them already repeats until it can't do anything else
+func doAssemblyOptimization(asm *Program_Asm) {
+	for _, pass := range allOptimizationPasses {
+		if (pass.kind != ASSEMBLY_PASS) || !isPassEnabled(pass) {
+			continue
+		}
+		for _, item := range asm.topItems {
+			fn, isFunc := item.(*Function_Asm)
+			if isFunc {
+				pass.runAssembly(fn)
+			}
+		}
+		printAssemblyIfRequested(pass.name, asm)
+	}
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+func printTackyIfRequested(passName string, tacky Program_Tacky) {
+	if !optimizationSettings.printAfter[passName] {
+		return
+	}
+	for _, item := range tacky.topItems {
+		fn, isFunc := item.(*Function_Definition_Tacky)
+		if isFunc {
+			printTackyFunction(passName, fn.name, fn.body)
+		}
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func printTackyFunction(passName string, fnName string, body []Instruction_Tacky) {
+	fmt.Println("*** IR after", passName, "for", fnName, "***")
+	for _, instr := range body {
+		_, isLabel := instr.(*Label_Instruction_Tacky)
+		if isLabel {
+			fmt.Println(getTackyInstructionString(instr))
+		} else {
+			fmt.Println("\t" + getTackyInstructionString(instr))
+		}
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// the assembly is printed the same way it would be written to the .s file
+func printAssemblyIfRequested(passName string, asm *Program_Asm) {
+	if !optimizationSettings.printAfter[passName] {
+		return
+	}
+	for _, item := range asm.topItems {
+		fn, isFunc := item.(*Function_Asm)
+		if isFunc {
+			fmt.Println("*** IR after", passName, "for", fn.name, "***")
+			fn.topLevelEmitAsm(os.Stdout)
+		}
+	}
+}
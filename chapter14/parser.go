@@ -29,6 +29,7 @@ type Variable_Declaration struct {
 	initializer  Expression
 	dTyp         Data_Type
 	storageClass StorageClassEnum
+	loc          Source_Location
 }
 
 type Function_Declaration struct {
@@ -37,6 +38,10 @@ type Function_Declaration struct {
 	body         *Block
 	dTyp         Data_Type
 	storageClass StorageClassEnum
+	loc          Source_Location
+	paramLocs    []Source_Location
+	// inline is only a hint for the inliner, the function is still emitted like any other function
+	isInline bool
 }
 
 /////////////////////////////////////////////////////////////////////////////////
@@ -122,7 +127,8 @@ func (dt *Data_Type) isEqualType(input *Data_Type) bool {
 //###############################################################################
 
 type Block struct {
-	items []Block_Item
+	items  []Block_Item
+	endLoc Source_Location // the closing brace
 }
 
 //###############################################################################
@@ -135,7 +141,8 @@ type Block_Item interface {
 }
 
 type Block_Statement struct {
-	st Statement
+	st  Statement
+	loc Source_Location
 }
 
 type Block_Declaration struct {
@@ -233,23 +240,27 @@ type Constant_Value_Expression struct {
 	dTyp      Data_Type
 	value     string
 	resultTyp Data_Type
+	loc       Source_Location
 }
 
 type Variable_Expression struct {
 	name      string
 	resultTyp Data_Type
+	loc       Source_Location
 }
 
 type Cast_Expression struct {
 	targetType Data_Type
 	innerExp   Expression
 	resultTyp  Data_Type
+	loc        Source_Location
 }
 
 type Unary_Expression struct {
 	unOp      UnaryOperatorType
 	innerExp  Expression
 	resultTyp Data_Type
+	loc       Source_Location
 }
 
 type Binary_Expression struct {
@@ -257,12 +268,14 @@ type Binary_Expression struct {
 	firstExp  Expression
 	secExp    Expression
 	resultTyp Data_Type
+	loc       Source_Location
 }
 
 type Assignment_Expression struct {
 	lvalue    Expression
 	rightExp  Expression
 	resultTyp Data_Type
+	loc       Source_Location
 }
 
 // example: a == 3 ? 1 : 2
@@ -271,22 +284,26 @@ type Conditional_Expression struct {
 	middleExp Expression
 	rightExp  Expression
 	resultTyp Data_Type
+	loc       Source_Location
 }
 
 type Function_Call_Expression struct {
 	functionName string
 	args         []Expression
 	resultTyp    Data_Type
+	loc          Source_Location
 }
 
 type Dereference_Expression struct {
 	innerExp  Expression
 	resultTyp Data_Type
+	loc       Source_Location
 }
 
 type Address_Of_Expression struct {
 	innerExp  Expression
 	resultTyp Data_Type
+	loc       Source_Location
 }
 
 //###############################################################################
@@ -300,6 +317,7 @@ type Declarator interface {
 
 type Identifier_Declarator struct {
 	name string
+	loc  Source_Location
 }
 
 type Pointer_Declarator struct {
@@ -488,24 +506,33 @@ func parseDeclaration(tokens []Token) (Declaration, []Token) {
 		return nil, tokens
 	}
 	baseType, storageClass := analyzeTypeAndStorageClass(specifiers)
+	isInline := isSpecifierInList(INLINE_KEYWORD_TOKEN, specifiers)
 	dec, tokens := parseDeclarator(tokens)
 	name, decType, paramNames := dec.processDeclarator(baseType)
+	loc := getDeclaratorLocation(dec)
+
+	if isInline && (decType.typ != FUNCTION_TYPE) {
+		fail("'inline' can only be used on functions")
+	}
 
 	if decType.typ == FUNCTION_TYPE {
+		paramLocs := getParamLocations(dec)
 		if peekToken(tokens).tokenType == SEMICOLON_TOKEN {
 			// it's a function declaration
 			_, tokens = expect(SEMICOLON_TOKEN, tokens)
-			fn := Function_Declaration{name: name, paramNames: paramNames, body: nil, dTyp: decType, storageClass: storageClass}
+			fn := Function_Declaration{name: name, paramNames: paramNames, body: nil, dTyp: decType, storageClass: storageClass,
+				loc: loc, paramLocs: paramLocs, isInline: isInline}
 			return &fn, tokens
 		} else {
 			// it's a function definition
 			block, tokens := parseBlock(tokens)
-			fn := Function_Declaration{name: name, paramNames: paramNames, body: &block, dTyp: decType, storageClass: storageClass}
+			fn := Function_Declaration{name: name, paramNames: paramNames, body: &block, dTyp: decType, storageClass: storageClass,
+				loc: loc, paramLocs: paramLocs, isInline: isInline}
 			return &fn, tokens
 		}
 	} else {
 		// it's a variable declaration
-		decl := Variable_Declaration{name: name, dTyp: decType, storageClass: storageClass}
+		decl := Variable_Declaration{name: name, dTyp: decType, storageClass: storageClass, loc: loc}
 
 		if peekToken(tokens).tokenType == EQUAL_TOKEN {
 			// it has an initializer
@@ -558,14 +585,45 @@ func parseSimpleDeclarator(tokens []Token) (Declarator, []Token) {
 		_, tokens = expect(CLOSE_PARENTHESIS_TOKEN, tokens)
 		return dec, tokens
 	} else {
+		loc := peekToken(tokens).loc
 		name, tokens := parseIdentifier(tokens)
-		identDec := Identifier_Declarator{name}
+		identDec := Identifier_Declarator{name: name, loc: loc}
 		return &identDec, tokens
 	}
 }
 
 /////////////////////////////////////////////////////////////////////////////////
 
+func getDeclaratorLocation(dec Declarator) Source_Location {
+	switch convertedDec := dec.(type) {
+	case *Identifier_Declarator:
+		return convertedDec.loc
+	case *Pointer_Declarator:
+		return getDeclaratorLocation(convertedDec.innerDec)
+	case *Function_Declarator:
+		return getDeclaratorLocation(convertedDec.innerDec)
+	}
+	return Source_Location{}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func getParamLocations(dec Declarator) []Source_Location {
+	switch convertedDec := dec.(type) {
+	case *Pointer_Declarator:
+		return getParamLocations(convertedDec.innerDec)
+	case *Function_Declarator:
+		paramLocs := []Source_Location{}
+		for _, paramInfo := range convertedDec.paramInfos {
+			paramLocs = append(paramLocs, getDeclaratorLocation(paramInfo.dec))
+		}
+		return paramLocs
+	}
+	return []Source_Location{}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
 func (dec *Identifier_Declarator) processDeclarator(baseTyp Data_Type) (string, Data_Type, []string) {
 	return dec.name, baseTyp, []string{}
 }
@@ -643,6 +701,9 @@ func parseSpecifiers(tokens []Token, storageClassAllowed bool) ([]TokenEnum, []T
 		if isSpecifierInList(STATIC_KEYWORD_TOKEN, specifiers) || isSpecifierInList(EXTERN_KEYWORD_TOKEN, specifiers) {
 			fail("Storage class specifier not allowed in parameter lists and cast expressions")
 		}
+		if isSpecifierInList(INLINE_KEYWORD_TOKEN, specifiers) {
+			fail("'inline' not allowed in parameter lists and cast expressions")
+		}
 	}
 
 	return specifiers, tokens
@@ -666,6 +727,8 @@ func isSpecifier(token Token) bool {
 		return true
 	case EXTERN_KEYWORD_TOKEN:
 		return true
+	case INLINE_KEYWORD_TOKEN:
+		return true
 	default:
 		return false
 	}
@@ -766,7 +829,8 @@ func analyzeTypeAndStorageClass(specifiers []TokenEnum) (Data_Type, StorageClass
 	for _, spec := range specifiers {
 		if isDataTypeKeyword(spec) {
 			types = append(types, spec)
-		} else {
+		} else if spec != INLINE_KEYWORD_TOKEN {
+			// inline is a function specifier, not a storage class
 			storageClasses = append(storageClasses, spec)
 		}
 	}
@@ -808,7 +872,7 @@ func parseParamList(tokens []Token) ([]Param_Info, []Token) {
 			name, decType, _ := dec.processDeclarator(baseType)
 
 			// add it to the list
-			paramInfo := Param_Info{dTyp: decType, dec: &Identifier_Declarator{name}}
+			paramInfo := Param_Info{dTyp: decType, dec: &Identifier_Declarator{name: name, loc: getDeclaratorLocation(dec)}}
 			paramInfos = append(paramInfos, paramInfo)
 
 			if peekToken(tokens).tokenType == COMMA_TOKEN {
@@ -859,8 +923,8 @@ func parseBlock(tokens []Token) (Block, []Token) {
 		items = append(items, bItem)
 	}
 
-	_, tokens = expect(CLOSE_BRACE_TOKEN, tokens)
-	bl := Block{items: items}
+	closeBrace, tokens := expect(CLOSE_BRACE_TOKEN, tokens)
+	bl := Block{items: items, endLoc: closeBrace.loc}
 	return bl, tokens
 }
 
@@ -874,8 +938,9 @@ func parseBlockItem(tokens []Token) (Block_Item, []Token) {
 		return &declBlock, tokens
 	} else {
 		// it's a statement
+		loc := peekToken(tokens).loc
 		st, tokens := parseStatement(tokens)
-		stBlock := Block_Statement{st}
+		stBlock := Block_Statement{st: st, loc: loc}
 		return &stBlock, tokens
 	}
 }
@@ -997,7 +1062,7 @@ func parseExpression(tokens []Token, minPrecedence int) (Expression, []Token) {
 			_, tokens = expect(EQUAL_TOKEN, tokens)
 			var right Expression
 			right, tokens = parseExpression(tokens, getPrecedence(nextToken))
-			left = &Assignment_Expression{lvalue: left, rightExp: right}
+			left = &Assignment_Expression{lvalue: left, rightExp: right, loc: nextToken.loc}
 		} else if nextToken.tokenType == QUESTION_TOKEN {
 			_, tokens = expect(QUESTION_TOKEN, tokens)
 			var middleExp Expression
@@ -1005,13 +1070,13 @@ func parseExpression(tokens []Token, minPrecedence int) (Expression, []Token) {
 			_, tokens = expect(COLON_TOKEN, tokens)
 			var rightExp Expression
 			rightExp, tokens = parseExpression(tokens, getPrecedence(nextToken))
-			left = &Conditional_Expression{condition: left, middleExp: middleExp, rightExp: rightExp}
+			left = &Conditional_Expression{condition: left, middleExp: middleExp, rightExp: rightExp, loc: nextToken.loc}
 		} else {
 			var binOpType BinaryOperatorType
 			binOpType, tokens = parseBinaryOperator(tokens)
 			var right Expression
 			right, tokens = parseExpression(tokens, getPrecedence(nextToken)+1)
-			left = &Binary_Expression{binOp: binOpType, firstExp: left, secExp: right}
+			left = &Binary_Expression{binOp: binOpType, firstExp: left, secExp: right, loc: nextToken.loc}
 		}
 		nextToken = peekToken(tokens)
 	}
@@ -1067,7 +1132,7 @@ func parseFactor(tokens []Token) (Expression, []Token) {
 
 	if constantTokenToDataType(nextToken) != NONE_TYPE {
 		value, typ, tokens := parseConstantValue(tokens)
-		ex := Constant_Value_Expression{dTyp: Data_Type{typ: typ}, value: value}
+		ex := Constant_Value_Expression{dTyp: Data_Type{typ: typ}, value: value, loc: nextToken.loc}
 		return &ex, tokens
 	} else if nextToken.tokenType == IDENTIFIER_TOKEN {
 		name, tokens := parseIdentifier(tokens)
@@ -1076,22 +1141,22 @@ func parseFactor(tokens []Token) (Expression, []Token) {
 			_, tokens = expect(OPEN_PARENTHESIS_TOKEN, tokens)
 			args, tokens := parseArgList(tokens)
 			_, tokens = expect(CLOSE_PARENTHESIS_TOKEN, tokens)
-			f := Function_Call_Expression{functionName: name, args: args}
+			f := Function_Call_Expression{functionName: name, args: args, loc: nextToken.loc}
 			return &f, tokens
 		} else {
 			// it's just a variable expression
-			v := Variable_Expression{name: name}
+			v := Variable_Expression{name: name, loc: nextToken.loc}
 			return &v, tokens
 		}
 	} else if isUnaryOperator(nextToken) {
 		unopType, tokens := parseUnaryOperator(tokens)
 		innerExp, tokens := parseFactor(tokens)
 		if unopType == DEREFERENCE_OPERATOR {
-			return &Dereference_Expression{innerExp: innerExp}, tokens
+			return &Dereference_Expression{innerExp: innerExp, loc: nextToken.loc}, tokens
 		} else if unopType == ADDRESS_OF_OPERATOR {
-			return &Address_Of_Expression{innerExp: innerExp}, tokens
+			return &Address_Of_Expression{innerExp: innerExp, loc: nextToken.loc}, tokens
 		} else {
-			unExp := Unary_Expression{innerExp: innerExp, unOp: unopType}
+			unExp := Unary_Expression{innerExp: innerExp, unOp: unopType, loc: nextToken.loc}
 			return &unExp, tokens
 		}
 	} else if nextToken.tokenType == OPEN_PARENTHESIS_TOKEN {
@@ -1104,7 +1169,7 @@ func parseFactor(tokens []Token) (Expression, []Token) {
 			derivedTyp := absDec.processAbstractDeclarator(baseTyp)
 			_, tokens = expect(CLOSE_PARENTHESIS_TOKEN, tokens)
 			exp, tokens := parseFactor(tokens)
-			cast := Cast_Expression{targetType: derivedTyp, innerExp: exp}
+			cast := Cast_Expression{targetType: derivedTyp, innerExp: exp, loc: nextToken.loc}
 			return &cast, tokens
 		} else {
 			// must be another expression within parentheses
@@ -1113,7 +1178,8 @@ func parseFactor(tokens []Token) (Expression, []Token) {
 			return innerExp, tokens
 		}
 	} else {
-		fail("Malformed expression. Unexpected", allRegexp[nextToken.tokenType].String())
+		failWithDiagnostic(makeTokenDiagnostic(ERROR_SEVERITY, nextToken, "Malformed expression. Unexpected "+
+			describeToken(nextToken.tokenType)))
 	}
 
 	// should never reach here, but go compiler complains if there's no return statement
@@ -1193,6 +1259,9 @@ func parseConstantValue(tokens []Token) (string, DataTypeEnum, []Token) {
 		}
 	} else if dataTyp == DOUBLE_TYPE {
 		currentToken.word = roundDouble(currentToken.word)
+		if strings.Contains(currentToken.word, "Inf") {
+			warn(OVERFLOW_WARNING, currentToken.loc, "floating constant exceeds range of 'double'")
+		}
 	}
 
 	return currentToken.word, dataTyp, tokens
@@ -1201,10 +1270,22 @@ func parseConstantValue(tokens []Token) (string, DataTypeEnum, []Token) {
 /////////////////////////////////////////////////////////////////////////////////
 
 func expect(expected TokenEnum, tokens []Token) (Token, []Token) {
+	previous := lastTakenToken
 	actual, tokens := takeToken(tokens)
 
 	if actual.tokenType != expected {
-		fail("Syntax error. Expected", allRegexp[expected].String(), "but found", allRegexp[actual.tokenType].String())
+		diag := makeTokenDiagnostic(ERROR_SEVERITY, actual,
+			"Syntax error. Expected "+describeToken(expected)+" but found "+describeToken(actual.tokenType))
+		if (expected == SEMICOLON_TOKEN) && (previous.tokenType != NONE_TOKEN) {
+			// the usual mistake is a missing semicolon at the end of the previous line, so suggest adding one
+			// right after the previous token, an empty range means it's an insertion
+			insertAt := previous.loc
+			insertAt.col += len(previous.word)
+			beforeInsert := insertAt
+			beforeInsert.col--
+			diag.fixIts = []Fix_It{{start: insertAt, finish: beforeInsert, replacement: ";"}}
+		}
+		failWithDiagnostic(diag)
 	}
 
 	return actual, tokens
@@ -1222,7 +1303,7 @@ func expectMultiple(expected []TokenEnum, tokens []Token) (Token, []Token) {
 		}
 	}
 	if !found {
-		fail("Syntax error. Unexpected", actual.word)
+		failWithDiagnostic(makeTokenDiagnostic(ERROR_SEVERITY, actual, "Syntax error. Unexpected "+actual.word))
 	}
 
 	return actual, tokens
@@ -1242,6 +1323,9 @@ func peekToken(tokens []Token) Token {
 
 /////////////////////////////////////////////////////////////////////////////////
 
+// used to point at the end of the previous token in error messages
+var lastTakenToken Token
+
 func takeToken(tokens []Token) (Token, []Token) {
 	// check for no more tokens, don't call os.Exit here, we don't have enough information to print a useful error message
 	if len(tokens) == 0 {
@@ -1251,6 +1335,7 @@ func takeToken(tokens []Token) (Token, []Token) {
 
 	firstToken := tokens[0]
 	tokens = tokens[1:]
+	lastTakenToken = firstToken
 
 	return firstToken, tokens
 }
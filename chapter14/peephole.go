@@ -0,0 +1,430 @@
+package main
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// A rule looks at the instructions starting at index. If they match, it returns what to replace them with and how many
+// instructions were replaced, otherwise it returns 0. Rules only look forward from index, so a rule never depends on
+// an instruction that an earlier rule in the same pass already changed.
+type Peephole_Rule struct {
+	name  string
+	apply func(instructions []Instruction_Asm, index int, liveAfter []Live_Registers) ([]Instruction_Asm, int)
+}
+
+// the order matters when more than one rule matches at the same index, the first one wins
+var PEEPHOLE_RULES = []Peephole_Rule{
+	{name: "compare with zero after setting the flags", apply: removeRedundantCompareWithZero},
+	{name: "set and then test the condition", apply: jumpOnConditionDirectly},
+	{name: "load right after a store", apply: reuseStoredRegister},
+	{name: "add or subtract zero, multiply by one", apply: removeIdentityArithmetic},
+	{name: "multiply by 3, 5 or 9 with a shift and add", apply: combineShiftAndAddIntoLea},
+	{name: "jump to the next instruction", apply: removeJumpToNextLabel},
+	{name: "mov to the same register", apply: removeSelfMove},
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+func (fn *Function_Asm) peepholeOptimize() {
+	// a rewrite can make another rule match, so keep going until nothing changes
+	for {
+		liveAfter := fn.findLiveAfterEachInstruction()
+		changed := false
+
+		instructions := []Instruction_Asm{}
+		index := 0
+		for index < len(fn.instructions) {
+			replaced := 0
+			for _, rule := range PEEPHOLE_RULES {
+				replacement, count := rule.apply(fn.instructions, index, liveAfter)
+				if count > 0 {
+					instructions = append(instructions, replacement...)
+					replaced = count
+					break
+				}
+			}
+
+			if replaced > 0 {
+				index += replaced
+				changed = true
+			} else {
+				instructions = append(instructions, fn.instructions[index])
+				index++
+			}
+		}
+		fn.instructions = instructions
+
+		if !changed {
+			return
+		}
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// the registers that are live right after each instruction, with the same index as fn.instructions
+func (fn *Function_Asm) findLiveAfterEachInstruction() []Live_Registers {
+	cfg := makeControlFlowGraphAsm(fn.instructions)
+	blockOut := findLiveRegisters(cfg, fn.name)
+
+	// the blocks hold every instruction in the same order as fn.instructions
+	liveAfter := []Live_Registers{}
+	for _, block := range cfg.blocks {
+		blockLive := make([]Live_Registers, len(block.instructions))
+		live := copyLiveRegisters(blockOut[block])
+		for index := len(block.instructions) - 1; index >= 0; index-- {
+			blockLive[index] = copyLiveRegisters(live)
+			uses, defs := getAsmUsesAndDefs(block.instructions[index], fn.name)
+			transferLiveRegisters(live, uses, defs)
+		}
+		liveAfter = append(liveAfter, blockLive...)
+	}
+	return liveAfter
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// op X; cmp $0, X => op X
+// and, or and xor set every flag the same way cmp $0 would. Add, sub and neg only get the zero flag the same, so the
+// cmp can only be removed if nothing reads the other flags.
+func removeRedundantCompareWithZero(instructions []Instruction_Asm, index int, liveAfter []Live_Registers) ([]Instruction_Asm, int) {
+	if (index + 1) >= len(instructions) {
+		return nil, 0
+	}
+	cmp, isCmp := instructions[index+1].(*Compare_Instruction_Asm)
+	if !isCmp || !isZeroImmediate(cmp.op1) {
+		return nil, 0
+	}
+
+	onlyZeroFlag := false
+	switch convertedInstr := instructions[index].(type) {
+	case *Binary_Instruction_Asm:
+		if (convertedInstr.asmTyp != cmp.asmTyp) || !isSameOperandAsm(convertedInstr.dst, cmp.op2) {
+			return nil, 0
+		}
+		switch convertedInstr.binOp {
+		case AND_OPERATOR_ASM, OR_OPERATOR_ASM, XOR_OPERATOR_ASM:
+			onlyZeroFlag = false
+		case ADD_OPERATOR_ASM, SUB_OPERATOR_ASM:
+			onlyZeroFlag = true
+		default:
+			return nil, 0
+		}
+	case *Unary_Instruction_Asm:
+		if (convertedInstr.unOp != NEGATE_OPERATOR_ASM) || (convertedInstr.asmTyp != cmp.asmTyp) ||
+			!isSameOperandAsm(convertedInstr.src, cmp.op2) {
+			return nil, 0
+		}
+		onlyZeroFlag = true
+	default:
+		return nil, 0
+	}
+
+	if onlyZeroFlag && !flagReadersOnlyUseZeroFlag(instructions, index+2) {
+		return nil, 0
+	}
+	return []Instruction_Asm{instructions[index]}, 2
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// mov $0, R; setcc R; cmp $0, R; je L => jNcc L
+// mov $0, R; setcc R; cmp $0, R; jne L => jcc L
+// Only when R isn't read after the jump. The mov at the start is optional.
+func jumpOnConditionDirectly(instructions []Instruction_Asm, index int, liveAfter []Live_Registers) ([]Instruction_Asm, int) {
+	start := index
+	mov, isMov := instructions[index].(*Mov_Instruction_Asm)
+	if isMov {
+		if !isZeroImmediate(mov.src) {
+			return nil, 0
+		}
+		index++
+	}
+	if (index + 2) >= len(instructions) {
+		return nil, 0
+	}
+
+	setC, isSetC := instructions[index].(*Set_Conditional_Instruction_Asm)
+	cmp, isCmp := instructions[index+1].(*Compare_Instruction_Asm)
+	jmpC, isJmpC := instructions[index+2].(*Jump_Conditional_Instruction_Asm)
+	if !isSetC || !isCmp || !isJmpC {
+		return nil, 0
+	}
+	reg, isReg := setC.dst.(*Register_Operand_Asm)
+	if !isReg || !isZeroImmediate(cmp.op1) || !isSameOperandAsm(cmp.op2, reg) {
+		return nil, 0
+	}
+	if isMov && !isSameOperandAsm(mov.dst, reg) {
+		return nil, 0
+	}
+	if liveAfter[index+2][registerNodeName(reg.reg)] {
+		return nil, 0
+	}
+
+	switch jmpC.code {
+	case IS_EQUAL_CODE_ASM:
+		return []Instruction_Asm{&Jump_Conditional_Instruction_Asm{code: invertConditionalCode(setC.code), target: jmpC.target}}, index + 3 - start
+	case NOT_EQUAL_CODE_ASM:
+		return []Instruction_Asm{&Jump_Conditional_Instruction_Asm{code: setC.code, target: jmpC.target}}, index + 3 - start
+	}
+	return nil, 0
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// mov R, M; mov M, X => mov R, M; mov R, X
+// M already has the same value as R, so there's no need to read it back. The second mov is removed when X is R, except
+// for movl, which also clears the upper half of R (see removeSelfMove).
+func reuseStoredRegister(instructions []Instruction_Asm, index int, liveAfter []Live_Registers) ([]Instruction_Asm, int) {
+	if (index + 1) >= len(instructions) {
+		return nil, 0
+	}
+	store, isStore := instructions[index].(*Mov_Instruction_Asm)
+	load, isLoad := instructions[index+1].(*Mov_Instruction_Asm)
+	if !isStore || !isLoad || (store.asmTyp != load.asmTyp) {
+		return nil, 0
+	}
+	reg, isReg := store.src.(*Register_Operand_Asm)
+	if !isReg || !isSameOperandAsm(store.dst, load.src) || isSameOperandAsm(store.src, store.dst) {
+		return nil, 0
+	}
+
+	if isSameOperandAsm(load.dst, reg) {
+		if load.asmTyp == LONGWORD_ASM_TYPE {
+			return nil, 0
+		}
+		return []Instruction_Asm{store}, 2
+	}
+	return []Instruction_Asm{store, &Mov_Instruction_Asm{asmTyp: load.asmTyp, src: reg, dst: load.dst}}, 2
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// add $0, X and sub $0, X and imul $1, X don't change X, they can be removed when nothing reads the flags they set
+func removeIdentityArithmetic(instructions []Instruction_Asm, index int, liveAfter []Live_Registers) ([]Instruction_Asm, int) {
+	bin, isBin := instructions[index].(*Binary_Instruction_Asm)
+	if !isBin || (bin.asmTyp == DOUBLE_ASM_TYPE) {
+		return nil, 0
+	}
+	imm, isImm := bin.src.(*Immediate_Int_Operand_Asm)
+	if !isImm {
+		return nil, 0
+	}
+
+	isIdentity := false
+	switch bin.binOp {
+	case ADD_OPERATOR_ASM, SUB_OPERATOR_ASM:
+		isIdentity = (imm.value == "0")
+	case MULT_OPERATOR_ASM:
+		isIdentity = (imm.value == "1")
+	}
+	if !isIdentity || !areFlagsDeadAfter(instructions, index) {
+		return nil, 0
+	}
+	return []Instruction_Asm{}, 1
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// mov R1, R2; shl $k, R2; add R1, R2 => lea (R1, R1, 2^k), R2
+// this is what strength reduction makes for x * 3, x * 5 and x * 9, lea doesn't set the flags so they have to be dead
+func combineShiftAndAddIntoLea(instructions []Instruction_Asm, index int, liveAfter []Live_Registers) ([]Instruction_Asm, int) {
+	if (index + 2) >= len(instructions) {
+		return nil, 0
+	}
+	mov, isMov := instructions[index].(*Mov_Instruction_Asm)
+	shl, isShl := instructions[index+1].(*Binary_Instruction_Asm)
+	add, isAdd := instructions[index+2].(*Binary_Instruction_Asm)
+	if !isMov || !isShl || !isAdd || (shl.binOp != SHIFT_LEFT_OPERATOR_ASM) || (add.binOp != ADD_OPERATOR_ASM) {
+		return nil, 0
+	}
+	if ((mov.asmTyp != LONGWORD_ASM_TYPE) && (mov.asmTyp != QUADWORD_ASM_TYPE)) ||
+		(shl.asmTyp != mov.asmTyp) || (add.asmTyp != mov.asmTyp) {
+		return nil, 0
+	}
+	src, srcIsReg := mov.src.(*Register_Operand_Asm)
+	dst, dstIsReg := mov.dst.(*Register_Operand_Asm)
+	if !srcIsReg || !dstIsReg || (src.reg == dst.reg) {
+		return nil, 0
+	}
+	if !isSameOperandAsm(shl.dst, dst) || !isSameOperandAsm(add.src, src) || !isSameOperandAsm(add.dst, dst) {
+		return nil, 0
+	}
+
+	count, isImm := shl.src.(*Immediate_Int_Operand_Asm)
+	if !isImm {
+		return nil, 0
+	}
+	scale := int32(0)
+	switch count.value {
+	case "1":
+		scale = 2
+	case "2":
+		scale = 4
+	case "3":
+		scale = 8
+	default:
+		return nil, 0
+	}
+	if !areFlagsDeadAfter(instructions, index+2) {
+		return nil, 0
+	}
+
+	indexed := Indexed_Operand_Asm{base: src.reg, index: src.reg, scale: scale}
+	return []Instruction_Asm{&Lea_Instruction_Asm{asmTyp: mov.asmTyp, src: &indexed, dst: dst}}, 3
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// jmp L; L: => L:
+func removeJumpToNextLabel(instructions []Instruction_Asm, index int, liveAfter []Live_Registers) ([]Instruction_Asm, int) {
+	if (index + 1) >= len(instructions) {
+		return nil, 0
+	}
+	lbl, isLabel := instructions[index+1].(*Label_Instruction_Asm)
+	if !isLabel {
+		return nil, 0
+	}
+
+	target := ""
+	switch convertedInstr := instructions[index].(type) {
+	case *Jump_Instruction_Asm:
+		target = convertedInstr.target
+	case *Jump_Conditional_Instruction_Asm:
+		target = convertedInstr.target
+	}
+	if target != lbl.name {
+		return nil, 0
+	}
+	return []Instruction_Asm{}, 1
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// movq R, R and movsd R, R don't do anything, but movl R, R clears the upper 32 bits so it has to stay
+func removeSelfMove(instructions []Instruction_Asm, index int, liveAfter []Live_Registers) ([]Instruction_Asm, int) {
+	mov, isMov := instructions[index].(*Mov_Instruction_Asm)
+	if !isMov || (mov.asmTyp == LONGWORD_ASM_TYPE) {
+		return nil, 0
+	}
+	_, isReg := mov.src.(*Register_Operand_Asm)
+	if !isReg || !isSameOperandAsm(mov.src, mov.dst) {
+		return nil, 0
+	}
+	return []Instruction_Asm{}, 1
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+func isZeroImmediate(op Operand_Asm) bool {
+	imm, isImm := op.(*Immediate_Int_Operand_Asm)
+	return isImm && (imm.value == "0")
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// returns the condition that is true exactly when code is false, including for the unordered result of comparing NaN
+func invertConditionalCode(code ConditionalCodeAsm) ConditionalCodeAsm {
+	switch code {
+	case IS_EQUAL_CODE_ASM:
+		return NOT_EQUAL_CODE_ASM
+	case NOT_EQUAL_CODE_ASM:
+		return IS_EQUAL_CODE_ASM
+	case LESS_THAN_CODE_ASM:
+		return GREATER_OR_EQUAL_CODE_ASM
+	case LESS_OR_EQUAL_CODE_ASM:
+		return GREATER_THAN_CODE_ASM
+	case GREATER_THAN_CODE_ASM:
+		return LESS_OR_EQUAL_CODE_ASM
+	case GREATER_OR_EQUAL_CODE_ASM:
+		return LESS_THAN_CODE_ASM
+	case LESS_THAN_CODE_UNSIGNED_ASM:
+		return GREATER_OR_EQUAL_CODE_UNSIGNED_ASM
+	case LESS_OR_EQUAL_CODE_UNSIGNED_ASM:
+		return GREATER_THAN_CODE_UNSIGNED_ASM
+	case GREATER_THAN_CODE_UNSIGNED_ASM:
+		return LESS_OR_EQUAL_CODE_UNSIGNED_ASM
+	case GREATER_OR_EQUAL_CODE_UNSIGNED_ASM:
+		return LESS_THAN_CODE_UNSIGNED_ASM
+	}
+	fail("unknown conditional code")
+	return NONE_CODE_ASM
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func readsFlags(instr Instruction_Asm) bool {
+	switch instr.(type) {
+	case *Jump_Conditional_Instruction_Asm, *Set_Conditional_Instruction_Asm, *Conditional_Move_Instruction_Asm:
+		return true
+	}
+	return false
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// Returns true if the flags can't be read after this instruction. The code generator always sets the flags right
+// before the jump or set that reads them, so the flags are never read after a label without being set first.
+func endsFlagLifetime(instr Instruction_Asm) bool {
+	switch convertedInstr := instr.(type) {
+	case *Compare_Instruction_Asm, *Call_Function_Asm, *Tail_Call_Function_Asm, *Ret_Instruction_Asm, *Label_Instruction_Asm,
+		*Jump_Instruction_Asm:
+		return true
+	case *IDivide_Instruction_Asm, *Divide_Instruction_Asm, *Multiply_High_Instruction_Asm:
+		// the flags are undefined after a division, and a multiply sets them
+		return true
+	case *Binary_Instruction_Asm:
+		// the double instructions don't change the flags
+		return convertedInstr.asmTyp != DOUBLE_ASM_TYPE
+	case *Unary_Instruction_Asm:
+		// not is the only one that doesn't change the flags
+		return convertedInstr.unOp != NOT_OPERATOR_ASM
+	}
+	return false
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func areFlagsDeadAfter(instructions []Instruction_Asm, index int) bool {
+	for _, instr := range instructions[index+1:] {
+		if readsFlags(instr) {
+			return false
+		}
+		if endsFlagLifetime(instr) {
+			return true
+		}
+	}
+	return true
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// true if every jump or set that reads the current flags, starting at index, only checks if the result was zero
+func flagReadersOnlyUseZeroFlag(instructions []Instruction_Asm, index int) bool {
+	for _, instr := range instructions[index:] {
+		code := NONE_CODE_ASM
+		switch convertedInstr := instr.(type) {
+		case *Jump_Conditional_Instruction_Asm:
+			code = convertedInstr.code
+		case *Set_Conditional_Instruction_Asm:
+			code = convertedInstr.code
+		case *Conditional_Move_Instruction_Asm:
+			code = convertedInstr.code
+		default:
+			if endsFlagLifetime(instr) {
+				return true
+			}
+			continue
+		}
+		if (code != IS_EQUAL_CODE_ASM) && (code != NOT_EQUAL_CODE_ASM) {
+			return false
+		}
+	}
+	return true
+}
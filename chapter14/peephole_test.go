@@ -0,0 +1,296 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+func reg(r RegisterTypeAsm) *Register_Operand_Asm {
+	return &Register_Operand_Asm{reg: r}
+}
+
+func imm(value string) *Immediate_Int_Operand_Asm {
+	return &Immediate_Int_Operand_Asm{value: value}
+}
+
+func stackSlot(offset int32) *Memory_Operand_Asm {
+	return &Memory_Operand_Asm{reg: BP_REGISTER_ASM, offset: offset}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// Each rule gets a case where it fires and at least one where it has to leave the code alone. The rule is applied at
+// index 0 with the liveness of the whole input, and want is the input after the replacement, or nil if the rule
+// shouldn't match. The functions return an int in eax, so a ret reads eax.
+var peepholeRuleTests = []struct {
+	name  string
+	rule  func(instructions []Instruction_Asm, index int, liveAfter []Live_Registers) ([]Instruction_Asm, int)
+	input []Instruction_Asm
+	want  []Instruction_Asm
+}{
+	{
+		name: "compare with zero after and",
+		rule: removeRedundantCompareWithZero,
+		input: []Instruction_Asm{
+			&Binary_Instruction_Asm{binOp: AND_OPERATOR_ASM, asmTyp: LONGWORD_ASM_TYPE, src: imm("1"), dst: reg(AX_REGISTER_ASM)},
+			&Compare_Instruction_Asm{asmTyp: LONGWORD_ASM_TYPE, op1: imm("0"), op2: reg(AX_REGISTER_ASM)},
+			&Jump_Conditional_Instruction_Asm{code: LESS_THAN_CODE_ASM, target: "L"},
+			&Ret_Instruction_Asm{},
+			&Label_Instruction_Asm{name: "L"},
+			&Ret_Instruction_Asm{},
+		},
+		want: []Instruction_Asm{
+			&Binary_Instruction_Asm{binOp: AND_OPERATOR_ASM, asmTyp: LONGWORD_ASM_TYPE, src: imm("1"), dst: reg(AX_REGISTER_ASM)},
+			&Jump_Conditional_Instruction_Asm{code: LESS_THAN_CODE_ASM, target: "L"},
+			&Ret_Instruction_Asm{},
+			&Label_Instruction_Asm{name: "L"},
+			&Ret_Instruction_Asm{},
+		},
+	},
+	{
+		name: "compare with zero after add, flags other than zero still live",
+		rule: removeRedundantCompareWithZero,
+		input: []Instruction_Asm{
+			&Binary_Instruction_Asm{binOp: ADD_OPERATOR_ASM, asmTyp: LONGWORD_ASM_TYPE, src: imm("1"), dst: reg(AX_REGISTER_ASM)},
+			&Compare_Instruction_Asm{asmTyp: LONGWORD_ASM_TYPE, op1: imm("0"), op2: reg(AX_REGISTER_ASM)},
+			&Jump_Conditional_Instruction_Asm{code: LESS_THAN_CODE_ASM, target: "L"},
+			&Ret_Instruction_Asm{},
+			&Label_Instruction_Asm{name: "L"},
+			&Ret_Instruction_Asm{},
+		},
+		want: nil,
+	},
+	{
+		name: "set and then test the condition",
+		rule: jumpOnConditionDirectly,
+		input: []Instruction_Asm{
+			&Mov_Instruction_Asm{asmTyp: LONGWORD_ASM_TYPE, src: imm("0"), dst: reg(AX_REGISTER_ASM)},
+			&Set_Conditional_Instruction_Asm{code: LESS_THAN_CODE_ASM, dst: reg(AX_REGISTER_ASM)},
+			&Compare_Instruction_Asm{asmTyp: LONGWORD_ASM_TYPE, op1: imm("0"), op2: reg(AX_REGISTER_ASM)},
+			&Jump_Conditional_Instruction_Asm{code: IS_EQUAL_CODE_ASM, target: "L"},
+			&Mov_Instruction_Asm{asmTyp: LONGWORD_ASM_TYPE, src: imm("1"), dst: reg(AX_REGISTER_ASM)},
+			&Ret_Instruction_Asm{},
+			&Label_Instruction_Asm{name: "L"},
+			&Mov_Instruction_Asm{asmTyp: LONGWORD_ASM_TYPE, src: imm("2"), dst: reg(AX_REGISTER_ASM)},
+			&Ret_Instruction_Asm{},
+		},
+		want: []Instruction_Asm{
+			&Jump_Conditional_Instruction_Asm{code: GREATER_OR_EQUAL_CODE_ASM, target: "L"},
+			&Mov_Instruction_Asm{asmTyp: LONGWORD_ASM_TYPE, src: imm("1"), dst: reg(AX_REGISTER_ASM)},
+			&Ret_Instruction_Asm{},
+			&Label_Instruction_Asm{name: "L"},
+			&Mov_Instruction_Asm{asmTyp: LONGWORD_ASM_TYPE, src: imm("2"), dst: reg(AX_REGISTER_ASM)},
+			&Ret_Instruction_Asm{},
+		},
+	},
+	{
+		name: "set and then test the condition, register still live",
+		rule: jumpOnConditionDirectly,
+		input: []Instruction_Asm{
+			&Mov_Instruction_Asm{asmTyp: LONGWORD_ASM_TYPE, src: imm("0"), dst: reg(AX_REGISTER_ASM)},
+			&Set_Conditional_Instruction_Asm{code: LESS_THAN_CODE_ASM, dst: reg(AX_REGISTER_ASM)},
+			&Compare_Instruction_Asm{asmTyp: LONGWORD_ASM_TYPE, op1: imm("0"), op2: reg(AX_REGISTER_ASM)},
+			&Jump_Conditional_Instruction_Asm{code: IS_EQUAL_CODE_ASM, target: "L"},
+			&Ret_Instruction_Asm{},
+			&Label_Instruction_Asm{name: "L"},
+			&Mov_Instruction_Asm{asmTyp: LONGWORD_ASM_TYPE, src: imm("2"), dst: reg(AX_REGISTER_ASM)},
+			&Ret_Instruction_Asm{},
+		},
+		want: nil,
+	},
+	{
+		name: "load right after a store",
+		rule: reuseStoredRegister,
+		input: []Instruction_Asm{
+			&Mov_Instruction_Asm{asmTyp: LONGWORD_ASM_TYPE, src: reg(AX_REGISTER_ASM), dst: stackSlot(-4)},
+			&Mov_Instruction_Asm{asmTyp: LONGWORD_ASM_TYPE, src: stackSlot(-4), dst: reg(CX_REGISTER_ASM)},
+			&Ret_Instruction_Asm{},
+		},
+		want: []Instruction_Asm{
+			&Mov_Instruction_Asm{asmTyp: LONGWORD_ASM_TYPE, src: reg(AX_REGISTER_ASM), dst: stackSlot(-4)},
+			&Mov_Instruction_Asm{asmTyp: LONGWORD_ASM_TYPE, src: reg(AX_REGISTER_ASM), dst: reg(CX_REGISTER_ASM)},
+			&Ret_Instruction_Asm{},
+		},
+	},
+	{
+		name: "movq store then load back into the same register",
+		rule: reuseStoredRegister,
+		input: []Instruction_Asm{
+			&Mov_Instruction_Asm{asmTyp: QUADWORD_ASM_TYPE, src: reg(DI_REGISTER_ASM), dst: reg(R9_REGISTER_ASM)},
+			&Mov_Instruction_Asm{asmTyp: QUADWORD_ASM_TYPE, src: reg(R9_REGISTER_ASM), dst: reg(DI_REGISTER_ASM)},
+			&Ret_Instruction_Asm{},
+		},
+		want: []Instruction_Asm{
+			&Mov_Instruction_Asm{asmTyp: QUADWORD_ASM_TYPE, src: reg(DI_REGISTER_ASM), dst: reg(R9_REGISTER_ASM)},
+			&Ret_Instruction_Asm{},
+		},
+	},
+	{
+		// the second movl clears the upper half of the register, which is how (long)(unsigned int)v zero extends
+		name: "movl store then load back into the same register",
+		rule: reuseStoredRegister,
+		input: []Instruction_Asm{
+			&Mov_Instruction_Asm{asmTyp: LONGWORD_ASM_TYPE, src: reg(DI_REGISTER_ASM), dst: reg(R9_REGISTER_ASM)},
+			&Mov_Instruction_Asm{asmTyp: LONGWORD_ASM_TYPE, src: reg(R9_REGISTER_ASM), dst: reg(DI_REGISTER_ASM)},
+			&Ret_Instruction_Asm{},
+		},
+		want: nil,
+	},
+	{
+		name: "load after a store with a label in between",
+		rule: reuseStoredRegister,
+		input: []Instruction_Asm{
+			&Mov_Instruction_Asm{asmTyp: LONGWORD_ASM_TYPE, src: reg(AX_REGISTER_ASM), dst: stackSlot(-4)},
+			&Label_Instruction_Asm{name: "L"},
+			&Mov_Instruction_Asm{asmTyp: LONGWORD_ASM_TYPE, src: stackSlot(-4), dst: reg(CX_REGISTER_ASM)},
+			&Ret_Instruction_Asm{},
+		},
+		want: nil,
+	},
+	{
+		name: "add zero",
+		rule: removeIdentityArithmetic,
+		input: []Instruction_Asm{
+			&Binary_Instruction_Asm{binOp: ADD_OPERATOR_ASM, asmTyp: LONGWORD_ASM_TYPE, src: imm("0"), dst: reg(AX_REGISTER_ASM)},
+			&Ret_Instruction_Asm{},
+		},
+		want: []Instruction_Asm{
+			&Ret_Instruction_Asm{},
+		},
+	},
+	{
+		name: "add zero, flags still live",
+		rule: removeIdentityArithmetic,
+		input: []Instruction_Asm{
+			&Binary_Instruction_Asm{binOp: ADD_OPERATOR_ASM, asmTyp: LONGWORD_ASM_TYPE, src: imm("0"), dst: reg(AX_REGISTER_ASM)},
+			&Jump_Conditional_Instruction_Asm{code: IS_EQUAL_CODE_ASM, target: "L"},
+			&Ret_Instruction_Asm{},
+			&Label_Instruction_Asm{name: "L"},
+			&Ret_Instruction_Asm{},
+		},
+		want: nil,
+	},
+	{
+		name: "multiply by 3 with a shift and add",
+		rule: combineShiftAndAddIntoLea,
+		input: []Instruction_Asm{
+			&Mov_Instruction_Asm{asmTyp: LONGWORD_ASM_TYPE, src: reg(CX_REGISTER_ASM), dst: reg(AX_REGISTER_ASM)},
+			&Binary_Instruction_Asm{binOp: SHIFT_LEFT_OPERATOR_ASM, asmTyp: LONGWORD_ASM_TYPE, src: imm("1"), dst: reg(AX_REGISTER_ASM)},
+			&Binary_Instruction_Asm{binOp: ADD_OPERATOR_ASM, asmTyp: LONGWORD_ASM_TYPE, src: reg(CX_REGISTER_ASM), dst: reg(AX_REGISTER_ASM)},
+			&Ret_Instruction_Asm{},
+		},
+		want: []Instruction_Asm{
+			&Lea_Instruction_Asm{asmTyp: LONGWORD_ASM_TYPE, src: &Indexed_Operand_Asm{base: CX_REGISTER_ASM, index: CX_REGISTER_ASM, scale: 2},
+				dst: reg(AX_REGISTER_ASM)},
+			&Ret_Instruction_Asm{},
+		},
+	},
+	{
+		name: "multiply by 3 with a shift and add, flags still live",
+		rule: combineShiftAndAddIntoLea,
+		input: []Instruction_Asm{
+			&Mov_Instruction_Asm{asmTyp: LONGWORD_ASM_TYPE, src: reg(CX_REGISTER_ASM), dst: reg(AX_REGISTER_ASM)},
+			&Binary_Instruction_Asm{binOp: SHIFT_LEFT_OPERATOR_ASM, asmTyp: LONGWORD_ASM_TYPE, src: imm("1"), dst: reg(AX_REGISTER_ASM)},
+			&Binary_Instruction_Asm{binOp: ADD_OPERATOR_ASM, asmTyp: LONGWORD_ASM_TYPE, src: reg(CX_REGISTER_ASM), dst: reg(AX_REGISTER_ASM)},
+			&Jump_Conditional_Instruction_Asm{code: IS_EQUAL_CODE_ASM, target: "L"},
+			&Ret_Instruction_Asm{},
+			&Label_Instruction_Asm{name: "L"},
+			&Ret_Instruction_Asm{},
+		},
+		want: nil,
+	},
+	{
+		name: "jump to the next instruction",
+		rule: removeJumpToNextLabel,
+		input: []Instruction_Asm{
+			&Jump_Instruction_Asm{target: "L"},
+			&Label_Instruction_Asm{name: "L"},
+			&Ret_Instruction_Asm{},
+		},
+		want: []Instruction_Asm{
+			&Label_Instruction_Asm{name: "L"},
+			&Ret_Instruction_Asm{},
+		},
+	},
+	{
+		name: "jump over a label to the next one",
+		rule: removeJumpToNextLabel,
+		input: []Instruction_Asm{
+			&Jump_Instruction_Asm{target: "L1"},
+			&Label_Instruction_Asm{name: "L2"},
+			&Label_Instruction_Asm{name: "L1"},
+			&Ret_Instruction_Asm{},
+		},
+		want: nil,
+	},
+	{
+		name: "movq to the same register",
+		rule: removeSelfMove,
+		input: []Instruction_Asm{
+			&Mov_Instruction_Asm{asmTyp: QUADWORD_ASM_TYPE, src: reg(AX_REGISTER_ASM), dst: reg(AX_REGISTER_ASM)},
+			&Ret_Instruction_Asm{},
+		},
+		want: []Instruction_Asm{
+			&Ret_Instruction_Asm{},
+		},
+	},
+	{
+		name: "movl to the same register clears the upper half",
+		rule: removeSelfMove,
+		input: []Instruction_Asm{
+			&Mov_Instruction_Asm{asmTyp: LONGWORD_ASM_TYPE, src: reg(AX_REGISTER_ASM), dst: reg(AX_REGISTER_ASM)},
+			&Ret_Instruction_Asm{},
+		},
+		want: nil,
+	},
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+func TestPeepholeRules(t *testing.T) {
+	symbolTable = map[string]Symbol{
+		"f": {dataTyp: Data_Type{typ: FUNCTION_TYPE, returnType: &Data_Type{typ: INT_TYPE}}, attrs: FUNCTION_ATTRIBUTES},
+	}
+
+	for _, tt := range peepholeRuleTests {
+		t.Run(tt.name, func(t *testing.T) {
+			fn := Function_Asm{name: "f", instructions: tt.input}
+			replacement, count := tt.rule(tt.input, 0, fn.findLiveAfterEachInstruction())
+
+			if tt.want == nil {
+				if count != 0 {
+					t.Fatalf("rule matched %d instructions, it should not have matched", count)
+				}
+				return
+			}
+			if count == 0 {
+				t.Fatalf("rule didn't match")
+			}
+			got := append(append([]Instruction_Asm{}, replacement...), tt.input[count:]...)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v\nwant %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// every rule in the table should be tested
+func TestPeepholeRulesAreCovered(t *testing.T) {
+	tested := make(map[uintptr]bool)
+	for _, tt := range peepholeRuleTests {
+		tested[reflect.ValueOf(tt.rule).Pointer()] = true
+	}
+	for _, rule := range PEEPHOLE_RULES {
+		if !tested[reflect.ValueOf(rule.apply).Pointer()] {
+			t.Errorf("no test for peephole rule %q", rule.name)
+		}
+	}
+}
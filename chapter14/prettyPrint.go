@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 )
 
@@ -550,3 +551,85 @@ func getPrettyPrintDataType(typ DataTypeEnum) string {
 		return ""
 	}
 }
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// one line of tacky, ex: tmp.3 = ADD x.1, 2
+func getTackyInstructionString(instr Instruction_Tacky) string {
+	switch convertedInstr := instr.(type) {
+	case *Return_Instruction_Tacky:
+		return "RETURN " + getTackyValueString(convertedInstr.val)
+	case *Sign_Extend_Instruction_Tacky:
+		return getTackyValueString(convertedInstr.dst) + " = SIGN_EXTEND " + getTackyValueString(convertedInstr.src)
+	case *Truncate_Instruction_Tacky:
+		return getTackyValueString(convertedInstr.dst) + " = TRUNCATE " + getTackyValueString(convertedInstr.src)
+	case *Zero_Extend_Instruction_Tacky:
+		return getTackyValueString(convertedInstr.dst) + " = ZERO_EXTEND " + getTackyValueString(convertedInstr.src)
+	case *Double_To_Int_Instruction_Tacky:
+		return getTackyValueString(convertedInstr.dst) + " = DOUBLE_TO_INT " + getTackyValueString(convertedInstr.src)
+	case *Double_To_UInt_Instruction_Tacky:
+		return getTackyValueString(convertedInstr.dst) + " = DOUBLE_TO_UINT " + getTackyValueString(convertedInstr.src)
+	case *Int_To_Double_Instruction_Tacky:
+		return getTackyValueString(convertedInstr.dst) + " = INT_TO_DOUBLE " + getTackyValueString(convertedInstr.src)
+	case *UInt_To_Double_Instruction_Tacky:
+		return getTackyValueString(convertedInstr.dst) + " = UINT_TO_DOUBLE " + getTackyValueString(convertedInstr.src)
+	case *Unary_Instruction_Tacky:
+		return getTackyValueString(convertedInstr.dst) + " = " + getPrettyPrintUnary(convertedInstr.unOp) + " " +
+			getTackyValueString(convertedInstr.src)
+	case *Binary_Instruction_Tacky:
+		return getTackyValueString(convertedInstr.dst) + " = " + getPrettyPrintBinary(convertedInstr.binOp) + " " +
+			getTackyValueString(convertedInstr.src1) + ", " + getTackyValueString(convertedInstr.src2)
+	case *Copy_Instruction_Tacky:
+		return getTackyValueString(convertedInstr.dst) + " = " + getTackyValueString(convertedInstr.src)
+	case *Conditional_Copy_Instruction_Tacky:
+		return getTackyValueString(convertedInstr.dst) + " = " + getPrettyPrintBinary(convertedInstr.binOp) + " " +
+			getTackyValueString(convertedInstr.left) + ", " + getTackyValueString(convertedInstr.right) + " ? " +
+			getTackyValueString(convertedInstr.src1) + " : " + getTackyValueString(convertedInstr.src2)
+	case *Get_Address_Instruction_Tacky:
+		return getTackyValueString(convertedInstr.dst) + " = GET_ADDRESS " + getTackyValueString(convertedInstr.src)
+	case *Load_Instruction_Tacky:
+		return getTackyValueString(convertedInstr.dst) + " = LOAD " + getTackyValueString(convertedInstr.srcPtr)
+	case *Store_Instruction_Tacky:
+		return "STORE " + getTackyValueString(convertedInstr.src) + ", " + getTackyValueString(convertedInstr.dstPtr)
+	case *Jump_Instruction_Tacky:
+		return "JUMP " + convertedInstr.target
+	case *Jump_If_Zero_Instruction_Tacky:
+		return "JUMP_IF_ZERO " + getTackyValueString(convertedInstr.condition) + ", " + convertedInstr.target
+	case *Jump_If_Not_Zero_Instruction_Tacky:
+		return "JUMP_IF_NOT_ZERO " + getTackyValueString(convertedInstr.condition) + ", " + convertedInstr.target
+	case *Jump_If_Compare_Instruction_Tacky:
+		return "JUMP_IF " + getPrettyPrintBinary(convertedInstr.binOp) + " " + getTackyValueString(convertedInstr.src1) + ", " +
+			getTackyValueString(convertedInstr.src2) + ", " + convertedInstr.target
+	case *Label_Instruction_Tacky:
+		return convertedInstr.name + ":"
+	case *Function_Call_Tacky:
+		args := []string{}
+		for _, arg := range convertedInstr.args {
+			args = append(args, getTackyValueString(arg))
+		}
+		return getTackyValueString(convertedInstr.returnVal) + " = CALL " + convertedInstr.funcName + "(" +
+			strings.Join(args, ", ") + ")"
+	case *Phi_Instruction_Tacky:
+		// each arg is shown with the id of the block it comes from
+		args := []string{}
+		for _, arg := range convertedInstr.args {
+			args = append(args, getTackyValueString(arg.val)+" [block "+strconv.Itoa(arg.pred.id)+"]")
+		}
+		return getTackyValueString(convertedInstr.dst) + " = PHI(" + strings.Join(args, ", ") + ")"
+	}
+	return "UNKNOWN_INSTRUCTION"
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func getTackyValueString(val Value_Tacky) string {
+	switch convertedVal := val.(type) {
+	case *Constant_Value_Tacky:
+		return convertedVal.value
+	case *Variable_Value_Tacky:
+		return convertedVal.name
+	}
+	return "UNKNOWN_VALUE"
+}
@@ -0,0 +1,929 @@
+package main
+
+import "math"
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// R10, R11, XMM14 and XMM15 are left out because instructionFixup uses them as scratch registers
+var ALLOCATABLE_INT_REGISTERS = []RegisterTypeAsm{AX_REGISTER_ASM, BX_REGISTER_ASM, CX_REGISTER_ASM, DX_REGISTER_ASM,
+	DI_REGISTER_ASM, SI_REGISTER_ASM, R8_REGISTER_ASM, R9_REGISTER_ASM, R12_REGISTER_ASM, R13_REGISTER_ASM,
+	R14_REGISTER_ASM, R15_REGISTER_ASM}
+
+var ALLOCATABLE_DOUBLE_REGISTERS = []RegisterTypeAsm{XMM0_REGISTER_ASM, XMM1_REGISTER_ASM, XMM2_REGISTER_ASM,
+	XMM3_REGISTER_ASM, XMM4_REGISTER_ASM, XMM5_REGISTER_ASM, XMM6_REGISTER_ASM, XMM7_REGISTER_ASM, XMM8_REGISTER_ASM,
+	XMM9_REGISTER_ASM, XMM10_REGISTER_ASM, XMM11_REGISTER_ASM, XMM12_REGISTER_ASM, XMM13_REGISTER_ASM}
+
+// a function we call has to preserve these, so we have to save them before using them ourselves
+var CALLEE_SAVED_REGISTERS = []RegisterTypeAsm{BX_REGISTER_ASM, R12_REGISTER_ASM, R13_REGISTER_ASM, R14_REGISTER_ASM,
+	R15_REGISTER_ASM}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// Both pseudoregisters and hard registers are nodes in the interference graph, hard registers are named with a %
+// (ex: %rax) so they can't be confused with a variable.
+type Interference_Node struct {
+	name       string
+	isRegister bool
+	reg        RegisterTypeAsm
+	neighbors  map[string]bool
+	spillCost  float64
+	// -1 means the node didn't get a color and it will be spilled to the stack
+	color  int
+	pruned bool
+}
+
+type Interference_Graph struct {
+	nodes map[string]*Interference_Node
+	// the order the nodes were added, so the allocation is the same every time the compiler runs
+	order []string
+	// the number of registers in this graph
+	k int
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+type Basic_Block_Asm struct {
+	id           int
+	instructions []Instruction_Asm
+	succs        []*Basic_Block_Asm
+	preds        []*Basic_Block_Asm
+}
+
+type Control_Flow_Graph_Asm struct {
+	entry  *Basic_Block_Asm
+	exit   *Basic_Block_Asm
+	blocks []*Basic_Block_Asm
+}
+
+type Live_Registers map[string]bool
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+func (pr *Program_Asm) allocateRegisters() {
+	for index, _ := range pr.topItems {
+		pr.topItems[index].allocateRegisters()
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func (st *Static_Variable_Asm) allocateRegisters() {
+	// nothing to do here
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func (st *Static_Constant_Asm) allocateRegisters() {
+	// nothing to do here
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func (fn *Function_Asm) allocateRegisters() {
+	aliased := findAliasedPseudoregisters(fn.instructions)
+
+	// the general purpose and floating point registers don't interfere with each other, so color them separately
+	assignments := make(map[string]RegisterTypeAsm)
+	for _, isDouble := range []bool{false, true} {
+		graph := fn.buildInterferenceGraph(isDouble, aliased)
+		// coalescing changes which nodes are live at the same time, so the graph is built again until nothing merges
+		for {
+			coalesced := graph.coalesce(fn.instructions)
+			if len(coalesced) == 0 {
+				break
+			}
+			fn.replaceCoalescedPseudoregisters(coalesced)
+			graph = fn.buildInterferenceGraph(isDouble, aliased)
+		}
+		graph.colorGraph()
+		for name, reg := range graph.getRegisterAssignments() {
+			assignments[name] = reg
+		}
+	}
+
+	fn.replaceAllocatedPseudoregisters(assignments)
+
+	usedCalleeSaved := make(map[RegisterTypeAsm]bool)
+	for _, reg := range assignments {
+		usedCalleeSaved[reg] = true
+	}
+	fn.calleeSavedRegisters = []RegisterTypeAsm{}
+	for _, reg := range CALLEE_SAVED_REGISTERS {
+		if usedCalleeSaved[reg] {
+			fn.calleeSavedRegisters = append(fn.calleeSavedRegisters, reg)
+		}
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// a pseudoregister that has its address taken needs to stay in memory so the pointer has something to point to
+func findAliasedPseudoregisters(instructions []Instruction_Asm) map[string]bool {
+	aliased := make(map[string]bool)
+	for _, instr := range instructions {
+		lea, isLea := instr.(*Lea_Instruction_Asm)
+		if isLea {
+			pseudo, isPseudo := lea.src.(*Pseudoregister_Operand_Asm)
+			if isPseudo {
+				aliased[pseudo.name] = true
+			}
+		}
+	}
+	return aliased
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+func (fn *Function_Asm) buildInterferenceGraph(isDouble bool, aliased map[string]bool) *Interference_Graph {
+	graph := Interference_Graph{nodes: make(map[string]*Interference_Node)}
+
+	registers := ALLOCATABLE_INT_REGISTERS
+	if isDouble {
+		registers = ALLOCATABLE_DOUBLE_REGISTERS
+	}
+	graph.k = len(registers)
+
+	// every hard register interferes with every other one so they all get different colors
+	for _, reg := range registers {
+		graph.addNode(&Interference_Node{name: registerNodeName(reg), isRegister: true, reg: reg, spillCost: math.Inf(1)})
+	}
+	for _, reg1 := range registers {
+		for _, reg2 := range registers {
+			if reg1 != reg2 {
+				graph.addEdge(registerNodeName(reg1), registerNodeName(reg2))
+			}
+		}
+	}
+
+	// the spill cost is how many times the pseudoregister is used, since each one would become a memory access
+	for _, instr := range fn.instructions {
+		uses, defs := getAsmUsesAndDefs(instr, fn.name)
+		for _, name := range append(uses, defs...) {
+			if !isAllocatablePseudo(name, isDouble, aliased) {
+				continue
+			}
+			_, exists := graph.nodes[name]
+			if !exists {
+				graph.addNode(&Interference_Node{name: name})
+			}
+			graph.nodes[name].spillCost += 1
+		}
+	}
+
+	cfg := makeControlFlowGraphAsm(fn.instructions)
+	blockOut := findLiveRegisters(cfg, fn.name)
+
+	for _, block := range cfg.blocks {
+		live := copyLiveRegisters(blockOut[block])
+		for index := len(block.instructions) - 1; index >= 0; index-- {
+			instr := block.instructions[index]
+			uses, defs := getAsmUsesAndDefs(instr, fn.name)
+
+			// A mov doesn't make its src and dst interfere, they have the same value so they can share a register.
+			movSrc := ""
+			mov, isMov := instr.(*Mov_Instruction_Asm)
+			if isMov {
+				movSrc = getOperandNodeName(mov.src)
+			}
+
+			for _, def := range defs {
+				for liveName, _ := range live {
+					if (liveName != def) && (liveName != movSrc) {
+						graph.addEdge(def, liveName)
+					}
+				}
+			}
+
+			transferLiveRegisters(live, uses, defs)
+		}
+	}
+
+	return &graph
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func (graph *Interference_Graph) addNode(node *Interference_Node) {
+	node.neighbors = make(map[string]bool)
+	node.color = -1
+	graph.nodes[node.name] = node
+	graph.order = append(graph.order, node.name)
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// nodes that are not in this graph are ignored, ex: a double register in the graph of general purpose registers
+func (graph *Interference_Graph) addEdge(name1 string, name2 string) {
+	node1, found1 := graph.nodes[name1]
+	node2, found2 := graph.nodes[name2]
+	if !found1 || !found2 {
+		return
+	}
+	node1.neighbors[name2] = true
+	node2.neighbors[name1] = true
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func registerNodeName(reg RegisterTypeAsm) string {
+	return "%" + getRegisterString(reg, QUADWORD_ASM_TYPE)
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func isAllocatablePseudo(name string, isDouble bool, aliased map[string]bool) bool {
+	if (len(name) == 0) || (name[0] == '%') || aliased[name] {
+		return false
+	}
+	sym := symbolTableBackend[name]
+	return !sym.isStatic && ((sym.asmTyp == DOUBLE_ASM_TYPE) == isDouble)
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// the name of the node for an operand, or "" if the operand can't be in the interference graph
+func getOperandNodeName(op Operand_Asm) string {
+	switch convertedOp := op.(type) {
+	case *Register_Operand_Asm:
+		return registerNodeName(convertedOp.reg)
+	case *Pseudoregister_Operand_Asm:
+		return convertedOp.name
+	}
+	return ""
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// the registers and pseudoregisters that an instruction reads and writes
+func getAsmUsesAndDefs(instr Instruction_Asm, fnName string) ([]string, []string) {
+	uses := []string{}
+	defs := []string{}
+
+	// reading an operand, a memory operand reads the register that holds the address
+	read := func(op Operand_Asm) {
+		mem, isMem := op.(*Memory_Operand_Asm)
+		if isMem {
+			uses = append(uses, registerNodeName(mem.reg))
+			return
+		}
+		indexed, isIndexed := op.(*Indexed_Operand_Asm)
+		if isIndexed {
+			uses = append(uses, registerNodeName(indexed.base), registerNodeName(indexed.index))
+			return
+		}
+		name := getOperandNodeName(op)
+		if name != "" {
+			uses = append(uses, name)
+		}
+	}
+	// writing to a memory operand still reads the register that holds the address
+	write := func(op Operand_Asm) {
+		_, isMem := op.(*Memory_Operand_Asm)
+		if isMem {
+			read(op)
+			return
+		}
+		name := getOperandNodeName(op)
+		if name != "" {
+			defs = append(defs, name)
+		}
+	}
+
+	switch convertedInstr := instr.(type) {
+	case *Mov_Instruction_Asm:
+		read(convertedInstr.src)
+		write(convertedInstr.dst)
+	case *Movsx_Instruction_Asm:
+		read(convertedInstr.src)
+		write(convertedInstr.dst)
+	case *Move_Zero_Extend_Instruction_Asm:
+		read(convertedInstr.src)
+		write(convertedInstr.dst)
+	case *Lea_Instruction_Asm:
+		// the src is an address, not a value that gets read
+		_, isMem := convertedInstr.src.(*Memory_Operand_Asm)
+		_, isIndexed := convertedInstr.src.(*Indexed_Operand_Asm)
+		if isMem || isIndexed {
+			read(convertedInstr.src)
+		}
+		write(convertedInstr.dst)
+	case *Cvttsd2si_Double_To_Int_Instruction_Asm:
+		read(convertedInstr.src)
+		write(convertedInstr.dst)
+	case *Cvtsi2sd_Int_To_Double_Instruction_Asm:
+		read(convertedInstr.src)
+		write(convertedInstr.dst)
+	case *Unary_Instruction_Asm:
+		read(convertedInstr.src)
+		write(convertedInstr.src)
+	case *Binary_Instruction_Asm:
+		// xor of a register with itself is how a register gets set to zero, it doesn't depend on the old value
+		srcReg, srcIsReg := convertedInstr.src.(*Register_Operand_Asm)
+		dstReg, dstIsReg := convertedInstr.dst.(*Register_Operand_Asm)
+		isZeroing := (convertedInstr.binOp == XOR_OPERATOR_ASM) && srcIsReg && dstIsReg && (srcReg.reg == dstReg.reg)
+		if !isZeroing {
+			read(convertedInstr.src)
+			read(convertedInstr.dst)
+		}
+		write(convertedInstr.dst)
+	case *Compare_Instruction_Asm:
+		read(convertedInstr.op1)
+		read(convertedInstr.op2)
+	case *IDivide_Instruction_Asm:
+		read(convertedInstr.divisor)
+		uses = append(uses, registerNodeName(AX_REGISTER_ASM), registerNodeName(DX_REGISTER_ASM))
+		defs = append(defs, registerNodeName(AX_REGISTER_ASM), registerNodeName(DX_REGISTER_ASM))
+	case *Divide_Instruction_Asm:
+		read(convertedInstr.divisor)
+		uses = append(uses, registerNodeName(AX_REGISTER_ASM), registerNodeName(DX_REGISTER_ASM))
+		defs = append(defs, registerNodeName(AX_REGISTER_ASM), registerNodeName(DX_REGISTER_ASM))
+	case *Multiply_High_Instruction_Asm:
+		read(convertedInstr.src)
+		uses = append(uses, registerNodeName(AX_REGISTER_ASM))
+		defs = append(defs, registerNodeName(AX_REGISTER_ASM), registerNodeName(DX_REGISTER_ASM))
+	case *CDQ_Sign_Extend_Instruction_Asm:
+		uses = append(uses, registerNodeName(AX_REGISTER_ASM))
+		defs = append(defs, registerNodeName(DX_REGISTER_ASM))
+	case *Set_Conditional_Instruction_Asm:
+		// set only writes the lowest byte, the rest of the bytes come from the mov of 0 before it
+		read(convertedInstr.dst)
+		write(convertedInstr.dst)
+	case *Conditional_Move_Instruction_Asm:
+		// the dst keeps its old value when the condition is false
+		read(convertedInstr.src)
+		read(convertedInstr.dst)
+		write(convertedInstr.dst)
+	case *Push_Instruction_Asm:
+		read(convertedInstr.op)
+	case *Call_Function_Asm:
+		for _, reg := range getParamRegisters(convertedInstr.name) {
+			uses = append(uses, registerNodeName(reg))
+		}
+		// the function we call can change any of the caller saved registers
+		for _, reg := range append(ALLOCATABLE_INT_REGISTERS, ALLOCATABLE_DOUBLE_REGISTERS...) {
+			if !isCalleeSaved(reg) {
+				defs = append(defs, registerNodeName(reg))
+			}
+		}
+	case *Tail_Call_Function_Asm:
+		// our frame is gone after the jump, so nothing else is used afterwards
+		for _, reg := range getParamRegisters(convertedInstr.name) {
+			uses = append(uses, registerNodeName(reg))
+		}
+	case *Ret_Instruction_Asm:
+		returnType := symbolTable[fnName].dataTyp.returnType
+		if returnType.typ == DOUBLE_TYPE {
+			uses = append(uses, registerNodeName(XMM0_REGISTER_ASM))
+		} else {
+			uses = append(uses, registerNodeName(AX_REGISTER_ASM))
+		}
+	}
+
+	return uses, defs
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// the registers that the arguments are passed in when calling the function
+func getParamRegisters(funcName string) []RegisterTypeAsm {
+	registers := []RegisterTypeAsm{}
+	intCount := 0
+	doubleCount := 0
+	for _, paramTyp := range symbolTable[funcName].dataTyp.paramTypes {
+		if (paramTyp.typ == DOUBLE_TYPE) && (doubleCount < len(DOUBLE_ARG_REGISTERS)) {
+			registers = append(registers, DOUBLE_ARG_REGISTERS[doubleCount])
+			doubleCount++
+		} else if (paramTyp.typ != DOUBLE_TYPE) && (intCount < len(INT_ARG_REGISTERS)) {
+			registers = append(registers, INT_ARG_REGISTERS[intCount])
+			intCount++
+		}
+	}
+	return registers
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func isCalleeSaved(reg RegisterTypeAsm) bool {
+	for _, saved := range CALLEE_SAVED_REGISTERS {
+		if saved == reg {
+			return true
+		}
+	}
+	return false
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+func makeControlFlowGraphAsm(instructions []Instruction_Asm) *Control_Flow_Graph_Asm {
+	cfg := Control_Flow_Graph_Asm{entry: &Basic_Block_Asm{id: 0}}
+
+	// a basic block starts at a label and ends after a jump or return
+	current := []Instruction_Asm{}
+	finishBlock := func() {
+		if len(current) > 0 {
+			cfg.blocks = append(cfg.blocks, &Basic_Block_Asm{id: len(cfg.blocks) + 1, instructions: current})
+			current = []Instruction_Asm{}
+		}
+	}
+	for _, instr := range instructions {
+		switch instr.(type) {
+		case *Label_Instruction_Asm:
+			finishBlock()
+			current = append(current, instr)
+		case *Jump_Instruction_Asm, *Jump_Conditional_Instruction_Asm, *Ret_Instruction_Asm, *Tail_Call_Function_Asm:
+			current = append(current, instr)
+			finishBlock()
+		default:
+			current = append(current, instr)
+		}
+	}
+	finishBlock()
+	cfg.exit = &Basic_Block_Asm{id: len(cfg.blocks) + 1}
+
+	labelToBlock := make(map[string]*Basic_Block_Asm)
+	for _, block := range cfg.blocks {
+		lbl, isLabel := block.instructions[0].(*Label_Instruction_Asm)
+		if isLabel {
+			labelToBlock[lbl.name] = block
+		}
+	}
+
+	if len(cfg.blocks) == 0 {
+		addEdgeAsm(cfg.entry, cfg.exit)
+		return &cfg
+	}
+	addEdgeAsm(cfg.entry, cfg.blocks[0])
+
+	for index, block := range cfg.blocks {
+		nextBlock := cfg.exit
+		if (index + 1) < len(cfg.blocks) {
+			nextBlock = cfg.blocks[index+1]
+		}
+
+		switch convertedInstr := block.instructions[len(block.instructions)-1].(type) {
+		case *Ret_Instruction_Asm, *Tail_Call_Function_Asm:
+			addEdgeAsm(block, cfg.exit)
+		case *Jump_Instruction_Asm:
+			addEdgeAsm(block, labelToBlock[convertedInstr.target])
+		case *Jump_Conditional_Instruction_Asm:
+			addEdgeAsm(block, labelToBlock[convertedInstr.target])
+			addEdgeAsm(block, nextBlock)
+		default:
+			addEdgeAsm(block, nextBlock)
+		}
+	}
+
+	return &cfg
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func addEdgeAsm(from *Basic_Block_Asm, to *Basic_Block_Asm) {
+	if to == nil {
+		fail("Jump to a label that doesn't exist")
+	}
+
+	for _, succ := range from.succs {
+		if succ == to {
+			return
+		}
+	}
+	from.succs = append(from.succs, to)
+	to.preds = append(to.preds, from)
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// backward dataflow analysis, returns what is live at the end of each block
+func findLiveRegisters(cfg *Control_Flow_Graph_Asm, fnName string) map[*Basic_Block_Asm]Live_Registers {
+	blockIn := make(map[*Basic_Block_Asm]Live_Registers)
+	blockOut := make(map[*Basic_Block_Asm]Live_Registers)
+	blockIn[cfg.exit] = make(Live_Registers)
+
+	worklist := make([]*Basic_Block_Asm, len(cfg.blocks))
+	for index, block := range cfg.blocks {
+		worklist[len(cfg.blocks)-1-index] = block
+	}
+	for len(worklist) > 0 {
+		block := worklist[0]
+		worklist = worklist[1:]
+
+		out := make(Live_Registers)
+		for _, succ := range block.succs {
+			for name, _ := range blockIn[succ] {
+				out[name] = true
+			}
+		}
+		blockOut[block] = out
+
+		in := copyLiveRegisters(out)
+		for index := len(block.instructions) - 1; index >= 0; index-- {
+			uses, defs := getAsmUsesAndDefs(block.instructions[index], fnName)
+			transferLiveRegisters(in, uses, defs)
+		}
+
+		oldIn, visited := blockIn[block]
+		if !visited || !isSameLiveRegisters(in, oldIn) {
+			blockIn[block] = in
+			for _, pred := range block.preds {
+				if pred != cfg.entry {
+					worklist = append(worklist, pred)
+				}
+			}
+		}
+	}
+
+	return blockOut
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func transferLiveRegisters(live Live_Registers, uses []string, defs []string) {
+	for _, def := range defs {
+		delete(live, def)
+	}
+	for _, use := range uses {
+		live[use] = true
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func copyLiveRegisters(input Live_Registers) Live_Registers {
+	output := make(Live_Registers)
+	for name, _ := range input {
+		output[name] = true
+	}
+	return output
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func isSameLiveRegisters(a Live_Registers, b Live_Registers) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, _ := range a {
+		if !b[name] {
+			return false
+		}
+	}
+	return true
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// Conservative coalescing. The src and dst of a mov that don't interfere can be merged into one node, and then the
+// mov moves the node to itself and can be removed. Merging is only done when it can't make the graph harder to color,
+// which is checked with the Briggs test for two pseudoregisters and the George test for a pseudoregister and a hard
+// register. Returns what each merged pseudoregister should be replaced with.
+func (graph *Interference_Graph) coalesce(instructions []Instruction_Asm) map[string]Operand_Asm {
+	// union find, each merged node points to the node it was merged into
+	mergedInto := make(map[string]string)
+	find := func(name string) string {
+		for {
+			parent, merged := mergedInto[name]
+			if !merged {
+				return name
+			}
+			name = parent
+		}
+	}
+
+	for _, instr := range instructions {
+		mov, isMov := instr.(*Mov_Instruction_Asm)
+		if !isMov {
+			continue
+		}
+		src, srcFound := graph.nodes[find(getOperandNodeName(mov.src))]
+		dst, dstFound := graph.nodes[find(getOperandNodeName(mov.dst))]
+		if !srcFound || !dstFound || (src == dst) || src.neighbors[dst.name] {
+			continue
+		}
+		if src.isRegister && dst.isRegister {
+			continue
+		}
+
+		// keep the hard register if there is one, since it's already colored
+		keep, remove := dst, src
+		if src.isRegister {
+			keep, remove = src, dst
+		}
+
+		canMerge := false
+		if keep.isRegister {
+			canMerge = graph.georgeTest(remove, keep)
+		} else {
+			// a pseudoregister that gets spilled needs a stack slot big enough for every value in it
+			canMerge = (symbolTableBackend[keep.name].asmTyp == symbolTableBackend[remove.name].asmTyp) &&
+				graph.briggsTest(keep, remove)
+		}
+		if canMerge {
+			graph.mergeNodes(remove, keep)
+			mergedInto[remove.name] = keep.name
+		}
+	}
+
+	coalesced := make(map[string]Operand_Asm)
+	for name, _ := range mergedInto {
+		root := graph.nodes[find(name)]
+		if root.isRegister {
+			coalesced[name] = &Register_Operand_Asm{root.reg}
+		} else {
+			coalesced[name] = &Pseudoregister_Operand_Asm{root.name}
+		}
+	}
+	return coalesced
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// The merged node can always be colored if it has fewer than k neighbors with k or more neighbors. The other ones can
+// be pruned first, and then there's a color left for it.
+func (graph *Interference_Graph) briggsTest(node1 *Interference_Node, node2 *Interference_Node) bool {
+	combined := make(map[string]bool)
+	for name, _ := range node1.neighbors {
+		combined[name] = true
+	}
+	for name, _ := range node2.neighbors {
+		combined[name] = true
+	}
+
+	significant := 0
+	for name, _ := range combined {
+		degree := len(graph.nodes[name].neighbors)
+		// a neighbor of both nodes loses one neighbor after the merge
+		if node1.neighbors[name] && node2.neighbors[name] {
+			degree--
+		}
+		if degree >= graph.k {
+			significant++
+		}
+	}
+	return significant < graph.k
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// The pseudoregister can be merged into the hard register if each of its neighbors already interferes with the hard
+// register, or has few enough neighbors that it will always be colored.
+func (graph *Interference_Graph) georgeTest(pseudo *Interference_Node, hardReg *Interference_Node) bool {
+	for name, _ := range pseudo.neighbors {
+		if !hardReg.neighbors[name] && (len(graph.nodes[name].neighbors) >= graph.k) {
+			return false
+		}
+	}
+	return true
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func (graph *Interference_Graph) mergeNodes(remove *Interference_Node, keep *Interference_Node) {
+	for name, _ := range remove.neighbors {
+		graph.addEdge(keep.name, name)
+		delete(graph.nodes[name].neighbors, remove.name)
+	}
+	keep.spillCost += remove.spillCost
+
+	delete(graph.nodes, remove.name)
+	for index, _ := range graph.order {
+		if graph.order[index] == remove.name {
+			graph.order = append(graph.order[:index], graph.order[index+1:]...)
+			break
+		}
+	}
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// Chaitin-Briggs coloring. Nodes with fewer than k neighbors can always be colored, so they are removed from the graph
+// first. When there aren't any left, the cheapest node is removed and optimistically colored later, if it doesn't get
+// a color then it is spilled.
+func (graph *Interference_Graph) colorGraph() {
+	stack := []*Interference_Node{}
+
+	for len(stack) < len(graph.nodes) {
+		var chosen *Interference_Node
+		for _, name := range graph.order {
+			node := graph.nodes[name]
+			if !node.pruned && (graph.getUnprunedDegree(node) < graph.k) {
+				chosen = node
+				break
+			}
+		}
+
+		if chosen == nil {
+			bestRatio := math.Inf(1)
+			for _, name := range graph.order {
+				node := graph.nodes[name]
+				if node.pruned {
+					continue
+				}
+				ratio := node.spillCost / float64(graph.getUnprunedDegree(node))
+				if (chosen == nil) || (ratio < bestRatio) {
+					chosen = node
+					bestRatio = ratio
+				}
+			}
+		}
+
+		chosen.pruned = true
+		stack = append(stack, chosen)
+	}
+
+	for index := len(stack) - 1; index >= 0; index-- {
+		node := stack[index]
+		available := make([]bool, graph.k)
+		for color, _ := range available {
+			available[color] = true
+		}
+		for neighborName, _ := range node.neighbors {
+			neighbor := graph.nodes[neighborName]
+			if !neighbor.pruned && (neighbor.color >= 0) {
+				available[neighbor.color] = false
+			}
+		}
+
+		// Callee saved registers take the highest colors, and pseudoregisters take the lowest colors, so that the
+		// pseudoregisters use caller saved registers when they can. Those don't need to be saved in the prologue.
+		if node.isRegister && isCalleeSaved(node.reg) {
+			for color := graph.k - 1; color >= 0; color-- {
+				if available[color] {
+					node.color = color
+					break
+				}
+			}
+		} else {
+			for color := 0; color < graph.k; color++ {
+				if available[color] {
+					node.color = color
+					break
+				}
+			}
+		}
+		node.pruned = false
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func (graph *Interference_Graph) getUnprunedDegree(node *Interference_Node) int {
+	degree := 0
+	for neighborName, _ := range node.neighbors {
+		if !graph.nodes[neighborName].pruned {
+			degree++
+		}
+	}
+	return degree
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// maps each pseudoregister that got a color to the hard register with the same color
+func (graph *Interference_Graph) getRegisterAssignments() map[string]RegisterTypeAsm {
+	colorToRegister := make(map[int]RegisterTypeAsm)
+	for _, node := range graph.nodes {
+		if node.isRegister {
+			colorToRegister[node.color] = node.reg
+		}
+	}
+
+	assignments := make(map[string]RegisterTypeAsm)
+	for _, node := range graph.nodes {
+		if !node.isRegister && (node.color >= 0) {
+			assignments[node.name] = colorToRegister[node.color]
+		}
+	}
+	return assignments
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// the pseudoregisters that didn't get a register are left alone, they get a spot on the stack in replacePseudoregisters
+func (fn *Function_Asm) replaceAllocatedPseudoregisters(assignments map[string]RegisterTypeAsm) {
+	fn.replaceOperands(func(op Operand_Asm) Operand_Asm {
+		pseudo, isPseudo := op.(*Pseudoregister_Operand_Asm)
+		if !isPseudo {
+			return op
+		}
+		reg, allocated := assignments[pseudo.name]
+		if !allocated {
+			return op
+		}
+		return &Register_Operand_Asm{reg}
+	})
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func (fn *Function_Asm) replaceCoalescedPseudoregisters(coalesced map[string]Operand_Asm) {
+	fn.replaceOperands(func(op Operand_Asm) Operand_Asm {
+		pseudo, isPseudo := op.(*Pseudoregister_Operand_Asm)
+		if !isPseudo {
+			return op
+		}
+		replacement, merged := coalesced[pseudo.name]
+		if !merged {
+			return op
+		}
+		return replacement
+	})
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// passes every operand through replace, and removes the movs that end up moving something to itself
+func (fn *Function_Asm) replaceOperands(replace func(Operand_Asm) Operand_Asm) {
+	instructions := []Instruction_Asm{}
+	for _, instr := range fn.instructions {
+		replaceInstructionOperands(instr, replace)
+		mov, isMov := instr.(*Mov_Instruction_Asm)
+		if isMov && isSameOperandAsm(mov.src, mov.dst) {
+			continue
+		}
+		instructions = append(instructions, instr)
+	}
+	fn.instructions = instructions
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func replaceInstructionOperands(instr Instruction_Asm, replace func(Operand_Asm) Operand_Asm) {
+	switch convertedInstr := instr.(type) {
+	case *Mov_Instruction_Asm:
+		convertedInstr.src = replace(convertedInstr.src)
+		convertedInstr.dst = replace(convertedInstr.dst)
+	case *Movsx_Instruction_Asm:
+		convertedInstr.src = replace(convertedInstr.src)
+		convertedInstr.dst = replace(convertedInstr.dst)
+	case *Move_Zero_Extend_Instruction_Asm:
+		convertedInstr.src = replace(convertedInstr.src)
+		convertedInstr.dst = replace(convertedInstr.dst)
+	case *Lea_Instruction_Asm:
+		convertedInstr.src = replace(convertedInstr.src)
+		convertedInstr.dst = replace(convertedInstr.dst)
+	case *Cvttsd2si_Double_To_Int_Instruction_Asm:
+		convertedInstr.src = replace(convertedInstr.src)
+		convertedInstr.dst = replace(convertedInstr.dst)
+	case *Cvtsi2sd_Int_To_Double_Instruction_Asm:
+		convertedInstr.src = replace(convertedInstr.src)
+		convertedInstr.dst = replace(convertedInstr.dst)
+	case *Unary_Instruction_Asm:
+		convertedInstr.src = replace(convertedInstr.src)
+	case *Binary_Instruction_Asm:
+		convertedInstr.src = replace(convertedInstr.src)
+		convertedInstr.dst = replace(convertedInstr.dst)
+	case *IDivide_Instruction_Asm:
+		convertedInstr.divisor = replace(convertedInstr.divisor)
+	case *Divide_Instruction_Asm:
+		convertedInstr.divisor = replace(convertedInstr.divisor)
+	case *Multiply_High_Instruction_Asm:
+		convertedInstr.src = replace(convertedInstr.src)
+	case *Compare_Instruction_Asm:
+		convertedInstr.op1 = replace(convertedInstr.op1)
+		convertedInstr.op2 = replace(convertedInstr.op2)
+	case *Set_Conditional_Instruction_Asm:
+		convertedInstr.dst = replace(convertedInstr.dst)
+	case *Conditional_Move_Instruction_Asm:
+		convertedInstr.src = replace(convertedInstr.src)
+		convertedInstr.dst = replace(convertedInstr.dst)
+	case *Push_Instruction_Asm:
+		convertedInstr.op = replace(convertedInstr.op)
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func isSameOperandAsm(a Operand_Asm, b Operand_Asm) bool {
+	switch convertedA := a.(type) {
+	case *Register_Operand_Asm:
+		convertedB, isReg := b.(*Register_Operand_Asm)
+		return isReg && (convertedA.reg == convertedB.reg)
+	case *Pseudoregister_Operand_Asm:
+		convertedB, isPseudo := b.(*Pseudoregister_Operand_Asm)
+		return isPseudo && (convertedA.name == convertedB.name)
+	case *Memory_Operand_Asm:
+		convertedB, isMem := b.(*Memory_Operand_Asm)
+		return isMem && (convertedA.reg == convertedB.reg) && (convertedA.offset == convertedB.offset)
+	case *Data_Operand_Asm:
+		convertedB, isData := b.(*Data_Operand_Asm)
+		return isData && (convertedA.name == convertedB.name)
+	}
+	return false
+}
@@ -0,0 +1,430 @@
+package main
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+type LatticeStateEnum int
+
+const (
+	// nothing that can run has given the variable a value yet
+	UNDEFINED_LATTICE LatticeStateEnum = iota
+	CONSTANT_LATTICE
+	// the variable can have more than one value
+	OVERDEFINED_LATTICE
+)
+
+type Lattice_Value struct {
+	state    LatticeStateEnum
+	constant *Constant_Value_Tacky
+}
+
+type CFG_Edge struct {
+	from *Basic_Block
+	to   *Basic_Block
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+type SCCP_State struct {
+	ssa     *SSA_Function
+	values  map[string]Lattice_Value
+	defined map[string]bool
+	// the instructions that read each variable, and the block they are in
+	useSites   map[string][]SSA_Use
+	executable map[CFG_Edge]bool
+	reached    map[*Basic_Block]bool
+	edgeList   []CFG_Edge
+	varList    []string
+}
+
+type SSA_Use struct {
+	block *Basic_Block
+	instr Instruction_Tacky
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// Sparse conditional constant propagation, from "Constant Propagation with Conditional Branches" by Wegman and
+// Zadeck. Variables are assumed to be undefined until an instruction that can run gives them a value, and a branch
+// only makes its targets reachable for the conditions it can have. The variables that end up constant are replaced by
+// their values, and the blocks that can't run are removed.
+func propagateConstants(ssa *SSA_Function) bool {
+	state := SCCP_State{ssa: ssa, values: make(map[string]Lattice_Value), defined: make(map[string]bool),
+		useSites: make(map[string][]SSA_Use), executable: make(map[CFG_Edge]bool), reached: make(map[*Basic_Block]bool)}
+
+	for _, block := range ssa.cfg.blocks {
+		for _, instr := range block.instructions {
+			dst, isVar := getInstructionDst(instr).(*Variable_Value_Tacky)
+			if isVar {
+				state.defined[dst.name] = true
+			}
+			for _, val := range getInstructionUses(instr) {
+				v, isVar := val.(*Variable_Value_Tacky)
+				if isVar {
+					state.useSites[v.name] = append(state.useSites[v.name], SSA_Use{block: block, instr: instr})
+				}
+			}
+		}
+	}
+
+	state.markEdgeExecutable(ssa.cfg.entry, ssa.cfg.blocks[0])
+	for (len(state.edgeList) > 0) || (len(state.varList) > 0) {
+		if len(state.edgeList) > 0 {
+			edge := state.edgeList[len(state.edgeList)-1]
+			state.edgeList = state.edgeList[:len(state.edgeList)-1]
+			state.visitEdge(edge)
+			continue
+		}
+		name := state.varList[len(state.varList)-1]
+		state.varList = state.varList[:len(state.varList)-1]
+		for _, use := range state.useSites[name] {
+			if state.reached[use.block] {
+				state.evaluate(use.block, use.instr)
+			}
+		}
+	}
+
+	if !state.rewrite() {
+		return false
+	}
+	ssa.updateControlFlow()
+	return true
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func (state *SCCP_State) markEdgeExecutable(from *Basic_Block, to *Basic_Block) {
+	edge := CFG_Edge{from: from, to: to}
+	if state.executable[edge] {
+		return
+	}
+	state.executable[edge] = true
+	state.edgeList = append(state.edgeList, edge)
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// the first time a block is reached all of its instructions are evaluated, after that a new edge into it can only
+// change its phis
+func (state *SCCP_State) visitEdge(edge CFG_Edge) {
+	block := edge.to
+	if block == state.ssa.cfg.exit {
+		return
+	}
+	firstVisit := !state.reached[block]
+	state.reached[block] = true
+
+	for _, instr := range block.instructions {
+		_, isPhi := instr.(*Phi_Instruction_Tacky)
+		if isPhi || firstVisit {
+			state.evaluate(block, instr)
+		}
+	}
+
+	// a block that doesn't end with a jump goes on to the next block, removing a constant branch can leave it empty
+	if firstVisit && (len(block.succs) == 1) {
+		var lastInstr Instruction_Tacky
+		if len(block.instructions) > 0 {
+			lastInstr = block.instructions[len(block.instructions)-1]
+		}
+		switch lastInstr.(type) {
+		case *Jump_If_Zero_Instruction_Tacky, *Jump_If_Not_Zero_Instruction_Tacky, *Jump_If_Compare_Instruction_Tacky:
+		default:
+			state.markEdgeExecutable(block, block.succs[0])
+		}
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func (state *SCCP_State) evaluate(block *Basic_Block, instr Instruction_Tacky) {
+	switch convertedInstr := instr.(type) {
+	case *Phi_Instruction_Tacky:
+		result := Lattice_Value{state: UNDEFINED_LATTICE}
+		for _, arg := range convertedInstr.args {
+			if state.executable[CFG_Edge{from: arg.pred, to: block}] {
+				result = meetLatticeValues(result, state.getLatticeValue(arg.val))
+			}
+		}
+		state.setLatticeValue(convertedInstr.dst, result)
+		return
+	case *Jump_Instruction_Tacky:
+		state.markEdgeExecutable(block, block.succs[0])
+		return
+	case *Jump_If_Zero_Instruction_Tacky:
+		state.evaluateBranch(block, state.getLatticeValue(convertedInstr.condition), convertedInstr.target, true)
+		return
+	case *Jump_If_Not_Zero_Instruction_Tacky:
+		state.evaluateBranch(block, state.getLatticeValue(convertedInstr.condition), convertedInstr.target, false)
+		return
+	case *Jump_If_Compare_Instruction_Tacky:
+		state.evaluateBranch(block, state.getComparisonLatticeValue(convertedInstr), convertedInstr.target, false)
+		return
+	case *Copy_Instruction_Tacky:
+		value := state.getLatticeValue(convertedInstr.src)
+		if (value.state == CONSTANT_LATTICE) && (value.constant.typ != convertedInstr.dst.getDataType()) {
+			// a copy between signed and unsigned types keeps the bits
+			value.constant = makeIntegerConstant(convertedInstr.dst.getDataType(), getIntegerBits(value.constant))
+		}
+		state.setLatticeValue(convertedInstr.dst, value)
+		return
+	case *Conditional_Copy_Instruction_Tacky:
+		// once the comparison is known it's a copy of the src that gets picked. A variable that isn't in SSA can be
+		// one of the srcs too, so it's left overdefined instead of going back and forth between the two values.
+		if !state.ssa.isSingleAssignment(convertedInstr.dst.(*Variable_Value_Tacky)) {
+			break
+		}
+		withConstants := replaceInstructionUses(instr, func(val Value_Tacky) Value_Tacky {
+			value := state.getLatticeValue(val)
+			if value.state == CONSTANT_LATTICE {
+				return value.constant
+			}
+			return val
+		}).(*Conditional_Copy_Instruction_Tacky)
+		src, ok := foldConditionalCopy(withConstants)
+		if ok {
+			state.evaluate(block, &Copy_Instruction_Tacky{src: src, dst: convertedInstr.dst})
+			return
+		}
+	case *Function_Call_Tacky, *Load_Instruction_Tacky, *Get_Address_Instruction_Tacky:
+		state.setLatticeValue(getInstructionDst(instr), Lattice_Value{state: OVERDEFINED_LATTICE})
+		return
+	}
+
+	dst := getInstructionDst(instr)
+	if dst == nil {
+		return
+	}
+	for _, val := range getInstructionUses(instr) {
+		switch state.getLatticeValue(val).state {
+		case OVERDEFINED_LATTICE:
+			state.setLatticeValue(dst, Lattice_Value{state: OVERDEFINED_LATTICE})
+			return
+		case UNDEFINED_LATTICE:
+			return
+		}
+	}
+
+	// every src is a constant so the instruction can be folded like constant folding does
+	withConstants := replaceInstructionUses(instr, func(val Value_Tacky) Value_Tacky {
+		return state.getLatticeValue(val).constant
+	})
+	folded, _, ok := foldInstruction(withConstants)
+	if ok {
+		state.setLatticeValue(dst, Lattice_Value{state: CONSTANT_LATTICE, constant: folded})
+	} else {
+		state.setLatticeValue(dst, Lattice_Value{state: OVERDEFINED_LATTICE})
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func (state *SCCP_State) evaluateBranch(block *Basic_Block, value Lattice_Value, target string, jumpIfZero bool) {
+	switch value.state {
+	case UNDEFINED_LATTICE:
+		return
+	case OVERDEFINED_LATTICE:
+		for _, succ := range block.succs {
+			state.markEdgeExecutable(block, succ)
+		}
+		return
+	}
+
+	targetBlock, nextBlock := getBranchSuccessors(block, target)
+	if isZeroConstant(value.constant) == jumpIfZero {
+		state.markEdgeExecutable(block, targetBlock)
+	} else {
+		state.markEdgeExecutable(block, nextBlock)
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// the comparison of a JUMP_IF is 1 or 0 once both sides are constants
+func (state *SCCP_State) getComparisonLatticeValue(instr *Jump_If_Compare_Instruction_Tacky) Lattice_Value {
+	value1 := state.getLatticeValue(instr.src1)
+	value2 := state.getLatticeValue(instr.src2)
+	if (value1.state == OVERDEFINED_LATTICE) || (value2.state == OVERDEFINED_LATTICE) {
+		return Lattice_Value{state: OVERDEFINED_LATTICE}
+	}
+	if (value1.state == UNDEFINED_LATTICE) || (value2.state == UNDEFINED_LATTICE) {
+		return Lattice_Value{state: UNDEFINED_LATTICE}
+	}
+	result, ok := foldBinary(instr.binOp, value1.constant, value2.constant, INT_TYPE)
+	if !ok {
+		return Lattice_Value{state: OVERDEFINED_LATTICE}
+	}
+	return Lattice_Value{state: CONSTANT_LATTICE, constant: result}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// returns the block a conditional jump goes to when it's taken, and the block it falls through to otherwise
+func getBranchSuccessors(block *Basic_Block, target string) (*Basic_Block, *Basic_Block) {
+	var targetBlock, nextBlock *Basic_Block
+	for _, succ := range block.succs {
+		isTarget := false
+		if len(succ.instructions) > 0 {
+			lbl, isLabel := succ.instructions[0].(*Label_Instruction_Tacky)
+			isTarget = isLabel && (lbl.name == target)
+		}
+		if isTarget && (targetBlock == nil) {
+			targetBlock = succ
+		} else {
+			nextBlock = succ
+		}
+	}
+	// the jump goes to the next block either way
+	if nextBlock == nil {
+		nextBlock = targetBlock
+	}
+	return targetBlock, nextBlock
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// Variables that aren't written in the function, like params, and variables that weren't renamed, can have any value.
+func (state *SCCP_State) getLatticeValue(val Value_Tacky) Lattice_Value {
+	switch convertedVal := val.(type) {
+	case *Constant_Value_Tacky:
+		return Lattice_Value{state: CONSTANT_LATTICE, constant: convertedVal}
+	case *Variable_Value_Tacky:
+		if !state.defined[convertedVal.name] || !state.ssa.renamed[state.ssa.original[convertedVal.name]] {
+			return Lattice_Value{state: OVERDEFINED_LATTICE}
+		}
+		return state.values[convertedVal.name]
+	}
+	return Lattice_Value{state: OVERDEFINED_LATTICE}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// values only move down the lattice, so each variable is added to the worklist at most twice
+func (state *SCCP_State) setLatticeValue(dst Value_Tacky, value Lattice_Value) {
+	v, isVar := dst.(*Variable_Value_Tacky)
+	if !isVar {
+		return
+	}
+	old := state.values[v.name]
+	if (old.state == value.state) && ((value.state != CONSTANT_LATTICE) || isSameValue(old.constant, value.constant)) {
+		return
+	}
+	state.values[v.name] = value
+	state.varList = append(state.varList, v.name)
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func meetLatticeValues(a Lattice_Value, b Lattice_Value) Lattice_Value {
+	if a.state == UNDEFINED_LATTICE {
+		return b
+	}
+	if b.state == UNDEFINED_LATTICE {
+		return a
+	}
+	if (a.state == CONSTANT_LATTICE) && (b.state == CONSTANT_LATTICE) && isSameValue(a.constant, b.constant) {
+		return a
+	}
+	return Lattice_Value{state: OVERDEFINED_LATTICE}
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// Replaces the constant variables with their values, turns the branches that only go one way into jumps or removes
+// them, and removes the blocks that were never reached. Returns false if nothing changed. Copies and phis don't count
+// as changes, because leaving SSA turns a phi of a constant back into copies of it.
+func (state *SCCP_State) rewrite() bool {
+	cfg := state.ssa.cfg
+	changed := false
+
+	isCopyOrPhi := func(instr Instruction_Tacky) bool {
+		switch instr.(type) {
+		case *Copy_Instruction_Tacky, *Phi_Instruction_Tacky:
+			return true
+		}
+		return false
+	}
+	var current Instruction_Tacky
+	replace := func(val Value_Tacky) Value_Tacky {
+		v, isVar := val.(*Variable_Value_Tacky)
+		if !isVar {
+			return val
+		}
+		value := state.getLatticeValue(v)
+		if value.state != CONSTANT_LATTICE {
+			return val
+		}
+		if !isCopyOrPhi(current) {
+			changed = true
+		}
+		return value.constant
+	}
+
+	keptBlocks := []*Basic_Block{}
+	for _, block := range cfg.blocks {
+		if !state.reached[block] {
+			changed = true
+			continue
+		}
+		keptBlocks = append(keptBlocks, block)
+
+		instructions := []Instruction_Tacky{}
+		for _, instr := range block.instructions {
+			switch convertedInstr := instr.(type) {
+			case *Phi_Instruction_Tacky:
+				// the args from edges that can't run aren't needed
+				args := []Phi_Argument{}
+				for _, arg := range convertedInstr.args {
+					if state.executable[CFG_Edge{from: arg.pred, to: block}] {
+						args = append(args, arg)
+					}
+				}
+				instr = &Phi_Instruction_Tacky{dst: convertedInstr.dst, args: args}
+			case *Jump_If_Zero_Instruction_Tacky, *Jump_If_Not_Zero_Instruction_Tacky, *Jump_If_Compare_Instruction_Tacky:
+				target := ""
+				jumpIfZero := false
+				var value Lattice_Value
+				switch convertedJump := instr.(type) {
+				case *Jump_If_Zero_Instruction_Tacky:
+					target = convertedJump.target
+					jumpIfZero = true
+					value = state.getLatticeValue(convertedJump.condition)
+				case *Jump_If_Not_Zero_Instruction_Tacky:
+					target = convertedJump.target
+					value = state.getLatticeValue(convertedJump.condition)
+				case *Jump_If_Compare_Instruction_Tacky:
+					target = convertedJump.target
+					value = state.getComparisonLatticeValue(convertedJump)
+				}
+				if value.state == CONSTANT_LATTICE {
+					changed = true
+					if isZeroConstant(value.constant) == jumpIfZero {
+						instructions = append(instructions, &Jump_Instruction_Tacky{target: target})
+					}
+					continue
+				}
+			}
+
+			// the instruction that computes a constant isn't needed anymore, but calls still have to happen
+			_, isCall := instr.(*Function_Call_Tacky)
+			dst, isVar := getInstructionDst(instr).(*Variable_Value_Tacky)
+			if isVar && !isCall && (state.getLatticeValue(dst).state == CONSTANT_LATTICE) {
+				if !isCopyOrPhi(instr) {
+					changed = true
+				}
+				continue
+			}
+			current = instr
+			instructions = append(instructions, replaceInstructionUses(instr, replace))
+		}
+		block.instructions = instructions
+	}
+	cfg.blocks = keptBlocks
+
+	return changed
+}
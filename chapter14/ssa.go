@@ -0,0 +1,1070 @@
+package main
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// Only exists while a function is in SSA form, there is one arg for each predecessor of the phi's block. The dst gets
+// the arg of whichever predecessor execution came from.
+type Phi_Instruction_Tacky struct {
+	dst  Value_Tacky
+	args []Phi_Argument
+}
+
+type Phi_Argument struct {
+	pred *Basic_Block
+	val  Value_Tacky
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func (instr *Phi_Instruction_Tacky) instructionToAsm() []Instruction_Asm {
+	fail("phi should have been removed by convertFromSSA")
+	return nil
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// the results of converting a function to SSA, the dominator info is kept so the passes that use SSA can walk the
+// dominator tree too
+type SSA_Function struct {
+	cfg *Control_Flow_Graph
+	// the immediate dominator of each block, the entry block doesn't have one
+	idom     map[*Basic_Block]*Basic_Block
+	children map[*Basic_Block][]*Basic_Block
+	// the variables that were renamed, the other variables are static or have their address taken
+	renamed map[string]bool
+	// each new name points back to the variable it came from
+	original map[string]string
+	// set by a pass that had to leave SSA to finish its changes, the SSA passes after it don't run
+	exitBody []Instruction_Tacky
+}
+
+// Every time a function goes in and out of SSA its variables get new names. They are all made from the name the
+// variable had before the first time, so the names don't keep getting longer.
+var ssaBaseNames = make(map[string]string)
+
+// a copy that goes at the end of a predecessor when a phi is removed
+type Pred_Copy struct {
+	pred  *Basic_Block
+	instr Instruction_Tacky
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// Each variable that isn't static and doesn't have its address taken gets a new name at each place it's written, and
+// phis are added where different names for the same variable meet. The unreachable blocks are removed first since
+// they aren't in the dominator tree.
+func convertToSSA(body []Instruction_Tacky) *SSA_Function {
+	cfg := makeControlFlowGraph(body)
+	cfg.removeUnreachableBlocks()
+
+	ssa := SSA_Function{cfg: cfg, renamed: make(map[string]bool), original: make(map[string]string)}
+	ssa.findDominatorTree()
+
+	aliased := findAliasedVariables(body)
+	for _, instr := range body {
+		values := append([]Value_Tacky{getInstructionDst(instr)}, getInstructionUses(instr)...)
+		for _, val := range values {
+			v, isVar := val.(*Variable_Value_Tacky)
+			if isVar && !aliased[v.name] {
+				ssa.renamed[v.name] = true
+			}
+		}
+	}
+
+	phiVariables := ssa.insertPhis(findDominanceFrontiers(cfg, ssa.idom))
+	ssa.renameVariables(phiVariables)
+	return &ssa
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func (ssa *SSA_Function) findDominatorTree() {
+	ssa.idom = findImmediateDominators(ssa.cfg)
+	ssa.children = make(map[*Basic_Block][]*Basic_Block)
+	for _, block := range ssa.cfg.blocks {
+		parent := ssa.idom[block]
+		ssa.children[parent] = append(ssa.children[parent], block)
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// For a pass that changed the jumps without leaving SSA. The edges are found again, the blocks that can't be reached
+// anymore are removed, and the phis lose the args from the edges that are gone.
+func (ssa *SSA_Function) updateControlFlow() {
+	ssa.cfg.addEdges()
+	ssa.cfg.removeUnreachableBlocks()
+	for _, block := range ssa.cfg.blocks {
+		for index, instr := range block.instructions {
+			phi, isPhi := instr.(*Phi_Instruction_Tacky)
+			if !isPhi {
+				continue
+			}
+			args := []Phi_Argument{}
+			for _, arg := range phi.args {
+				if containsBlock(block.preds, arg.pred) {
+					args = append(args, arg)
+				}
+			}
+			block.instructions[index] = &Phi_Instruction_Tacky{dst: phi.dst, args: args}
+		}
+	}
+	ssa.findDominatorTree()
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// A phi for a variable goes in the dominance frontier of each block that writes to it, and the phi is another write
+// so it repeats until nothing is added. Variables that are never read outside of the block that wrote them don't need
+// phis (semi-pruned SSA).
+func (ssa *SSA_Function) insertPhis(frontiers map[*Basic_Block][]*Basic_Block) map[*Phi_Instruction_Tacky]string {
+	crossBlock := make(map[string]bool)
+	defBlocks := make(map[string][]*Basic_Block)
+	for _, block := range ssa.cfg.blocks {
+		written := make(map[string]bool)
+		for _, instr := range block.instructions {
+			for _, val := range getInstructionUses(instr) {
+				v, isVar := val.(*Variable_Value_Tacky)
+				if isVar && ssa.renamed[v.name] && !written[v.name] {
+					crossBlock[v.name] = true
+				}
+			}
+			v, isVar := getInstructionDst(instr).(*Variable_Value_Tacky)
+			if isVar && ssa.renamed[v.name] && !written[v.name] {
+				written[v.name] = true
+				defBlocks[v.name] = append(defBlocks[v.name], block)
+			}
+		}
+	}
+
+	phiVariables := make(map[*Phi_Instruction_Tacky]string)
+	for name, blocks := range defBlocks {
+		if !crossBlock[name] {
+			continue
+		}
+		hasPhi := make(map[*Basic_Block]bool)
+		worklist := append([]*Basic_Block{}, blocks...)
+		for len(worklist) > 0 {
+			block := worklist[len(worklist)-1]
+			worklist = worklist[:len(worklist)-1]
+			for _, frontier := range frontiers[block] {
+				if hasPhi[frontier] || (frontier == ssa.cfg.exit) {
+					continue
+				}
+				hasPhi[frontier] = true
+				phi := Phi_Instruction_Tacky{dst: &Variable_Value_Tacky{name}}
+				for _, pred := range frontier.preds {
+					phi.args = append(phi.args, Phi_Argument{pred: pred, val: &Variable_Value_Tacky{name}})
+				}
+				frontier.insertAfterLabel(&phi)
+				phiVariables[&phi] = name
+				worklist = append(worklist, frontier)
+			}
+		}
+	}
+	return phiVariables
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// Walks the dominator tree, each variable's current name is on the top of its stack. A variable that is read before
+// anything writes to it keeps its old name, which is how the params keep the value they were called with.
+func (ssa *SSA_Function) renameVariables(phiVariables map[*Phi_Instruction_Tacky]string) {
+	stacks := make(map[string][]string)
+	currentName := func(name string) string {
+		stack := stacks[name]
+		if len(stack) == 0 {
+			return name
+		}
+		return stack[len(stack)-1]
+	}
+	newName := func(name string) string {
+		baseName, found := ssaBaseNames[name]
+		if !found {
+			baseName = name
+		}
+		version := makeTempVarName(baseName)
+		ssaBaseNames[version] = baseName
+		symbolTable[version] = symbolTable[name]
+		ssa.original[version] = name
+		stacks[name] = append(stacks[name], version)
+		return version
+	}
+	replace := func(val Value_Tacky) Value_Tacky {
+		v, isVar := val.(*Variable_Value_Tacky)
+		if !isVar || !ssa.renamed[v.name] {
+			return val
+		}
+		return &Variable_Value_Tacky{currentName(v.name)}
+	}
+
+	var renameBlock func(block *Basic_Block)
+	renameBlock = func(block *Basic_Block) {
+		pushed := []string{}
+		for index, instr := range block.instructions {
+			phi, isPhi := instr.(*Phi_Instruction_Tacky)
+			if isPhi {
+				name := phiVariables[phi]
+				phi.dst = &Variable_Value_Tacky{newName(name)}
+				pushed = append(pushed, name)
+				continue
+			}
+
+			instr = replaceInstructionUses(instr, replace)
+			v, isVar := getInstructionDst(instr).(*Variable_Value_Tacky)
+			if isVar && ssa.renamed[v.name] {
+				instr = replaceInstructionDst(instr, &Variable_Value_Tacky{newName(v.name)})
+				pushed = append(pushed, v.name)
+			}
+			block.instructions[index] = instr
+		}
+
+		for _, succ := range block.succs {
+			for _, instr := range succ.instructions {
+				phi, isPhi := instr.(*Phi_Instruction_Tacky)
+				if !isPhi {
+					continue
+				}
+				for index, _ := range phi.args {
+					if phi.args[index].pred == block {
+						phi.args[index].val = &Variable_Value_Tacky{currentName(phiVariables[phi])}
+					}
+				}
+			}
+		}
+
+		for _, child := range ssa.children[block] {
+			renameBlock(child)
+		}
+		for _, name := range pushed {
+			stacks[name] = stacks[name][:len(stacks[name])-1]
+		}
+	}
+	renameBlock(ssa.cfg.entry)
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// The copies and the instructions that nothing reads are cleaned up first, so the phis have fewer names to merge. The
+// phis that only have one value and the phis that nothing reads are removed, and most of the others go away by giving
+// the phi's dst and args the same name (see coalescePhis). Each phi that is left gets its own temporary.
+// Every predecessor copies its arg to the temporary right before leaving, and the phi becomes a copy from the
+// temporary. Because the phi's dst is only written in the phi's own block, a predecessor that branches somewhere else
+// can't overwrite a value that is still live there (the lost copy problem), and because all of the temporaries are
+// written before any of the dsts, phis that read each other's dst get the old values (the swap problem).
+func convertFromSSA(ssa *SSA_Function) []Instruction_Tacky {
+	ssa.propagateCopies()
+	ssa.removeDeadInstructions()
+	ssa.removeTrivialPhis()
+	ssa.removeDeadPhis()
+	ssa.coalescePhis()
+
+	cfg := ssa.cfg
+	// the copies are added after all of the phis are replaced, so the phis don't move while they are being replaced
+	predCopies := []Pred_Copy{}
+	for _, block := range cfg.blocks {
+		for index, instr := range block.instructions {
+			phi, isPhi := instr.(*Phi_Instruction_Tacky)
+			if !isPhi {
+				continue
+			}
+			dst := phi.dst.(*Variable_Value_Tacky)
+			temp := &Variable_Value_Tacky{makeTempVarName("phi")}
+			symbolTable[temp.name] = symbolTable[dst.name]
+
+			for _, arg := range phi.args {
+				predCopies = append(predCopies, Pred_Copy{pred: arg.pred, instr: &Copy_Instruction_Tacky{src: arg.val, dst: temp}})
+			}
+			block.instructions[index] = &Copy_Instruction_Tacky{src: temp, dst: dst}
+		}
+	}
+
+	for _, predCopy := range predCopies {
+		cfg.insertAtEnd(predCopy.pred, predCopy.instr)
+	}
+	return cfg.toInstructions()
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// In SSA a copy's dst always has the src's value, so the dst can be replaced by the src everywhere as long as the src
+// can't change either. This removes the copies left by value numbering and by moving loop invariants, which would
+// otherwise stay around until the next round.
+func (ssa *SSA_Function) propagateCopies() {
+	replacements := make(map[string]Value_Tacky)
+	for _, block := range ssa.cfg.blocks {
+		for _, instr := range block.instructions {
+			copyInstr, isCopy := instr.(*Copy_Instruction_Tacky)
+			if !isCopy {
+				continue
+			}
+			dst, isVar := copyInstr.dst.(*Variable_Value_Tacky)
+			if !isVar || !ssa.isSingleAssignment(dst) || (copyInstr.src.getDataType() != dst.getDataType()) {
+				continue
+			}
+			src, isVar := copyInstr.src.(*Variable_Value_Tacky)
+			if isVar && !ssa.isSingleAssignment(src) {
+				continue
+			}
+			replacements[dst.name] = copyInstr.src
+		}
+	}
+	if len(replacements) == 0 {
+		return
+	}
+
+	// a copy can be of another copy, the limit is only there in case of a cycle in code that can't run
+	replace := func(val Value_Tacky) Value_Tacky {
+		for steps := 0; steps <= len(replacements); steps++ {
+			v, isVar := val.(*Variable_Value_Tacky)
+			if !isVar {
+				return val
+			}
+			src, found := replacements[v.name]
+			if !found {
+				return val
+			}
+			val = src
+		}
+		return val
+	}
+	for _, block := range ssa.cfg.blocks {
+		kept := []Instruction_Tacky{}
+		for _, instr := range block.instructions {
+			dst, isVar := getInstructionDst(instr).(*Variable_Value_Tacky)
+			_, isCopy := instr.(*Copy_Instruction_Tacky)
+			if isCopy && isVar && (replacements[dst.name] != nil) {
+				continue
+			}
+			kept = append(kept, replaceInstructionUses(instr, replace))
+		}
+		block.instructions = kept
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// An instruction is dead when nothing reads its dst, and removing it can make the instructions it read from dead too.
+// Calls are kept since they can have side effects, and the variables that aren't in SSA can be read through pointers.
+func (ssa *SSA_Function) removeDeadInstructions() {
+	useCounts := make(map[string]int)
+	for _, block := range ssa.cfg.blocks {
+		for _, instr := range block.instructions {
+			for _, val := range getInstructionUses(instr) {
+				useCounts[getTackyValueString(val)]++
+			}
+		}
+	}
+
+	removed := true
+	for removed {
+		removed = false
+		for _, block := range ssa.cfg.blocks {
+			kept := []Instruction_Tacky{}
+			for _, instr := range block.instructions {
+				_, isCall := instr.(*Function_Call_Tacky)
+				dst, isVar := getInstructionDst(instr).(*Variable_Value_Tacky)
+				if isCall || !isVar || !ssa.isSingleAssignment(dst) || (useCounts[dst.name] > 0) {
+					kept = append(kept, instr)
+					continue
+				}
+				for _, val := range getInstructionUses(instr) {
+					useCounts[getTackyValueString(val)]--
+				}
+				removed = true
+			}
+			block.instructions = kept
+		}
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// A phi is trivial when all of its args are the same value, not counting the args that are the phi's own dst (a
+// variable that a loop doesn't change). The dst is replaced by that value everywhere. One phi is removed at a time,
+// since removing one can make another trivial.
+func (ssa *SSA_Function) removeTrivialPhis() {
+	for {
+		var dst *Variable_Value_Tacky
+		var value Value_Tacky
+		for _, block := range ssa.cfg.blocks {
+			for index, instr := range block.instructions {
+				phi, isPhi := instr.(*Phi_Instruction_Tacky)
+				if !isPhi {
+					continue
+				}
+				value = ssa.getTrivialPhiValue(phi)
+				if value != nil {
+					dst = phi.dst.(*Variable_Value_Tacky)
+					block.instructions = append(block.instructions[:index:index], block.instructions[index+1:]...)
+					break
+				}
+			}
+			if dst != nil {
+				break
+			}
+		}
+		if dst == nil {
+			return
+		}
+
+		replace := func(val Value_Tacky) Value_Tacky {
+			if isSameValue(val, dst) {
+				return value
+			}
+			return val
+		}
+		for _, block := range ssa.cfg.blocks {
+			for index, instr := range block.instructions {
+				block.instructions[index] = replaceInstructionUses(instr, replace)
+			}
+		}
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// returns nil if the phi isn't trivial
+func (ssa *SSA_Function) getTrivialPhiValue(phi *Phi_Instruction_Tacky) Value_Tacky {
+	var value Value_Tacky
+	for _, arg := range phi.args {
+		if isSameValue(arg.val, phi.dst) {
+			continue
+		}
+		if (value != nil) && !isSameValue(arg.val, value) {
+			return nil
+		}
+		value = arg.val
+	}
+	if value == nil {
+		return nil
+	}
+	v, isVar := value.(*Variable_Value_Tacky)
+	if isVar && !ssa.isSingleAssignment(v) {
+		return nil
+	}
+	if value.getDataType() != phi.dst.getDataType() {
+		return nil
+	}
+	return value
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// A phi is dead when its dst is only read by other dead phis, like a variable that a loop changes but that is never
+// read after the loop. The live names are found from the instructions that aren't phis.
+func (ssa *SSA_Function) removeDeadPhis() {
+	phis := make(map[string]*Phi_Instruction_Tacky)
+	live := make(map[string]bool)
+	worklist := []string{}
+	markLive := func(val Value_Tacky) {
+		v, isVar := val.(*Variable_Value_Tacky)
+		if isVar && !live[v.name] {
+			live[v.name] = true
+			worklist = append(worklist, v.name)
+		}
+	}
+	for _, block := range ssa.cfg.blocks {
+		for _, instr := range block.instructions {
+			phi, isPhi := instr.(*Phi_Instruction_Tacky)
+			if isPhi {
+				phis[getTackyValueString(phi.dst)] = phi
+				continue
+			}
+			for _, val := range getInstructionUses(instr) {
+				markLive(val)
+			}
+		}
+	}
+
+	for len(worklist) > 0 {
+		name := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+		phi, found := phis[name]
+		if !found {
+			continue
+		}
+		for _, arg := range phi.args {
+			markLive(arg.val)
+		}
+	}
+
+	for _, block := range ssa.cfg.blocks {
+		kept := []Instruction_Tacky{}
+		for _, instr := range block.instructions {
+			phi, isPhi := instr.(*Phi_Instruction_Tacky)
+			if isPhi && !live[getTackyValueString(phi.dst)] {
+				continue
+			}
+			kept = append(kept, instr)
+		}
+		block.instructions = kept
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// the names that are merged into one variable, and the constant args of the phis that were removed by merging them
+type Phi_Group struct {
+	members   []string
+	constants []Phi_Argument
+}
+
+// A phi's dst and its variable args are merged into one variable when none of them are live at the same time, then
+// the phi doesn't do anything and is removed. A constant arg becomes a copy at the end of its predecessor, which is
+// only allowed when nothing in the group is live there. The groups are built greedily one phi at a time, and a phi
+// that can't join its args' groups is left for convertFromSSA. A group keeps the name of its variable that wasn't
+// renamed, like a param, if it has one.
+func (ssa *SSA_Function) coalescePhis() {
+	names := make(map[string]bool)
+	phis := []*Phi_Instruction_Tacky{}
+	for _, block := range ssa.cfg.blocks {
+		for _, instr := range block.instructions {
+			phi, isPhi := instr.(*Phi_Instruction_Tacky)
+			if !isPhi {
+				continue
+			}
+			phis = append(phis, phi)
+			for _, val := range append(getInstructionUses(phi), phi.dst) {
+				v, isVar := val.(*Variable_Value_Tacky)
+				if isVar {
+					names[v.name] = true
+				}
+			}
+		}
+	}
+	if len(phis) == 0 {
+		return
+	}
+
+	liveOut := ssa.findLiveOut(names)
+	interference := ssa.findInterference(names, liveOut)
+	// the copies go right before the jump at the end of the predecessor, so what the jump reads is live there too
+	isLiveAtCopy := func(pred *Basic_Block, name string) bool {
+		if liveOut[pred][name] {
+			return true
+		}
+		if len(pred.instructions) == 0 {
+			return false
+		}
+		for _, val := range getInstructionUses(pred.instructions[len(pred.instructions)-1]) {
+			if getTackyValueString(val) == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	groupOf := make(map[string]*Phi_Group)
+	coalesced := make(map[*Phi_Instruction_Tacky]bool)
+	for _, phi := range phis {
+		groups := []*Phi_Group{}
+		merged := &Phi_Group{}
+		addName := func(name string) {
+			group, found := groupOf[name]
+			if !found {
+				group = &Phi_Group{members: []string{name}}
+				groupOf[name] = group
+			}
+			for _, other := range groups {
+				if other == group {
+					return
+				}
+			}
+			groups = append(groups, group)
+			merged.members = append(merged.members, group.members...)
+			merged.constants = append(merged.constants, group.constants...)
+		}
+		addName(getTackyValueString(phi.dst))
+		for _, arg := range phi.args {
+			v, isVar := arg.val.(*Variable_Value_Tacky)
+			if isVar {
+				addName(v.name)
+			} else {
+				merged.constants = append(merged.constants, arg)
+			}
+		}
+
+		if !ssa.canMergePhiGroups(phi, groups, merged, interference, isLiveAtCopy) {
+			continue
+		}
+		for _, name := range merged.members {
+			groupOf[name] = merged
+		}
+		coalesced[phi] = true
+	}
+
+	newNames := make(map[string]*Variable_Value_Tacky)
+	for _, group := range groupOf {
+		if len(group.members) < 2 {
+			continue
+		}
+		rep := group.members[0]
+		for _, name := range group.members {
+			_, isVersion := ssa.original[name]
+			if !isVersion {
+				rep = name
+			}
+		}
+		for _, name := range group.members {
+			newNames[name] = &Variable_Value_Tacky{rep}
+		}
+	}
+	replace := func(val Value_Tacky) Value_Tacky {
+		v, isVar := val.(*Variable_Value_Tacky)
+		if !isVar {
+			return val
+		}
+		newName, found := newNames[v.name]
+		if !found {
+			return val
+		}
+		return newName
+	}
+
+	// the copies are added after the blocks are done, so the instructions don't move while they are being renamed
+	copies := []Pred_Copy{}
+	for _, block := range ssa.cfg.blocks {
+		kept := []Instruction_Tacky{}
+		for _, instr := range block.instructions {
+			phi, isPhi := instr.(*Phi_Instruction_Tacky)
+			if isPhi && coalesced[phi] {
+				dst := replace(phi.dst)
+				for _, arg := range phi.args {
+					_, isConst := arg.val.(*Constant_Value_Tacky)
+					if isConst {
+						copies = append(copies, Pred_Copy{pred: arg.pred, instr: &Copy_Instruction_Tacky{src: arg.val, dst: dst}})
+					}
+				}
+				continue
+			}
+			instr = replaceInstructionUses(instr, replace)
+			dst, isVar := getInstructionDst(instr).(*Variable_Value_Tacky)
+			if isVar && (newNames[dst.name] != nil) {
+				instr = replaceInstructionDst(instr, newNames[dst.name])
+			}
+			kept = append(kept, instr)
+		}
+		block.instructions = kept
+	}
+	for _, predCopy := range copies {
+		ssa.cfg.insertAtEnd(predCopy.pred, predCopy.instr)
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// The names can be merged if they are all SSA names of the same type, none of them are live at the same time, and at
+// most one of them wasn't renamed. Only the pairs from different groups need to be checked, each group was already
+// checked when it was made.
+func (ssa *SSA_Function) canMergePhiGroups(phi *Phi_Instruction_Tacky, groups []*Phi_Group, merged *Phi_Group,
+	interference map[string]map[string]bool, isLiveAtCopy func(pred *Basic_Block, name string) bool) bool {
+
+	typ := phi.dst.getDataType()
+	notRenamed := 0
+	for _, name := range merged.members {
+		v := &Variable_Value_Tacky{name}
+		if !ssa.isSingleAssignment(v) || (v.getDataType() != typ) {
+			return false
+		}
+		_, isVersion := ssa.original[name]
+		if !isVersion {
+			notRenamed++
+		}
+	}
+	if notRenamed > 1 {
+		return false
+	}
+
+	for index, group := range groups {
+		for _, other := range groups[index+1:] {
+			for _, name := range group.members {
+				for _, otherName := range other.members {
+					if interference[name][otherName] {
+						return false
+					}
+				}
+			}
+		}
+	}
+
+	// two constant copies at the end of the same predecessor would overwrite each other
+	preds := make(map[*Basic_Block]bool)
+	for _, arg := range merged.constants {
+		if preds[arg.pred] {
+			return false
+		}
+		preds[arg.pred] = true
+		for _, name := range merged.members {
+			if isLiveAtCopy(arg.pred, name) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// Which of the names are live at the end of each block, repeated until nothing changes. A phi's args are read at the
+// end of their predecessors instead of in the phi's block, and its dst is written at the top of its block. The
+// instructions before a block's label only run when the block before falls through, but they are treated like the
+// rest of the block, which can only make more names live.
+func (ssa *SSA_Function) findLiveOut(names map[string]bool) map[*Basic_Block]map[string]bool {
+	blocks := append([]*Basic_Block{ssa.cfg.entry}, ssa.cfg.blocks...)
+	liveIn := map[*Basic_Block]map[string]bool{ssa.cfg.exit: {}}
+	liveOut := make(map[*Basic_Block]map[string]bool)
+	for _, block := range blocks {
+		liveIn[block] = make(map[string]bool)
+		liveOut[block] = make(map[string]bool)
+	}
+
+	changed := true
+	for changed {
+		changed = false
+		for index := len(blocks) - 1; index >= 0; index-- {
+			block := blocks[index]
+			out := liveOut[block]
+			for _, succ := range block.succs {
+				for name, _ := range liveIn[succ] {
+					out[name] = true
+				}
+				for _, instr := range succ.instructions {
+					phi, isPhi := instr.(*Phi_Instruction_Tacky)
+					if !isPhi {
+						continue
+					}
+					for _, arg := range phi.args {
+						v, isVar := arg.val.(*Variable_Value_Tacky)
+						if isVar && (arg.pred == block) && names[v.name] {
+							out[v.name] = true
+						}
+					}
+				}
+			}
+
+			// the sets only get bigger, so they changed if their size did
+			live := make(map[string]bool)
+			for name, _ := range out {
+				live[name] = true
+			}
+			walkLiveNames(block, live, names, nil)
+			if len(live) != len(liveIn[block]) {
+				changed = true
+			}
+			liveIn[block] = live
+		}
+	}
+	return liveOut
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// Two names interfere if one is live where the other is written. The names that are live at the end of the entry
+// block all have their values when the function starts, like the params, so they all interfere with each other.
+func (ssa *SSA_Function) findInterference(names map[string]bool, liveOut map[*Basic_Block]map[string]bool) map[string]map[string]bool {
+	interference := make(map[string]map[string]bool)
+	addInterference := func(name string, other string) {
+		if name == other {
+			return
+		}
+		if interference[name] == nil {
+			interference[name] = make(map[string]bool)
+		}
+		if interference[other] == nil {
+			interference[other] = make(map[string]bool)
+		}
+		interference[name][other] = true
+		interference[other][name] = true
+	}
+
+	for _, block := range ssa.cfg.blocks {
+		live := make(map[string]bool)
+		for name, _ := range liveOut[block] {
+			live[name] = true
+		}
+		walkLiveNames(block, live, names, func(name string) {
+			for other, _ := range live {
+				addInterference(name, other)
+			}
+		})
+	}
+	for name, _ := range liveOut[ssa.cfg.entry] {
+		for other, _ := range liveOut[ssa.cfg.entry] {
+			addInterference(name, other)
+		}
+	}
+	return interference
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// Goes backward through the block starting from the names that are live at its end, and leaves the names that are
+// live at its start. onWrite is called with each name that is written, while live still has what is live after it.
+func walkLiveNames(block *Basic_Block, live map[string]bool, names map[string]bool, onWrite func(name string)) {
+	for index := len(block.instructions) - 1; index >= 0; index-- {
+		instr := block.instructions[index]
+		dst, isVar := getInstructionDst(instr).(*Variable_Value_Tacky)
+		if isVar && names[dst.name] {
+			if onWrite != nil {
+				onWrite(dst.name)
+			}
+			delete(live, dst.name)
+		}
+		_, isPhi := instr.(*Phi_Instruction_Tacky)
+		if isPhi {
+			continue
+		}
+		for _, val := range getInstructionUses(instr) {
+			v, isVar := val.(*Variable_Value_Tacky)
+			if isVar && names[v.name] {
+				live[v.name] = true
+			}
+		}
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// the entry block doesn't have any instructions, so anything that goes at its end goes before the first block's label
+func (cfg *Control_Flow_Graph) insertAtEnd(block *Basic_Block, instr Instruction_Tacky) {
+	if block == cfg.entry {
+		cfg.blocks[0].insertBeforeLabel(instr)
+		return
+	}
+	block.insertBeforeJump(instr)
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// Instructions before a block's label only run when the block before it falls through to it, not when something
+// jumps to the label. They stay in the order they were added.
+func (block *Basic_Block) insertBeforeLabel(instr Instruction_Tacky) {
+	position := 0
+	for index, current := range block.instructions {
+		_, isLabel := current.(*Label_Instruction_Tacky)
+		if isLabel {
+			position = index
+			break
+		}
+	}
+	instructions := append([]Instruction_Tacky{}, block.instructions[:position]...)
+	instructions = append(instructions, instr)
+	block.instructions = append(instructions, block.instructions[position:]...)
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// the phis go at the start of the block, but after its label
+func (block *Basic_Block) insertAfterLabel(instr Instruction_Tacky) {
+	position := 0
+	if len(block.instructions) > 0 {
+		_, isLabel := block.instructions[0].(*Label_Instruction_Tacky)
+		if isLabel {
+			position = 1
+		}
+	}
+	instructions := append([]Instruction_Tacky{}, block.instructions[:position]...)
+	instructions = append(instructions, instr)
+	block.instructions = append(instructions, block.instructions[position:]...)
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// a block that ends with a jump has to do the instruction before jumping, otherwise it goes at the end
+func (block *Basic_Block) insertBeforeJump(instr Instruction_Tacky) {
+	last := len(block.instructions)
+	if last > 0 {
+		switch block.instructions[last-1].(type) {
+		case *Jump_Instruction_Tacky, *Jump_If_Zero_Instruction_Tacky, *Jump_If_Not_Zero_Instruction_Tacky,
+			*Jump_If_Compare_Instruction_Tacky:
+			last--
+		}
+	}
+	instructions := append([]Instruction_Tacky{}, block.instructions[:last]...)
+	instructions = append(instructions, instr)
+	block.instructions = append(instructions, block.instructions[last:]...)
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// "A Simple, Fast Dominance Algorithm" by Cooper, Harvey and Kennedy. Each block's immediate dominator is found by
+// walking up from its predecessors until the paths meet, in reverse postorder until nothing changes.
+func findImmediateDominators(cfg *Control_Flow_Graph) map[*Basic_Block]*Basic_Block {
+	order := cfg.getReversePostorder()
+	position := make(map[*Basic_Block]int)
+	for index, block := range order {
+		position[block] = index
+	}
+
+	idom := make(map[*Basic_Block]*Basic_Block)
+	idom[cfg.entry] = cfg.entry
+	intersect := func(a *Basic_Block, b *Basic_Block) *Basic_Block {
+		for a != b {
+			for position[a] > position[b] {
+				a = idom[a]
+			}
+			for position[b] > position[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+
+	changed := true
+	for changed {
+		changed = false
+		for _, block := range order[1:] {
+			var newIdom *Basic_Block
+			for _, pred := range block.preds {
+				_, processed := idom[pred]
+				if !processed {
+					continue
+				}
+				if newIdom == nil {
+					newIdom = pred
+				} else {
+					newIdom = intersect(pred, newIdom)
+				}
+			}
+			if idom[block] != newIdom {
+				idom[block] = newIdom
+				changed = true
+			}
+		}
+	}
+
+	delete(idom, cfg.entry)
+	return idom
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// only includes the blocks that can be reached from the entry
+func (cfg *Control_Flow_Graph) getReversePostorder() []*Basic_Block {
+	visited := make(map[*Basic_Block]bool)
+	postorder := []*Basic_Block{}
+
+	var visit func(block *Basic_Block)
+	visit = func(block *Basic_Block) {
+		visited[block] = true
+		for _, succ := range block.succs {
+			if !visited[succ] {
+				visit(succ)
+			}
+		}
+		postorder = append(postorder, block)
+	}
+	visit(cfg.entry)
+
+	order := []*Basic_Block{}
+	for index := len(postorder) - 1; index >= 0; index-- {
+		order = append(order, postorder[index])
+	}
+	return order
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// The dominance frontier of a block is where its dominance stops, the blocks it doesn't strictly dominate that have
+// a predecessor it does dominate. Only a block with more than one predecessor can be in a frontier.
+func findDominanceFrontiers(cfg *Control_Flow_Graph, idom map[*Basic_Block]*Basic_Block) map[*Basic_Block][]*Basic_Block {
+	frontiers := make(map[*Basic_Block][]*Basic_Block)
+	blocks := append([]*Basic_Block{cfg.exit}, cfg.blocks...)
+	for _, block := range blocks {
+		if len(block.preds) < 2 {
+			continue
+		}
+		for _, pred := range block.preds {
+			runner := pred
+			for (runner != nil) && (runner != idom[block]) {
+				if !containsBlock(frontiers[runner], block) {
+					frontiers[runner] = append(frontiers[runner], block)
+				}
+				runner = idom[runner]
+			}
+		}
+	}
+	return frontiers
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// returns true if the block is dominated by dominator, every block dominates itself
+func (ssa *SSA_Function) dominates(dominator *Basic_Block, block *Basic_Block) bool {
+	for block != nil {
+		if block == dominator {
+			return true
+		}
+		block = ssa.idom[block]
+	}
+	return false
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// returns true if nothing writes to the variable after it gets its value, which is true for the new names and for
+// the renamed variables that are read before they are written, like params
+func (ssa *SSA_Function) isSingleAssignment(v *Variable_Value_Tacky) bool {
+	return ssa.renamed[v.name] || ssa.renamed[ssa.original[v.name]]
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func containsBlock(blocks []*Basic_Block, block *Basic_Block) bool {
+	for _, b := range blocks {
+		if b == block {
+			return true
+		}
+	}
+	return false
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// returns a new instruction that writes to dst instead, the instruction must have a dst
+func replaceInstructionDst(instr Instruction_Tacky, dst Value_Tacky) Instruction_Tacky {
+	switch convertedInstr := instr.(type) {
+	case *Sign_Extend_Instruction_Tacky:
+		return &Sign_Extend_Instruction_Tacky{src: convertedInstr.src, dst: dst}
+	case *Truncate_Instruction_Tacky:
+		return &Truncate_Instruction_Tacky{src: convertedInstr.src, dst: dst}
+	case *Zero_Extend_Instruction_Tacky:
+		return &Zero_Extend_Instruction_Tacky{src: convertedInstr.src, dst: dst}
+	case *Double_To_Int_Instruction_Tacky:
+		return &Double_To_Int_Instruction_Tacky{src: convertedInstr.src, dst: dst}
+	case *Double_To_UInt_Instruction_Tacky:
+		return &Double_To_UInt_Instruction_Tacky{src: convertedInstr.src, dst: dst}
+	case *Int_To_Double_Instruction_Tacky:
+		return &Int_To_Double_Instruction_Tacky{src: convertedInstr.src, dst: dst}
+	case *UInt_To_Double_Instruction_Tacky:
+		return &UInt_To_Double_Instruction_Tacky{src: convertedInstr.src, dst: dst}
+	case *Unary_Instruction_Tacky:
+		return &Unary_Instruction_Tacky{unOp: convertedInstr.unOp, src: convertedInstr.src, dst: dst}
+	case *Binary_Instruction_Tacky:
+		return &Binary_Instruction_Tacky{binOp: convertedInstr.binOp, src1: convertedInstr.src1, src2: convertedInstr.src2, dst: dst}
+	case *Copy_Instruction_Tacky:
+		return &Copy_Instruction_Tacky{src: convertedInstr.src, dst: dst}
+	case *Conditional_Copy_Instruction_Tacky:
+		return &Conditional_Copy_Instruction_Tacky{binOp: convertedInstr.binOp, left: convertedInstr.left, right: convertedInstr.right,
+			src1: convertedInstr.src1, src2: convertedInstr.src2, dst: dst}
+	case *Get_Address_Instruction_Tacky:
+		return &Get_Address_Instruction_Tacky{src: convertedInstr.src, dst: dst}
+	case *Load_Instruction_Tacky:
+		return &Load_Instruction_Tacky{srcPtr: convertedInstr.srcPtr, dst: dst}
+	case *Function_Call_Tacky:
+		return &Function_Call_Tacky{funcName: convertedInstr.funcName, args: convertedInstr.args, returnVal: dst}
+	case *Phi_Instruction_Tacky:
+		return &Phi_Instruction_Tacky{dst: dst, args: convertedInstr.args}
+	}
+	fail("tacky instruction doesn't have a dst")
+	return nil
+}
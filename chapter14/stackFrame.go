@@ -0,0 +1,65 @@
+package main
+
+import "strconv"
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// the System V ABI promises that signal handlers won't touch this many bytes below the stack pointer
+const RED_ZONE_SIZE = 128
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// A leaf function doesn't call anything, so nothing pushes a return address over the memory below the stack pointer.
+// A tail call is fine because our frame is already gone when it jumps.
+func (fn *Function_Asm) isLeaf() bool {
+	for _, instr := range fn.instructions {
+		_, isCall := instr.(*Call_Function_Asm)
+		if isCall {
+			return false
+		}
+	}
+	return true
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// Without a frame pointer the locals and the stack parameters are found from RSP instead of RBP. They were given
+// offsets from RBP as if it had been pushed, so the locals are below where RBP would be and the parameters start at
+// 16(%rbp). localsBase and entryOffset are how far above the stack pointer (after the prologue) the locals start and
+// the return address is. The stack pointer moves while the args for a call are pushed, so that is tracked too.
+func (fn *Function_Asm) addressFromStackPointer(localsBase int32, entryOffset int32) {
+	pushed := int32(0)
+	for _, instr := range fn.instructions {
+		replace := func(op Operand_Asm) Operand_Asm {
+			mem, isMem := op.(*Memory_Operand_Asm)
+			if !isMem || (mem.reg != BP_REGISTER_ASM) {
+				return op
+			}
+			if mem.offset < 0 {
+				return &Memory_Operand_Asm{reg: SP_REGISTER_ASM, offset: mem.offset + localsBase + pushed}
+			}
+			// the pushed RBP isn't there, so the parameters are 8 bytes closer
+			return &Memory_Operand_Asm{reg: SP_REGISTER_ASM, offset: mem.offset - 8 + entryOffset + pushed}
+		}
+		replaceInstructionOperands(instr, replace)
+
+		switch convertedInstr := instr.(type) {
+		case *Push_Instruction_Asm:
+			pushed += 8
+		case *Binary_Instruction_Asm:
+			// the padding before a call and removing the args after it
+			reg, isReg := convertedInstr.dst.(*Register_Operand_Asm)
+			imm, isImm := convertedInstr.src.(*Immediate_Int_Operand_Asm)
+			if isReg && (reg.reg == SP_REGISTER_ASM) && isImm {
+				amount, _ := strconv.ParseInt(imm.value, 10, 32)
+				if convertedInstr.binOp == SUB_OPERATOR_ASM {
+					pushed += int32(amount)
+				} else if convertedInstr.binOp == ADD_OPERATOR_ASM {
+					pushed -= int32(amount)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import "sort"
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+type Stack_Slot struct {
+	size    int32
+	members []string
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// Gives the pseudoregisters that didn't get a register a place on the stack, where pseudoregisters that are never live
+// at the same time share a slot. The offsets are added to nameToOffset. A pseudoregister that has its address taken
+// keeps a slot to itself because a pointer could still read it after it looks dead.
+func (fn *Function_Asm) shareStackSlots(nameToOffset map[string]int32) {
+	aliased := findAliasedPseudoregisters(fn.instructions)
+	isCandidate := func(name string) bool {
+		if (len(name) == 0) || (name[0] == '%') || aliased[name] {
+			return false
+		}
+		return !symbolTableBackend[name].isStatic
+	}
+
+	// the order they are first seen in, so the layout is the same every time the compiler runs
+	order := []string{}
+	interferes := make(map[string]map[string]bool)
+	addCandidate := func(name string) {
+		_, exists := interferes[name]
+		if isCandidate(name) && !exists {
+			interferes[name] = make(map[string]bool)
+			order = append(order, name)
+		}
+	}
+	for _, instr := range fn.instructions {
+		uses, defs := getAsmUsesAndDefs(instr, fn.name)
+		for _, name := range append(uses, defs...) {
+			addCandidate(name)
+		}
+	}
+
+	// same as the interference graph for registers, a def interferes with everything that is live right after it
+	cfg := makeControlFlowGraphAsm(fn.instructions)
+	blockOut := findLiveRegisters(cfg, fn.name)
+	for _, block := range cfg.blocks {
+		live := copyLiveRegisters(blockOut[block])
+		for index := len(block.instructions) - 1; index >= 0; index-- {
+			uses, defs := getAsmUsesAndDefs(block.instructions[index], fn.name)
+			for _, def := range defs {
+				if !isCandidate(def) {
+					continue
+				}
+				for liveName, _ := range live {
+					if (liveName != def) && isCandidate(liveName) {
+						interferes[def][liveName] = true
+						interferes[liveName][def] = true
+					}
+				}
+			}
+			transferLiveRegisters(live, uses, defs)
+		}
+	}
+
+	// put each pseudoregister in the first slot that doesn't hold anything it interferes with
+	slots := []*Stack_Slot{}
+	for _, name := range order {
+		size := asmTypToAlignment(symbolTableBackend[name].asmTyp)
+		var chosen *Stack_Slot
+		for _, slot := range slots {
+			canShare := true
+			for _, member := range slot.members {
+				if interferes[name][member] {
+					canShare = false
+					break
+				}
+			}
+			if canShare {
+				chosen = slot
+				break
+			}
+		}
+		if chosen == nil {
+			chosen = &Stack_Slot{}
+			slots = append(slots, chosen)
+		}
+		chosen.members = append(chosen.members, name)
+		chosen.size = max(chosen.size, size)
+	}
+
+	// the biggest slots go first so every slot is aligned to its size without any padding in between
+	sort.SliceStable(slots, func(i, j int) bool {
+		return slots[i].size > slots[j].size
+	})
+	for _, slot := range slots {
+		fn.stackSize -= slot.size
+		if (fn.stackSize % slot.size) != 0 {
+			fn.stackSize = (fn.stackSize/slot.size)*slot.size - slot.size
+		}
+		for _, member := range slot.members {
+			nameToOffset[member] = fn.stackSize
+		}
+	}
+}
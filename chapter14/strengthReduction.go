@@ -0,0 +1,351 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"strconv"
+)
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// Returns the cheaper instructions for a multiplication, division or remainder by a constant, or nil if the normal
+// imul, idiv or div has to be used. The magic number division is from chapter 10 of Hacker's Delight.
+func (instr *Binary_Instruction_Tacky) strengthReduceToAsm() []Instruction_Asm {
+	asmTyp := instr.src1.getAssemblyType()
+	if (asmTyp != LONGWORD_ASM_TYPE) && (asmTyp != QUADWORD_ASM_TYPE) {
+		return nil
+	}
+
+	switch instr.binOp {
+	case MULTIPLY_OPERATOR:
+		constant, isConst := instr.src2.(*Constant_Value_Tacky)
+		if isConst {
+			return strengthReduceMultiply(instr.src1, constant, instr.dst, asmTyp)
+		}
+		constant, isConst = instr.src1.(*Constant_Value_Tacky)
+		if isConst {
+			return strengthReduceMultiply(instr.src2, constant, instr.dst, asmTyp)
+		}
+	case DIVIDE_OPERATOR, REMAINDER_OPERATOR:
+		constant, isConst := instr.src2.(*Constant_Value_Tacky)
+		if !isConst || (getIntegerBits(constant) == 0) {
+			// division by zero is left for the program to crash on
+			return nil
+		}
+		isRemainder := instr.binOp == REMAINDER_OPERATOR
+		if instr.src1.isSigned() {
+			return strengthReduceSignedDivide(instr.src1, int64(getIntegerBits(constant)), instr.dst, asmTyp, isRemainder)
+		}
+		return strengthReduceUnsignedDivide(instr.src1, getIntegerBits(constant), instr.dst, asmTyp, isRemainder)
+	}
+	return nil
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// x * 2^k => shl, x * (2^k + 1) => shl and add, which the peephole pass turns into lea
+func strengthReduceMultiply(val Value_Tacky, constant *Constant_Value_Tacky, dstVal Value_Tacky, asmTyp AssemblyTypeEnum) []Instruction_Asm {
+	src := val.valueToAsm()
+	dst := dstVal.valueToAsm()
+	multiplier := getIntegerBits(constant)
+	negate := false
+	if isSigned(constant.typ) && (int64(multiplier) < 0) {
+		multiplier = -multiplier
+		negate = true
+	}
+
+	instructions := []Instruction_Asm{}
+	switch {
+	case multiplier == 0:
+		instructions = append(instructions, &Mov_Instruction_Asm{asmTyp: asmTyp, src: &Immediate_Int_Operand_Asm{"0"}, dst: dst})
+		return instructions
+	case isPowerOfTwo(multiplier):
+		instructions = append(instructions, &Mov_Instruction_Asm{asmTyp: asmTyp, src: src, dst: dst})
+		if multiplier > 1 {
+			instructions = append(instructions, makeShiftAsm(SHIFT_LEFT_OPERATOR_ASM, asmTyp, bits.TrailingZeros64(multiplier), dst))
+		}
+	case !negate && ((multiplier == 3) || (multiplier == 5) || (multiplier == 9)) && !isSameValue(val, dstVal):
+		// src is read again after dst is written, so they can't be the same
+		instructions = append(instructions, &Mov_Instruction_Asm{asmTyp: asmTyp, src: src, dst: dst})
+		instructions = append(instructions, makeShiftAsm(SHIFT_LEFT_OPERATOR_ASM, asmTyp, bits.TrailingZeros64(multiplier-1), dst))
+		instructions = append(instructions, &Binary_Instruction_Asm{binOp: ADD_OPERATOR_ASM, asmTyp: asmTyp, src: src, dst: dst})
+		return instructions
+	default:
+		return nil
+	}
+
+	if negate {
+		instructions = append(instructions, &Unary_Instruction_Asm{unOp: NEGATE_OPERATOR_ASM, asmTyp: asmTyp, src: dst})
+	}
+	return instructions
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func strengthReduceSignedDivide(val Value_Tacky, divisor int64, dstVal Value_Tacky, asmTyp AssemblyTypeEnum, isRemainder bool) []Instruction_Asm {
+	size := getAsmTypeBitCount(asmTyp)
+	x := val.valueToAsm()
+	dst := dstVal.valueToAsm()
+	ax := &Register_Operand_Asm{AX_REGISTER_ASM}
+	dx := &Register_Operand_Asm{DX_REGISTER_ASM}
+
+	absDivisor := uint64(divisor)
+	if divisor < 0 {
+		absDivisor = -absDivisor
+	}
+	absDivisor &= getAsmTypeMask(asmTyp)
+
+	instructions := []Instruction_Asm{}
+	if absDivisor == 1 {
+		if isRemainder {
+			instructions = append(instructions, &Mov_Instruction_Asm{asmTyp: asmTyp, src: &Immediate_Int_Operand_Asm{"0"}, dst: dst})
+			return instructions
+		}
+		instructions = append(instructions, &Mov_Instruction_Asm{asmTyp: asmTyp, src: x, dst: dst})
+		if divisor < 0 {
+			instructions = append(instructions, &Unary_Instruction_Asm{unOp: NEGATE_OPERATOR_ASM, asmTyp: asmTyp, src: dst})
+		}
+		return instructions
+	}
+
+	if isPowerOfTwo(absDivisor) {
+		// a negative x needs 2^k - 1 added first, so the shift rounds toward zero instead of toward negative infinity
+		k := bits.TrailingZeros64(absDivisor)
+		instructions = append(instructions, &Mov_Instruction_Asm{asmTyp: asmTyp, src: x, dst: ax})
+		instructions = append(instructions, makeShiftAsm(SHIFT_RIGHT_ARITHMETIC_OPERATOR_ASM, asmTyp, size-1, ax))
+		instructions = append(instructions, makeShiftAsm(SHIFT_RIGHT_LOGICAL_OPERATOR_ASM, asmTyp, size-k, ax))
+		instructions = append(instructions, &Binary_Instruction_Asm{binOp: ADD_OPERATOR_ASM, asmTyp: asmTyp, src: x, dst: ax})
+		if isRemainder {
+			// x - (x rounded toward zero to a multiple of 2^k)
+			instructions = append(instructions, &Binary_Instruction_Asm{binOp: AND_OPERATOR_ASM, asmTyp: asmTyp,
+				src: makeImmediateAsm(-absDivisor, asmTyp), dst: ax})
+			instructions = append(instructions, &Mov_Instruction_Asm{asmTyp: asmTyp, src: x, dst: dx})
+			instructions = append(instructions, &Binary_Instruction_Asm{binOp: SUB_OPERATOR_ASM, asmTyp: asmTyp, src: ax, dst: dx})
+			instructions = append(instructions, &Mov_Instruction_Asm{asmTyp: asmTyp, src: dx, dst: dst})
+			return instructions
+		}
+		instructions = append(instructions, makeShiftAsm(SHIFT_RIGHT_ARITHMETIC_OPERATOR_ASM, asmTyp, k, ax))
+		if divisor < 0 {
+			instructions = append(instructions, &Unary_Instruction_Asm{unOp: NEGATE_OPERATOR_ASM, asmTyp: asmTyp, src: ax})
+		}
+		instructions = append(instructions, &Mov_Instruction_Asm{asmTyp: asmTyp, src: ax, dst: dst})
+		return instructions
+	}
+
+	magic, shift := getSignedMagicNumber(divisor, absDivisor, size)
+	magicIsNegative := (magic>>(size-1))&1 == 1
+	instructions = append(instructions, &Mov_Instruction_Asm{asmTyp: asmTyp, src: x, dst: ax})
+	instructions = append(instructions, &Mov_Instruction_Asm{asmTyp: asmTyp, src: makeImmediateAsm(magic, asmTyp), dst: dx})
+	instructions = append(instructions, &Multiply_High_Instruction_Asm{signed: true, asmTyp: asmTyp, src: dx})
+	if (divisor > 0) && magicIsNegative {
+		instructions = append(instructions, &Binary_Instruction_Asm{binOp: ADD_OPERATOR_ASM, asmTyp: asmTyp, src: x, dst: dx})
+	} else if (divisor < 0) && !magicIsNegative {
+		instructions = append(instructions, &Binary_Instruction_Asm{binOp: SUB_OPERATOR_ASM, asmTyp: asmTyp, src: x, dst: dx})
+	}
+	if shift > 0 {
+		instructions = append(instructions, makeShiftAsm(SHIFT_RIGHT_ARITHMETIC_OPERATOR_ASM, asmTyp, shift, dx))
+	}
+	// add 1 when the quotient is negative, so it rounds toward zero
+	instructions = append(instructions, &Mov_Instruction_Asm{asmTyp: asmTyp, src: dx, dst: ax})
+	instructions = append(instructions, makeShiftAsm(SHIFT_RIGHT_LOGICAL_OPERATOR_ASM, asmTyp, size-1, ax))
+	instructions = append(instructions, &Binary_Instruction_Asm{binOp: ADD_OPERATOR_ASM, asmTyp: asmTyp, src: ax, dst: dx})
+
+	return append(instructions, makeQuotientResultAsm(x, uint64(divisor), dst, asmTyp, isRemainder)...)
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func strengthReduceUnsignedDivide(val Value_Tacky, divisor uint64, dstVal Value_Tacky, asmTyp AssemblyTypeEnum, isRemainder bool) []Instruction_Asm {
+	size := getAsmTypeBitCount(asmTyp)
+	x := val.valueToAsm()
+	dst := dstVal.valueToAsm()
+	ax := &Register_Operand_Asm{AX_REGISTER_ASM}
+	dx := &Register_Operand_Asm{DX_REGISTER_ASM}
+
+	instructions := []Instruction_Asm{}
+	if isPowerOfTwo(divisor) {
+		if isRemainder {
+			if divisor == 1 {
+				instructions = append(instructions, &Mov_Instruction_Asm{asmTyp: asmTyp, src: &Immediate_Int_Operand_Asm{"0"}, dst: dst})
+				return instructions
+			}
+			instructions = append(instructions, &Mov_Instruction_Asm{asmTyp: asmTyp, src: x, dst: dst})
+			instructions = append(instructions, &Binary_Instruction_Asm{binOp: AND_OPERATOR_ASM, asmTyp: asmTyp,
+				src: makeImmediateAsm(divisor-1, asmTyp), dst: dst})
+			return instructions
+		}
+		instructions = append(instructions, &Mov_Instruction_Asm{asmTyp: asmTyp, src: x, dst: dst})
+		if divisor > 1 {
+			instructions = append(instructions, makeShiftAsm(SHIFT_RIGHT_LOGICAL_OPERATOR_ASM, asmTyp, bits.TrailingZeros64(divisor), dst))
+		}
+		return instructions
+	}
+
+	magic, shift, needsAdd := getUnsignedMagicNumber(divisor, size)
+	instructions = append(instructions, &Mov_Instruction_Asm{asmTyp: asmTyp, src: x, dst: ax})
+	instructions = append(instructions, &Mov_Instruction_Asm{asmTyp: asmTyp, src: makeImmediateAsm(magic, asmTyp), dst: dx})
+	instructions = append(instructions, &Multiply_High_Instruction_Asm{signed: false, asmTyp: asmTyp, src: dx})
+	if needsAdd {
+		// the magic number needs one more bit than the register has, so the extra x is added without overflowing:
+		// ((x - high) / 2 + high) >> (shift - 1)
+		instructions = append(instructions, &Mov_Instruction_Asm{asmTyp: asmTyp, src: x, dst: ax})
+		instructions = append(instructions, &Binary_Instruction_Asm{binOp: SUB_OPERATOR_ASM, asmTyp: asmTyp, src: dx, dst: ax})
+		instructions = append(instructions, makeShiftAsm(SHIFT_RIGHT_LOGICAL_OPERATOR_ASM, asmTyp, 1, ax))
+		instructions = append(instructions, &Binary_Instruction_Asm{binOp: ADD_OPERATOR_ASM, asmTyp: asmTyp, src: ax, dst: dx})
+		shift--
+	}
+	if shift > 0 {
+		instructions = append(instructions, makeShiftAsm(SHIFT_RIGHT_LOGICAL_OPERATOR_ASM, asmTyp, shift, dx))
+	}
+
+	return append(instructions, makeQuotientResultAsm(x, divisor, dst, asmTyp, isRemainder)...)
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// the quotient is in DX, the remainder is x - quotient * divisor
+func makeQuotientResultAsm(x Operand_Asm, divisor uint64, dst Operand_Asm, asmTyp AssemblyTypeEnum, isRemainder bool) []Instruction_Asm {
+	ax := &Register_Operand_Asm{AX_REGISTER_ASM}
+	dx := &Register_Operand_Asm{DX_REGISTER_ASM}
+	if !isRemainder {
+		return []Instruction_Asm{&Mov_Instruction_Asm{asmTyp: asmTyp, src: dx, dst: dst}}
+	}
+	mult := Binary_Instruction_Asm{binOp: MULT_OPERATOR_ASM, asmTyp: asmTyp, src: makeImmediateAsm(divisor, asmTyp), dst: dx}
+	mov1 := Mov_Instruction_Asm{asmTyp: asmTyp, src: x, dst: ax}
+	sub := Binary_Instruction_Asm{binOp: SUB_OPERATOR_ASM, asmTyp: asmTyp, src: dx, dst: ax}
+	mov2 := Mov_Instruction_Asm{asmTyp: asmTyp, src: ax, dst: dst}
+	return []Instruction_Asm{&mult, &mov1, &sub, &mov2}
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// Finds the magic number and shift for signed division by a divisor that isn't 0, 1, -1 or a power of two. All of the
+// math is done with size bits, the result is the high half of x * magic shifted right.
+func getSignedMagicNumber(divisor int64, absDivisor uint64, size int) (uint64, int) {
+	mask := uint64(math.MaxUint64) >> (64 - size)
+	twoToSizeMinus1 := uint64(1) << (size - 1)
+	t := twoToSizeMinus1
+	if divisor < 0 {
+		t++
+	}
+	absNc := t - 1 - t%absDivisor
+
+	p := size - 1
+	q1 := twoToSizeMinus1 / absNc
+	r1 := twoToSizeMinus1 - q1*absNc
+	q2 := twoToSizeMinus1 / absDivisor
+	r2 := twoToSizeMinus1 - q2*absDivisor
+	for {
+		p++
+		q1 = (2 * q1) & mask
+		r1 = (2 * r1) & mask
+		if r1 >= absNc {
+			q1++
+			r1 -= absNc
+		}
+		q2 = (2 * q2) & mask
+		r2 = (2 * r2) & mask
+		if r2 >= absDivisor {
+			q2++
+			r2 -= absDivisor
+		}
+		delta := absDivisor - r2
+		if (q1 > delta) || ((q1 == delta) && (r1 != 0)) {
+			break
+		}
+	}
+
+	magic := (q2 + 1) & mask
+	if divisor < 0 {
+		magic = (-magic) & mask
+	}
+	return magic, p - size
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// Same as getSignedMagicNumber for unsigned division by a divisor that isn't a power of two. When the magic number
+// doesn't fit in size bits the last return value is true, and x has to be added to the high half.
+func getUnsignedMagicNumber(divisor uint64, size int) (uint64, int, bool) {
+	mask := uint64(math.MaxUint64) >> (64 - size)
+	twoToSizeMinus1 := uint64(1) << (size - 1)
+	nc := mask - ((-divisor)&mask)%divisor
+
+	p := size - 1
+	q1 := twoToSizeMinus1 / nc
+	r1 := twoToSizeMinus1 - q1*nc
+	q2 := (twoToSizeMinus1 - 1) / divisor
+	r2 := (twoToSizeMinus1 - 1) - q2*divisor
+	needsAdd := false
+	for {
+		p++
+		if r1 >= nc-r1 {
+			q1 = (2*q1 + 1) & mask
+			r1 = (2*r1 - nc) & mask
+		} else {
+			q1 = (2 * q1) & mask
+			r1 = (2 * r1) & mask
+		}
+		if r2+1 >= divisor-r2 {
+			if q2 >= twoToSizeMinus1-1 {
+				needsAdd = true
+			}
+			q2 = (2*q2 + 1) & mask
+			r2 = (2*r2 + 1 - divisor) & mask
+		} else {
+			if q2 >= twoToSizeMinus1 {
+				needsAdd = true
+			}
+			q2 = (2 * q2) & mask
+			r2 = (2*r2 + 1) & mask
+		}
+		delta := divisor - 1 - r2
+		if (p >= 2*size) || (q1 > delta) || ((q1 == delta) && (r1 != 0)) {
+			break
+		}
+	}
+	return (q2 + 1) & mask, p - size, needsAdd
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+func isPowerOfTwo(value uint64) bool {
+	return (value != 0) && ((value & (value - 1)) == 0)
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func getAsmTypeBitCount(asmTyp AssemblyTypeEnum) int {
+	if asmTyp == QUADWORD_ASM_TYPE {
+		return 64
+	}
+	return 32
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func getAsmTypeMask(asmTyp AssemblyTypeEnum) uint64 {
+	return uint64(math.MaxUint64) >> (64 - getAsmTypeBitCount(asmTyp))
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// immediates are written as the signed value of the operand's size, so that opIsBigImm can parse them
+func makeImmediateAsm(value uint64, asmTyp AssemblyTypeEnum) *Immediate_Int_Operand_Asm {
+	if asmTyp == QUADWORD_ASM_TYPE {
+		return &Immediate_Int_Operand_Asm{strconv.FormatInt(int64(value), 10)}
+	}
+	return &Immediate_Int_Operand_Asm{strconv.FormatInt(int64(int32(value)), 10)}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func makeShiftAsm(binOp BinaryOperatorTypeAsm, asmTyp AssemblyTypeEnum, count int, dst Operand_Asm) *Binary_Instruction_Asm {
+	return &Binary_Instruction_Asm{binOp: binOp, asmTyp: asmTyp, src: &Immediate_Int_Operand_Asm{strconv.Itoa(count)}, dst: dst}
+}
@@ -47,6 +47,8 @@ type Function_Definition_Tacky struct {
 	global     bool
 	paramNames []string
 	body       []Instruction_Tacky
+	// at least one declaration of the function used the inline keyword
+	isInline bool
 }
 
 /////////////////////////////////////////////////////////////////////////////////
@@ -147,6 +149,18 @@ type Copy_Instruction_Tacky struct {
 
 /////////////////////////////////////////////////////////////////////////////////
 
+// dst = (left binOp right) ? src1 : src2, where binOp is a comparison. Only made by if-conversion.
+type Conditional_Copy_Instruction_Tacky struct {
+	binOp BinaryOperatorType
+	left  Value_Tacky
+	right Value_Tacky
+	src1  Value_Tacky
+	src2  Value_Tacky
+	dst   Value_Tacky
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
 // get address of variable src and store it in dst
 type Get_Address_Instruction_Tacky struct {
 	src Value_Tacky
@@ -189,6 +203,16 @@ type Jump_If_Not_Zero_Instruction_Tacky struct {
 
 /////////////////////////////////////////////////////////////////////////////////
 
+// jumps to target when (src1 binOp src2) is true, where binOp is a comparison
+type Jump_If_Compare_Instruction_Tacky struct {
+	binOp  BinaryOperatorType
+	src1   Value_Tacky
+	src2   Value_Tacky
+	target string
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
 type Label_Instruction_Tacky struct {
 	name string
 }
@@ -269,6 +293,15 @@ func doTackyGen(ast Program) Program_Tacky {
 func (pr *Program) genTacky() Program_Tacky {
 	topItems := []Top_Level_Tacky{}
 
+	// a function is inline if any of its declarations say so, not just the definition
+	inlineFunctions := make(map[string]bool)
+	for _, decl := range pr.decls {
+		fnDecl, isFunc := decl.(*Function_Declaration)
+		if isFunc && fnDecl.isInline {
+			inlineFunctions[fnDecl.name] = true
+		}
+	}
+
 	for _, decl := range pr.decls {
 		fnDecl, isFunc := decl.(*Function_Declaration)
 		if !isFunc {
@@ -281,7 +314,8 @@ func (pr *Program) genTacky() Program_Tacky {
 			// function definitions will have at least one instruction, function declarations won't have any instructions,
 			// we will only keep the function definitions
 			global := symbolTable[fnDecl.name].global
-			tacFunc := Function_Definition_Tacky{name: fnDecl.name, global: global, paramNames: fnDecl.paramNames, body: instrs}
+			tacFunc := Function_Definition_Tacky{name: fnDecl.name, global: global, paramNames: fnDecl.paramNames, body: instrs,
+				isInline: inlineFunctions[fnDecl.name]}
 			topItems = append(topItems, &tacFunc)
 		}
 	}
@@ -357,10 +391,9 @@ func (fn *Function_Declaration) declToTacky() []Instruction_Tacky {
 
 	bodyTac := fn.body.blockToTacky()
 
-	// Add a return statement to the end of every function just in case the original source didn't have one.
-	// If it already had a return statement then no big deal becuase this new ret instruction will never run.
-	ret := Return_Instruction_Tacky{&Constant_Value_Tacky{typ: INT_TYPE, value: "0"}}
-	bodyTac = append(bodyTac, &ret)
+	// add a return statement to the end of the function if the original source can reach the end without one
+	bodyTac = addImplicitReturn(fn, bodyTac)
+	warnUninitializedVariables(bodyTac)
 
 	return bodyTac
 }
@@ -385,13 +418,20 @@ func (b *Block) blockToTacky() []Instruction_Tacky {
 //###############################################################################
 
 func (bi *Block_Statement) blockItemToTacky() []Instruction_Tacky {
-	return bi.st.statementToTacky()
+	instructions := bi.st.statementToTacky()
+	recordStatementLocation(instructions, bi.loc)
+	return instructions
 }
 
 /////////////////////////////////////////////////////////////////////////////////
 
 func (bi *Block_Declaration) blockItemToTacky() []Instruction_Tacky {
-	return bi.decl.declToTacky()
+	instructions := bi.decl.declToTacky()
+	varDecl, isVarDecl := bi.decl.(*Variable_Declaration)
+	if isVarDecl {
+		recordStatementLocation(instructions, varDecl.loc)
+	}
+	return instructions
 }
 
 //###############################################################################
@@ -437,20 +477,16 @@ func (st *Expression_Statement) statementToTacky() []Instruction_Tacky {
 
 func (st *If_Statement) statementToTacky() []Instruction_Tacky {
 	if st.elseSt == nil {
-		c, instructions := expToTackyAndConvert(st.condition, []Instruction_Tacky{})
 		endLabel := makeLabelName("end")
-		jmp := Jump_If_Zero_Instruction_Tacky{condition: c, target: endLabel}
-		instructions = append(instructions, &jmp)
+		instructions := conditionToTacky(st.condition, endLabel, false, []Instruction_Tacky{})
 		moreInstr := st.thenSt.statementToTacky()
 		instructions = append(instructions, moreInstr...)
 		lblInstr := Label_Instruction_Tacky{endLabel}
 		instructions = append(instructions, &lblInstr)
 		return instructions
 	} else {
-		c, instructions := expToTackyAndConvert(st.condition, []Instruction_Tacky{})
 		elseLabel := makeLabelName("else")
-		jmpElse := Jump_If_Zero_Instruction_Tacky{condition: c, target: elseLabel}
-		instructions = append(instructions, &jmpElse)
+		instructions := conditionToTacky(st.condition, elseLabel, false, []Instruction_Tacky{})
 		moreInstr := st.thenSt.statementToTacky()
 		instructions = append(instructions, moreInstr...)
 		endLabel := makeLabelName("end")
@@ -494,10 +530,7 @@ func (st *While_Statement) statementToTacky() []Instruction_Tacky {
 	continueLabel := Label_Instruction_Tacky{"continue_" + st.label}
 	instructions = append(instructions, &continueLabel)
 
-	v, instructions := expToTackyAndConvert(st.condition, instructions)
-
-	jmpBreak := Jump_If_Zero_Instruction_Tacky{condition: v, target: "break_" + st.label}
-	instructions = append(instructions, &jmpBreak)
+	instructions = conditionToTacky(st.condition, "break_"+st.label, false, instructions)
 
 	moreInstr := st.body.statementToTacky()
 	instructions = append(instructions, moreInstr...)
@@ -525,10 +558,7 @@ func (st *Do_While_Statement) statementToTacky() []Instruction_Tacky {
 	continueLabel := Label_Instruction_Tacky{"continue_" + st.label}
 	instructions = append(instructions, &continueLabel)
 
-	v, instructions := expToTackyAndConvert(st.condition, instructions)
-
-	jmp := Jump_If_Not_Zero_Instruction_Tacky{condition: v, target: "start_" + st.label}
-	instructions = append(instructions, &jmp)
+	instructions = conditionToTacky(st.condition, "start_"+st.label, true, instructions)
 
 	breakLabel := Label_Instruction_Tacky{"break_" + st.label}
 	instructions = append(instructions, &breakLabel)
@@ -548,10 +578,7 @@ func (st *For_Statement) statementToTacky() []Instruction_Tacky {
 	instructions = append(instructions, &startLabel)
 
 	if st.condition != nil {
-		var v Value_Tacky
-		v, instructions = expToTackyAndConvert(st.condition, instructions)
-		jmpBreak := Jump_If_Zero_Instruction_Tacky{condition: v, target: "break_" + st.label}
-		instructions = append(instructions, &jmpBreak)
+		instructions = conditionToTacky(st.condition, "break_"+st.label, false, instructions)
 	}
 
 	moreInstr = st.body.statementToTacky()
@@ -598,6 +625,79 @@ func expToTackyAndConvert(exp Expression, instructions []Instruction_Tacky) (Val
 	return nil, []Instruction_Tacky{}
 }
 
+// Makes the jumps for a condition that only decides where to go next, like the condition of an if or a loop. It
+// jumps to target when the condition is the same as jumpIfTrue and falls through otherwise. Comparisons jump on the
+// result of the cmp, and &&, || and ! only change where the jumps go, so none of them need a 0 or 1 in a variable.
+func conditionToTacky(exp Expression, target string, jumpIfTrue bool, instructions []Instruction_Tacky) []Instruction_Tacky {
+	switch convertedExp := exp.(type) {
+	case *Unary_Expression:
+		if convertedExp.unOp == NOT_OPERATOR {
+			return conditionToTacky(convertedExp.innerExp, target, !jumpIfTrue, instructions)
+		}
+	case *Binary_Expression:
+		if convertedExp.binOp == AND_OPERATOR {
+			if !jumpIfTrue {
+				instructions = conditionToTacky(convertedExp.firstExp, target, false, instructions)
+				return conditionToTacky(convertedExp.secExp, target, false, instructions)
+			}
+			false_label := makeLabelName("and_false")
+			instructions = conditionToTacky(convertedExp.firstExp, false_label, false, instructions)
+			instructions = conditionToTacky(convertedExp.secExp, target, true, instructions)
+			return append(instructions, &Label_Instruction_Tacky{false_label})
+		} else if convertedExp.binOp == OR_OPERATOR {
+			if jumpIfTrue {
+				instructions = conditionToTacky(convertedExp.firstExp, target, true, instructions)
+				return conditionToTacky(convertedExp.secExp, target, true, instructions)
+			}
+			true_label := makeLabelName("or_true")
+			instructions = conditionToTacky(convertedExp.firstExp, true_label, true, instructions)
+			instructions = conditionToTacky(convertedExp.secExp, target, false, instructions)
+			return append(instructions, &Label_Instruction_Tacky{true_label})
+		}
+
+		// !(a < b) isn't the same as a >= b when one of them is NaN, so a double comparison that jumps when it's
+		// false is turned into 0 or 1 and checked with JUMP_IF_ZERO
+		operandTyp := getResultType(convertedExp.firstExp)
+		if isComparisonOperator(convertedExp.binOp) && (jumpIfTrue || (operandTyp.typ != DOUBLE_TYPE)) {
+			src1, instructions := expToTackyAndConvert(convertedExp.firstExp, instructions)
+			src2, instructions := expToTackyAndConvert(convertedExp.secExp, instructions)
+			binOp := convertedExp.binOp
+			if !jumpIfTrue {
+				binOp = getInvertedComparison(binOp)
+			}
+			jmp := Jump_If_Compare_Instruction_Tacky{binOp: binOp, src1: src1, src2: src2, target: target}
+			return append(instructions, &jmp)
+		}
+	}
+
+	c, instructions := expToTackyAndConvert(exp, instructions)
+	if jumpIfTrue {
+		return append(instructions, &Jump_If_Not_Zero_Instruction_Tacky{condition: c, target: target})
+	}
+	return append(instructions, &Jump_If_Zero_Instruction_Tacky{condition: c, target: target})
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// a < b is false when a >= b is true, only for integers and pointers
+func getInvertedComparison(binOp BinaryOperatorType) BinaryOperatorType {
+	switch binOp {
+	case IS_EQUAL_OPERATOR:
+		return NOT_EQUAL_OPERATOR
+	case NOT_EQUAL_OPERATOR:
+		return IS_EQUAL_OPERATOR
+	case LESS_THAN_OPERATOR:
+		return GREATER_OR_EQUAL_OPERATOR
+	case LESS_OR_EQUAL_OPERATOR:
+		return GREATER_THAN_OPERATOR
+	case GREATER_THAN_OPERATOR:
+		return LESS_OR_EQUAL_OPERATOR
+	case GREATER_OR_EQUAL_OPERATOR:
+		return LESS_THAN_OPERATOR
+	}
+	return binOp
+}
+
 /////////////////////////////////////////////////////////////////////////////////
 
 func (exp *Constant_Value_Expression) expToTacky(instructions []Instruction_Tacky) (Expression_Result_Tacky, []Instruction_Tacky) {
@@ -609,6 +709,7 @@ func (exp *Constant_Value_Expression) expToTacky(instructions []Instruction_Tack
 
 func (exp *Variable_Expression) expToTacky(instructions []Instruction_Tacky) (Expression_Result_Tacky, []Instruction_Tacky) {
 	v := Variable_Value_Tacky{exp.name}
+	valueLocations[&v] = exp.loc
 	return &Plain_Operand_Tacky{&v}, instructions
 }
 
@@ -679,13 +780,9 @@ func (exp *Unary_Expression) expToTacky(instructions []Instruction_Tacky) (Expre
 func (exp *Binary_Expression) expToTacky(instructions []Instruction_Tacky) (Expression_Result_Tacky, []Instruction_Tacky) {
 	// some operators can short-circuit on the first expression, so we handle them differently
 	if exp.binOp == AND_OPERATOR {
-		v1, instructions := expToTackyAndConvert(exp.firstExp, instructions)
 		false_label := makeLabelName("and_false")
-		j1 := Jump_If_Zero_Instruction_Tacky{condition: v1, target: false_label}
-		instructions = append(instructions, &j1)
-		v2, instructions := expToTackyAndConvert(exp.secExp, instructions)
-		j2 := Jump_If_Zero_Instruction_Tacky{condition: v2, target: false_label}
-		instructions = append(instructions, &j2)
+		instructions = conditionToTacky(exp.firstExp, false_label, false, instructions)
+		instructions = conditionToTacky(exp.secExp, false_label, false, instructions)
 		result := makeTackyVariable(getResultType(exp).typ)
 		cp1 := Copy_Instruction_Tacky{src: &Constant_Value_Tacky{typ: INT_TYPE, value: "1"}, dst: &result}
 		instructions = append(instructions, &cp1)
@@ -700,13 +797,9 @@ func (exp *Binary_Expression) expToTacky(instructions []Instruction_Tacky) (Expr
 		instructions = append(instructions, &lb2)
 		return &Plain_Operand_Tacky{&result}, instructions
 	} else if exp.binOp == OR_OPERATOR {
-		v1, instructions := expToTackyAndConvert(exp.firstExp, instructions)
 		true_label := makeLabelName("or_true")
-		j1 := Jump_If_Not_Zero_Instruction_Tacky{condition: v1, target: true_label}
-		instructions = append(instructions, &j1)
-		v2, instructions := expToTackyAndConvert(exp.secExp, instructions)
-		j2 := Jump_If_Not_Zero_Instruction_Tacky{condition: v2, target: true_label}
-		instructions = append(instructions, &j2)
+		instructions = conditionToTacky(exp.firstExp, true_label, true, instructions)
+		instructions = conditionToTacky(exp.secExp, true_label, true, instructions)
 		result := makeTackyVariable(getResultType(exp).typ)
 		cp1 := Copy_Instruction_Tacky{src: &Constant_Value_Tacky{typ: INT_TYPE, value: "0"}, dst: &result}
 		instructions = append(instructions, &cp1)
@@ -753,10 +846,8 @@ func (exp *Assignment_Expression) expToTacky(instructions []Instruction_Tacky) (
 /////////////////////////////////////////////////////////////////////////////////
 
 func (exp *Conditional_Expression) expToTacky(instructions []Instruction_Tacky) (Expression_Result_Tacky, []Instruction_Tacky) {
-	c, instructions := expToTackyAndConvert(exp.condition, instructions)
 	rightLabel := makeLabelName("rightExp")
-	jmp := Jump_If_Zero_Instruction_Tacky{c, rightLabel}
-	instructions = append(instructions, &jmp)
+	instructions = conditionToTacky(exp.condition, rightLabel, false, instructions)
 	v1, instructions := expToTackyAndConvert(exp.middleExp, instructions)
 	result := makeTackyVariable(getResultType(exp).typ)
 	cp1 := Copy_Instruction_Tacky{v1, &result}
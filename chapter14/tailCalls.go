@@ -0,0 +1,124 @@
+package main
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// Turns calls that a function makes to itself right before returning into jumps back to the start of the function,
+// so deep recursion doesn't use up the stack. The arguments are copied into the parameters first. Returns true if
+// anything changed.
+func eliminateTailRecursion(tacky *Program_Tacky) bool {
+	changed := false
+	for _, item := range tacky.topItems {
+		fn, isFunc := item.(*Function_Definition_Tacky)
+		if !isFunc || hasAddressTakenLocals(fn.body) {
+			continue
+		}
+
+		labels := makeLabelIndexes(fn.body)
+		startLabel := ""
+		newBody := []Instruction_Tacky{}
+		for index, instr := range fn.body {
+			call, isCall := instr.(*Function_Call_Tacky)
+			if !isCall || (call.funcName != fn.name) || !isInTailPosition(fn.body, index, labels) {
+				newBody = append(newBody, instr)
+				continue
+			}
+
+			if startLabel == "" {
+				startLabel = makeLabelName("tailRecursion")
+			}
+
+			// copy to temporaries first because the args can read the params, ex: f(b, a)
+			temps := []Variable_Value_Tacky{}
+			for _, arg := range call.args {
+				temp := makeTackyVariable(arg.getDataType())
+				newBody = append(newBody, &Copy_Instruction_Tacky{src: arg, dst: &temp})
+				temps = append(temps, temp)
+			}
+			for paramIndex, param := range fn.paramNames {
+				newBody = append(newBody, &Copy_Instruction_Tacky{src: &temps[paramIndex], dst: &Variable_Value_Tacky{param}})
+			}
+			// the instructions after the call can't be reached anymore, they're cleaned up later
+			newBody = append(newBody, &Jump_Instruction_Tacky{startLabel})
+		}
+
+		if startLabel != "" {
+			fn.body = append([]Instruction_Tacky{&Label_Instruction_Tacky{startLabel}}, newBody...)
+			changed = true
+		}
+	}
+	return changed
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// A call is in tail position when the only thing that happens after it is returning its result. Copies of the result
+// into other temporaries and jumps are followed, since that's how the result of a ?: or an if reaches the return.
+func isInTailPosition(body []Instruction_Tacky, callIndex int, labels map[string]int) bool {
+	call := body[callIndex].(*Function_Call_Tacky)
+	result := call.returnVal.(*Variable_Value_Tacky).name
+
+	// the step limit stops us from following a loop of jumps forever
+	index := callIndex + 1
+	for steps := 0; (index < len(body)) && (steps < len(body)); steps++ {
+		switch convertedInstr := body[index].(type) {
+		case *Return_Instruction_Tacky:
+			v, isVar := convertedInstr.val.(*Variable_Value_Tacky)
+			return isVar && (v.name == result)
+		case *Copy_Instruction_Tacky:
+			src, srcIsVar := convertedInstr.src.(*Variable_Value_Tacky)
+			dst, dstIsVar := convertedInstr.dst.(*Variable_Value_Tacky)
+			if !srcIsVar || !dstIsVar || (src.name != result) || (symbolTable[dst.name].attrs != LOCAL_ATTRIBUTES) {
+				return false
+			}
+			if getAsmTypeOfVariable(dst.name) != getAsmTypeOfVariable(src.name) {
+				return false
+			}
+			result = dst.name
+			index++
+		case *Jump_Instruction_Tacky:
+			labelIndex, found := labels[convertedInstr.target]
+			if !found {
+				return false
+			}
+			index = labelIndex + 1
+		case *Label_Instruction_Tacky:
+			index++
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// A function that gives out the address of one of its locals can't reuse its stack frame, because the pointer could
+// still be used by the function it calls.
+func hasAddressTakenLocals(body []Instruction_Tacky) bool {
+	for _, instr := range body {
+		getAddr, isGetAddr := instr.(*Get_Address_Instruction_Tacky)
+		if !isGetAddr {
+			continue
+		}
+		v, isVar := getAddr.src.(*Variable_Value_Tacky)
+		if isVar && (symbolTable[v.name].attrs == LOCAL_ATTRIBUTES) {
+			return true
+		}
+	}
+	return false
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func makeLabelIndexes(body []Instruction_Tacky) map[string]int {
+	labels := make(map[string]int)
+	for index, instr := range body {
+		lbl, isLabel := instr.(*Label_Instruction_Tacky)
+		if isLabel {
+			labels[lbl.name] = index
+		}
+	}
+	return labels
+}
@@ -1,5 +1,11 @@
 package main
 
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
 /////////////////////////////////////////////////////////////////////////////////
 
 type InitializerEnum int
@@ -132,12 +138,42 @@ func getResultType(exp Expression) Data_Type {
 
 /////////////////////////////////////////////////////////////////////////////////
 
+func getLocation(exp Expression) Source_Location {
+	switch convertedExp := exp.(type) {
+	case *Constant_Value_Expression:
+		return convertedExp.loc
+	case *Variable_Expression:
+		return convertedExp.loc
+	case *Cast_Expression:
+		return convertedExp.loc
+	case *Unary_Expression:
+		return convertedExp.loc
+	case *Binary_Expression:
+		return convertedExp.loc
+	case *Assignment_Expression:
+		return convertedExp.loc
+	case *Conditional_Expression:
+		return convertedExp.loc
+	case *Function_Call_Expression:
+		return convertedExp.loc
+	case *Dereference_Expression:
+		return convertedExp.loc
+	case *Address_Of_Expression:
+		return convertedExp.loc
+	default:
+		fail("Unknown Expression in getLocation")
+	}
+	return Source_Location{}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
 func convertToType(exp Expression, newTyp Data_Type) Expression {
 	res := getResultType(exp)
 	if res.isEqualType(&newTyp) {
 		return exp
 	}
-	castExp := Cast_Expression{targetType: newTyp, innerExp: exp}
+	castExp := Cast_Expression{targetType: newTyp, innerExp: exp, loc: getLocation(exp)}
 	return setResultType(&castExp, newTyp)
 }
 
@@ -151,6 +187,7 @@ func convertByAssignment(exp Expression, newTyp Data_Type) Expression {
 	}
 
 	if isArithmeticType(currentTyp) && isArithmeticType(newTyp) {
+		warnIfNarrowing(exp, newTyp)
 		return convertToType(exp, newTyp)
 	}
 
@@ -158,12 +195,146 @@ func convertByAssignment(exp Expression, newTyp Data_Type) Expression {
 		return convertToType(exp, newTyp)
 	}
 
-	fail("Cannot convert type for assignment")
+	failAt(getLocation(exp), "Cannot convert type for assignment")
 	return nil
 }
 
 /////////////////////////////////////////////////////////////////////////////////
 
+func warnIfNarrowing(exp Expression, newTyp Data_Type) {
+	currentTyp := getResultType(exp)
+
+	narrowing := false
+	if (currentTyp.typ == DOUBLE_TYPE) && (newTyp.typ != DOUBLE_TYPE) {
+		// the fractional part is lost
+		narrowing = true
+	} else if (currentTyp.typ != DOUBLE_TYPE) && (newTyp.typ == DOUBLE_TYPE) {
+		// a double has 53 bits of precision, so only the 64-bit integers can lose information
+		narrowing = size(currentTyp.typ) > 4
+	} else if size(newTyp.typ) < size(currentTyp.typ) {
+		narrowing = true
+	}
+	if !narrowing {
+		return
+	}
+
+	// a constant that fits into the new type doesn't lose anything, ex: int x = -5L;
+	value, isConst := getConstantValue(exp)
+	if isConst && constantFitsInType(value, currentTyp.typ, newTyp.typ) {
+		return
+	}
+
+	warn(CONVERSION_WARNING, getLocation(exp), "conversion from '"+getTypeName(currentTyp)+"' to '"+
+		getTypeName(newTyp)+"' may change value")
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// negative constants are parsed as a negate operator applied to a constant
+func getConstantValue(exp Expression) (string, bool) {
+	switch convertedExp := exp.(type) {
+	case *Constant_Value_Expression:
+		return convertedExp.value, true
+	case *Unary_Expression:
+		innerConst, isConst := convertedExp.innerExp.(*Constant_Value_Expression)
+		if (convertedExp.unOp == NEGATE_OPERATOR) && isConst && isSigned(innerConst.dTyp.typ) {
+			return "-" + innerConst.value, true
+		}
+	}
+	return "", false
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func constantFitsInType(value string, currentTyp DataTypeEnum, newTyp DataTypeEnum) bool {
+	if currentTyp == DOUBLE_TYPE {
+		double, err := strconv.ParseFloat(value, 64)
+		if (err != nil) || (double != math.Trunc(double)) {
+			return false
+		}
+		switch newTyp {
+		case INT_TYPE:
+			return (double >= math.MinInt32) && (double <= math.MaxInt32)
+		case LONG_TYPE:
+			return (double >= math.MinInt64) && (double < math.MaxInt64)
+		case UNSIGNED_INT_TYPE:
+			return (double >= 0) && (double <= math.MaxUint32)
+		case UNSIGNED_LONG_TYPE:
+			return (double >= 0) && (double < math.MaxUint64)
+		}
+		return false
+	}
+
+	if isSigned(currentTyp) {
+		integer, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return false
+		}
+		switch newTyp {
+		case INT_TYPE:
+			return (integer >= math.MinInt32) && (integer <= math.MaxInt32)
+		case UNSIGNED_INT_TYPE:
+			return (integer >= 0) && (integer <= math.MaxUint32)
+		case DOUBLE_TYPE:
+			return (integer >= -(1 << 53)) && (integer <= (1 << 53))
+		}
+		return true
+	} else {
+		integer, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return false
+		}
+		switch newTyp {
+		case INT_TYPE:
+			return integer <= math.MaxInt32
+		case UNSIGNED_INT_TYPE:
+			return integer <= math.MaxUint32
+		case DOUBLE_TYPE:
+			return integer <= (1 << 53)
+		}
+		return true
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func isComparisonOperator(binOp BinaryOperatorType) bool {
+	switch binOp {
+	case IS_EQUAL_OPERATOR, NOT_EQUAL_OPERATOR, LESS_THAN_OPERATOR, LESS_OR_EQUAL_OPERATOR,
+		GREATER_THAN_OPERATOR, GREATER_OR_EQUAL_OPERATOR:
+		return true
+	}
+	return false
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// converting to the common type can turn a negative signed value into a large unsigned value
+func warnIfSignCompare(exp1 Expression, exp2 Expression, commonTyp Data_Type, loc Source_Location) {
+	if (commonTyp.typ == DOUBLE_TYPE) || isSigned(commonTyp.typ) {
+		return
+	}
+
+	typ1 := getResultType(exp1)
+	typ2 := getResultType(exp2)
+	if (typ1.typ == DOUBLE_TYPE) || (typ2.typ == DOUBLE_TYPE) || (isSigned(typ1.typ) == isSigned(typ2.typ)) {
+		return
+	}
+
+	// a non-negative constant keeps its value when converted, so gcc doesn't warn about it either
+	for _, exp := range []Expression{exp1, exp2} {
+		value, isConst := getConstantValue(exp)
+		if isConst && !strings.HasPrefix(value, "-") {
+			return
+		}
+	}
+
+	warn(SIGN_COMPARE_WARNING, loc, "comparison of integer expressions of different signedness: '"+
+		getTypeName(typ1)+"' and '"+getTypeName(typ2)+"'")
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
 func isArithmeticType(dTyp Data_Type) bool {
 	if (dTyp.typ == INT_TYPE) || (dTyp.typ == LONG_TYPE) || (dTyp.typ == UNSIGNED_INT_TYPE) ||
 		(dTyp.typ == UNSIGNED_LONG_TYPE) || (dTyp.typ == DOUBLE_TYPE) {
@@ -244,7 +415,7 @@ func getCommonPointerType(exp1 Expression, exp2 Expression) Data_Type {
 		return exp1Typ
 	}
 
-	fail("Expressions have incompatible types")
+	failAt(getLocation(exp1), "Expressions have incompatible types")
 	return Data_Type{}
 }
 
@@ -285,15 +456,15 @@ func typeCheckFuncDecl(decl Function_Declaration) Function_Declaration {
 	oldDecl, inSymbolTable := symbolTable[decl.name]
 	if inSymbolTable {
 		if !oldDecl.dataTyp.isEqualType(&newTyp) {
-			fail("Incompatible function declarations for function", decl.name)
+			failAt(decl.loc, "Incompatible function declarations for function", decl.name)
 		}
 		alreadyDefined = oldDecl.defined
 		if alreadyDefined && hasBody {
-			fail("Function", decl.name, "has two definitions.")
+			failAt(decl.loc, "Function", decl.name, "has two definitions.")
 		}
 
 		if oldDecl.global && decl.storageClass == STATIC_STORAGE_CLASS {
-			fail("Static function declaration follows non-static declaration of", decl.name)
+			failAt(decl.loc, "Static function declaration follows non-static declaration of", decl.name)
 		}
 		global = oldDecl.global
 	}
@@ -335,7 +506,7 @@ func typeCheckFileScopeVarDecl(decl Variable_Declaration) Variable_Declaration {
 			initEnum = TENTATIVE_INIT
 		}
 	} else {
-		fail("Non-constant initializer for variable", decl.name)
+		failAt(decl.loc, "Non-constant initializer for variable", decl.name)
 	}
 
 	global := (decl.storageClass != STATIC_STORAGE_CLASS)
@@ -343,12 +514,12 @@ func typeCheckFileScopeVarDecl(decl Variable_Declaration) Variable_Declaration {
 	oldDecl, alreadyExists := symbolTable[decl.name]
 	if alreadyExists {
 		if !oldDecl.dataTyp.isEqualType(&decl.dTyp) {
-			fail("Data types don't match for variable", decl.name)
+			failAt(decl.loc, "Data types don't match for variable", decl.name)
 		}
 		if decl.storageClass == EXTERN_STORAGE_CLASS {
 			global = oldDecl.global
 		} else if oldDecl.global != global {
-			fail("Conflicting variable linkage")
+			failAt(decl.loc, "Conflicting variable linkage")
 		}
 
 		// TODO: update this when more types are available, and the else if below.
@@ -357,7 +528,7 @@ func typeCheckFileScopeVarDecl(decl Variable_Declaration) Variable_Declaration {
 		if (oldDecl.initEnum == INITIAL_INT) || (oldDecl.initEnum == INITIAL_LONG) || (oldDecl.initEnum == INITIAL_UNSIGNED_INT) ||
 			(oldDecl.initEnum == INITIAL_UNSIGNED_LONG) || (oldDecl.initEnum == INITIAL_DOUBLE) {
 			if initEnum == oldDecl.initEnum {
-				fail("Conflicting file scope variable declarations")
+				failAt(decl.loc, "Conflicting file scope variable declarations")
 			} else {
 				initEnum = oldDecl.initEnum
 				initialValue = oldDecl.initialValue
@@ -380,12 +551,12 @@ func typeCheckLocalVarDecl(decl Variable_Declaration) Variable_Declaration {
 	// every variable should have a unique name at this point, so it won't conflict with any existing entry
 	if decl.storageClass == EXTERN_STORAGE_CLASS {
 		if decl.initializer != nil {
-			fail("Initializer on local extern variable declaration")
+			failAt(decl.loc, "Initializer on local extern variable declaration")
 		}
 		oldDecl, alreadyExists := symbolTable[decl.name]
 		if alreadyExists {
 			if !oldDecl.dataTyp.isEqualType(&decl.dTyp) {
-				fail("Data types don't match for variable", decl.name)
+				failAt(decl.loc, "Data types don't match for variable", decl.name)
 			}
 		} else {
 			symbolTable[decl.name] = Symbol{dataTyp: decl.dTyp, attrs: STATIC_ATTRIBUTES, global: true, initEnum: NO_INITIALIZER}
@@ -406,7 +577,7 @@ func typeCheckLocalVarDecl(decl Variable_Declaration) Variable_Declaration {
 			initEnum = dataTypeEnumToInitEnum(decl.dTyp.typ)
 			initialValue = "0"
 		} else {
-			fail("Non-constant initializer on local static variable")
+			failAt(decl.loc, "Non-constant initializer on local static variable")
 		}
 		symbolTable[decl.name] = Symbol{dataTyp: decl.dTyp, attrs: STATIC_ATTRIBUTES, global: false,
 			initEnum: initEnum, initialValue: initialValue}
@@ -516,7 +687,7 @@ func typeCheckForInitial(initial For_Initial_Clause) For_Initial_Clause {
 	switch convertedInit := initial.(type) {
 	case *For_Initial_Declaration:
 		if convertedInit.decl.storageClass != NONE_STORAGE_CLASS {
-			fail("For loop initializer can not have storage-class specifier")
+			failAt(convertedInit.decl.loc, "For loop initializer can not have storage-class specifier")
 		}
 		convertedInit.decl = typeCheckLocalVarDecl(convertedInit.decl)
 		return convertedInit
@@ -540,7 +711,7 @@ func typeCheckExpression(exp Expression) Expression {
 	case *Variable_Expression:
 		dTyp := symbolTable[convertedExp.name].dataTyp
 		if dTyp.typ == FUNCTION_TYPE {
-			fail("Function name", convertedExp.name, "used as variable")
+			failAt(convertedExp.loc, "Function name", convertedExp.name, "used as variable")
 		}
 		return setResultType(convertedExp, dTyp)
 	case *Cast_Expression:
@@ -549,22 +720,22 @@ func typeCheckExpression(exp Expression) Expression {
 		innerTyp := getResultType(newInner).typ
 		targetTyp := convertedExp.targetType.typ
 		if ((innerTyp == POINTER_TYPE) && (targetTyp == DOUBLE_TYPE)) || ((innerTyp == DOUBLE_TYPE) && (targetTyp == POINTER_TYPE)) {
-			fail("Can't convert between pointer and double types")
+			failAt(convertedExp.loc, "Can't convert between pointer and double types")
 		}
 
-		newCast := Cast_Expression{targetType: convertedExp.targetType, innerExp: newInner}
+		newCast := Cast_Expression{targetType: convertedExp.targetType, innerExp: newInner, loc: convertedExp.loc}
 		return setResultType(&newCast, convertedExp.targetType)
 	case *Unary_Expression:
 		newInner := typeCheckExpression(convertedExp.innerExp)
 		if getResultType(newInner).typ == POINTER_TYPE {
 			if (convertedExp.unOp == NEGATE_OPERATOR) || (convertedExp.unOp == COMPLEMENT_OPERATOR) {
-				fail("Can't negate or take the bitwise complement of a pointer")
+				failAt(convertedExp.loc, "Can't negate or take the bitwise complement of a pointer")
 			}
 		}
 		if (getResultType(newInner).typ == DOUBLE_TYPE) && (convertedExp.unOp == COMPLEMENT_OPERATOR) {
-			fail("Can't take the bitwise complement of a double")
+			failAt(convertedExp.loc, "Can't take the bitwise complement of a double")
 		}
-		newUnary := Unary_Expression{unOp: convertedExp.unOp, innerExp: newInner}
+		newUnary := Unary_Expression{unOp: convertedExp.unOp, innerExp: newInner, loc: convertedExp.loc}
 		if convertedExp.unOp == NOT_OPERATOR {
 			return setResultType(&newUnary, Data_Type{typ: INT_TYPE})
 		} else {
@@ -578,17 +749,17 @@ func typeCheckExpression(exp Expression) Expression {
 
 		if (convertedExp.binOp == MULTIPLY_OPERATOR) || (convertedExp.binOp == DIVIDE_OPERATOR) || (convertedExp.binOp == REMAINDER_OPERATOR) {
 			if (typ1.typ == POINTER_TYPE) || (typ2.typ == POINTER_TYPE) {
-				fail("Can't multiply, divide, or take the remainder of pointers")
+				failAt(convertedExp.loc, "Can't multiply, divide, or take the remainder of pointers")
 			}
 		}
 
 		if convertedExp.binOp == REMAINDER_OPERATOR {
 			if (typ1.typ == DOUBLE_TYPE) || (typ2.typ == DOUBLE_TYPE) {
-				fail("Can't take the remainder using doubles")
+				failAt(convertedExp.loc, "Can't take the remainder using doubles")
 			}
 		}
 		if (convertedExp.binOp == AND_OPERATOR) || (convertedExp.binOp == OR_OPERATOR) {
-			newBinExp := Binary_Expression{binOp: convertedExp.binOp, firstExp: newFirstExp, secExp: newSecExp}
+			newBinExp := Binary_Expression{binOp: convertedExp.binOp, firstExp: newFirstExp, secExp: newSecExp, loc: convertedExp.loc}
 			return setResultType(&newBinExp, Data_Type{typ: INT_TYPE})
 		}
 
@@ -597,10 +768,13 @@ func typeCheckExpression(exp Expression) Expression {
 			commonTyp = getCommonPointerType(newFirstExp, newSecExp)
 		} else {
 			commonTyp = getCommonType(typ1, typ2)
+			if isComparisonOperator(convertedExp.binOp) {
+				warnIfSignCompare(newFirstExp, newSecExp, commonTyp, convertedExp.loc)
+			}
 		}
 		newFirstExp = convertToType(newFirstExp, commonTyp)
 		newSecExp = convertToType(newSecExp, commonTyp)
-		newBinExp := Binary_Expression{binOp: convertedExp.binOp, firstExp: newFirstExp, secExp: newSecExp}
+		newBinExp := Binary_Expression{binOp: convertedExp.binOp, firstExp: newFirstExp, secExp: newSecExp, loc: convertedExp.loc}
 		if (convertedExp.binOp == ADD_OPERATOR) || (convertedExp.binOp == SUBTRACT_OPERATOR) || (convertedExp.binOp == MULTIPLY_OPERATOR) ||
 			(convertedExp.binOp == DIVIDE_OPERATOR) || (convertedExp.binOp == REMAINDER_OPERATOR) {
 			return setResultType(&newBinExp, commonTyp)
@@ -611,13 +785,13 @@ func typeCheckExpression(exp Expression) Expression {
 	case *Assignment_Expression:
 		valid := isValidLvalue(convertedExp.lvalue)
 		if !valid {
-			fail("Semantic error. Invalid lvalue on left side of assignment.")
+			failAt(getLocation(convertedExp.lvalue), "Semantic error. Invalid lvalue on left side of assignment.")
 		}
 		newLvalue := typeCheckExpression(convertedExp.lvalue)
 		newRightExp := typeCheckExpression(convertedExp.rightExp)
 		leftTyp := getResultType(newLvalue)
 		newRightExp = convertByAssignment(newRightExp, leftTyp)
-		assignExp := Assignment_Expression{lvalue: newLvalue, rightExp: newRightExp}
+		assignExp := Assignment_Expression{lvalue: newLvalue, rightExp: newRightExp, loc: convertedExp.loc}
 		return setResultType(&assignExp, leftTyp)
 	case *Conditional_Expression:
 		newMiddle := typeCheckExpression(convertedExp.middleExp)
@@ -635,22 +809,22 @@ func typeCheckExpression(exp Expression) Expression {
 		newMiddle = convertToType(newMiddle, commonTyp)
 		newRight = convertToType(newRight, commonTyp)
 		newCond := typeCheckExpression(convertedExp.condition)
-		newExp := Conditional_Expression{condition: newCond, middleExp: newMiddle, rightExp: newRight}
+		newExp := Conditional_Expression{condition: newCond, middleExp: newMiddle, rightExp: newRight, loc: convertedExp.loc}
 		return setResultType(&newExp, commonTyp)
 	case *Function_Call_Expression:
 		existingSym, inTable := symbolTable[convertedExp.functionName]
 
 		if !inTable {
-			fail("Calling a function that's not in the symbol table:", convertedExp.functionName)
+			failAt(convertedExp.loc, "Calling a function that's not in the symbol table:", convertedExp.functionName)
 		}
 
 		existingTyp := existingSym.dataTyp
 		if existingTyp.typ != FUNCTION_TYPE {
-			fail("Variable used as function name:", convertedExp.functionName)
+			failAt(convertedExp.loc, "Variable used as function name:", convertedExp.functionName)
 		}
 
 		if len(existingTyp.paramTypes) != len(convertedExp.args) {
-			fail("Function called with the wrong number of arguments:", convertedExp.functionName)
+			failAt(convertedExp.loc, "Function called with the wrong number of arguments:", convertedExp.functionName)
 		}
 
 		newArgs := []Expression{}
@@ -660,24 +834,24 @@ func typeCheckExpression(exp Expression) Expression {
 			newArgs = append(newArgs, newArg)
 		}
 
-		callExp := Function_Call_Expression{functionName: convertedExp.functionName, args: newArgs}
+		callExp := Function_Call_Expression{functionName: convertedExp.functionName, args: newArgs, loc: convertedExp.loc}
 		return setResultType(&callExp, *existingTyp.returnType)
 	case *Dereference_Expression:
 		newInner := typeCheckExpression(convertedExp.innerExp)
 		dType := getResultType(newInner)
 		if dType.typ != POINTER_TYPE {
-			fail("Dereference operator must use a pointer")
+			failAt(convertedExp.loc, "Dereference operator must use a pointer")
 		}
-		derefExp := Dereference_Expression{innerExp: newInner}
+		derefExp := Dereference_Expression{innerExp: newInner, loc: convertedExp.loc}
 		return setResultType(&derefExp, *dType.refType)
 	case *Address_Of_Expression:
 		valid := isValidLvalue(convertedExp.innerExp)
 		if !valid {
-			fail("Semantic error. Address_Of expression requires lvalue.")
+			failAt(getLocation(convertedExp.innerExp), "Semantic error. Address_Of expression requires lvalue.")
 		}
 		newInner := typeCheckExpression(convertedExp.innerExp)
 		referencedTyp := getResultType(newInner)
-		addrExp := Address_Of_Expression{innerExp: newInner}
+		addrExp := Address_Of_Expression{innerExp: newInner, loc: convertedExp.loc}
 		return setResultType(&addrExp, Data_Type{typ: POINTER_TYPE, refType: &referencedTyp})
 	}
 
@@ -0,0 +1,153 @@
+package main
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+func eliminateUnreachableCode(body []Instruction_Tacky) []Instruction_Tacky {
+	cfg := makeControlFlowGraph(body)
+	cfg.removeUnreachableBlocks()
+	cfg.removeUselessJumps()
+	cfg.removeUselessLabels()
+	return cfg.toInstructions()
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// The edges that a branch with a constant condition can't take aren't in the graph, so its target can be removed.
+// The branch is replaced first, so it doesn't jump to a label that is gone.
+func (cfg *Control_Flow_Graph) removeUnreachableBlocks() {
+	reachable := cfg.findReachableBlocks()
+
+	keptBlocks := []*Basic_Block{}
+	for _, block := range cfg.blocks {
+		if reachable[block] {
+			block.foldConstantBranch()
+			keptBlocks = append(keptBlocks, block)
+			continue
+		}
+		for _, succ := range block.succs {
+			succ.removePred(block)
+		}
+	}
+	cfg.blocks = keptBlocks
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// same as foldConstants, a branch that is always taken becomes a jump and a branch that is never taken is removed
+func (block *Basic_Block) foldConstantBranch() {
+	if len(block.instructions) == 0 {
+		return
+	}
+	last := len(block.instructions) - 1
+	target := ""
+	taken := false
+	switch convertedInstr := block.instructions[last].(type) {
+	case *Jump_If_Zero_Instruction_Tacky:
+		constant, isConst := convertedInstr.condition.(*Constant_Value_Tacky)
+		if !isConst {
+			return
+		}
+		target = convertedInstr.target
+		taken = isZeroConstant(constant)
+	case *Jump_If_Not_Zero_Instruction_Tacky:
+		constant, isConst := convertedInstr.condition.(*Constant_Value_Tacky)
+		if !isConst {
+			return
+		}
+		target = convertedInstr.target
+		taken = !isZeroConstant(constant)
+	case *Jump_If_Compare_Instruction_Tacky:
+		isConst := false
+		taken, isConst = foldJumpIfCompare(convertedInstr)
+		if !isConst {
+			return
+		}
+		target = convertedInstr.target
+	default:
+		return
+	}
+
+	if taken {
+		block.instructions[last] = &Jump_Instruction_Tacky{target: target}
+	} else {
+		block.instructions = block.instructions[:last]
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// a jump to the block that comes right after it does nothing, because that's where execution goes anyways
+func (cfg *Control_Flow_Graph) removeUselessJumps() {
+	for index, block := range cfg.blocks {
+		if (index + 1) >= len(cfg.blocks) {
+			break
+		}
+		// a block whose only instruction was a branch that is never taken is empty now
+		if (len(block.instructions) == 0) || (len(cfg.blocks[index+1].instructions) == 0) {
+			continue
+		}
+		nextLabel, nextIsLabel := cfg.blocks[index+1].instructions[0].(*Label_Instruction_Tacky)
+		if !nextIsLabel {
+			continue
+		}
+
+		// the conditions are always variables or constants so they don't have side effects to keep
+		target := ""
+		switch convertedInstr := block.instructions[len(block.instructions)-1].(type) {
+		case *Jump_Instruction_Tacky:
+			target = convertedInstr.target
+		case *Jump_If_Zero_Instruction_Tacky:
+			target = convertedInstr.target
+		case *Jump_If_Not_Zero_Instruction_Tacky:
+			target = convertedInstr.target
+		case *Jump_If_Compare_Instruction_Tacky:
+			target = convertedInstr.target
+		}
+		if target == nextLabel.name {
+			block.instructions = block.instructions[:len(block.instructions)-1]
+		}
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func (cfg *Control_Flow_Graph) removeUselessLabels() {
+	targeted := make(map[string]bool)
+	for _, block := range cfg.blocks {
+		for _, instr := range block.instructions {
+			switch convertedInstr := instr.(type) {
+			case *Jump_Instruction_Tacky:
+				targeted[convertedInstr.target] = true
+			case *Jump_If_Zero_Instruction_Tacky:
+				targeted[convertedInstr.target] = true
+			case *Jump_If_Not_Zero_Instruction_Tacky:
+				targeted[convertedInstr.target] = true
+			case *Jump_If_Compare_Instruction_Tacky:
+				targeted[convertedInstr.target] = true
+			}
+		}
+	}
+
+	for _, block := range cfg.blocks {
+		if len(block.instructions) == 0 {
+			continue
+		}
+		lbl, isLabel := block.instructions[0].(*Label_Instruction_Tacky)
+		if isLabel && !targeted[lbl.name] {
+			block.instructions = block.instructions[1:]
+		}
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func (block *Basic_Block) removePred(pred *Basic_Block) {
+	for index, _ := range block.preds {
+		if block.preds[index] == pred {
+			block.preds = append(block.preds[:index], block.preds[index+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+func intConst(value uint64) *Constant_Value_Tacky {
+	return makeIntegerConstant(INT_TYPE, value)
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// Runs the pass by itself, without constant folding first, so the branches with constant conditions are still there
+// when their targets are removed. ex: int main(void) { if (1) return 3; return 4; }
+var unreachableCodeTests = []struct {
+	name  string
+	input []Instruction_Tacky
+	want  []Instruction_Tacky
+}{
+	{
+		name: "branch that is never taken",
+		input: []Instruction_Tacky{
+			&Jump_If_Zero_Instruction_Tacky{condition: intConst(1), target: "else"},
+			&Return_Instruction_Tacky{val: intConst(3)},
+			&Label_Instruction_Tacky{name: "else"},
+			&Return_Instruction_Tacky{val: intConst(4)},
+		},
+		want: []Instruction_Tacky{
+			&Return_Instruction_Tacky{val: intConst(3)},
+		},
+	},
+	{
+		name: "branch that is always taken",
+		input: []Instruction_Tacky{
+			&Jump_If_Not_Zero_Instruction_Tacky{condition: intConst(1), target: "then"},
+			&Return_Instruction_Tacky{val: intConst(4)},
+			&Label_Instruction_Tacky{name: "then"},
+			&Return_Instruction_Tacky{val: intConst(3)},
+		},
+		want: []Instruction_Tacky{
+			&Return_Instruction_Tacky{val: intConst(3)},
+		},
+	},
+	{
+		name: "comparison of two constants",
+		input: []Instruction_Tacky{
+			&Jump_If_Compare_Instruction_Tacky{binOp: LESS_THAN_OPERATOR, src1: intConst(2), src2: intConst(1), target: "else"},
+			&Return_Instruction_Tacky{val: intConst(3)},
+			&Label_Instruction_Tacky{name: "else"},
+			&Return_Instruction_Tacky{val: intConst(4)},
+		},
+		want: []Instruction_Tacky{
+			&Return_Instruction_Tacky{val: intConst(3)},
+		},
+	},
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+func TestEliminateUnreachableCode(t *testing.T) {
+	for _, tt := range unreachableCodeTests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := eliminateUnreachableCode(tt.input)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got:\n%s\nwant:\n%s", getTackyBodyString(got), getTackyBodyString(tt.want))
+			}
+		})
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func getTackyBodyString(body []Instruction_Tacky) string {
+	text := ""
+	for _, instr := range body {
+		text += "\t" + getTackyInstructionString(instr) + "\n"
+	}
+	return text
+}
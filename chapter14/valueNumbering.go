@@ -0,0 +1,176 @@
+package main
+
+import "fmt"
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// Two instructions with the same key compute the same value, as long as their srcs are SSA values that can't change
+// between them.
+type Expression_Key struct {
+	kind string
+	op   int
+	src1 string
+	src2 string
+	// the type of the dst, since the conversions with the same src can give different types
+	typ DataTypeEnum
+}
+
+type Value_Numbering_State struct {
+	ssa *SSA_Function
+	// the earlier value that each replaced variable is the same as
+	canonical map[string]Value_Tacky
+	changed   bool
+}
+
+//###############################################################################
+//###############################################################################
+//###############################################################################
+
+// Global value numbering in SSA, walking the dominator tree like "Value Numbering" by Briggs, Cooper and Simpson. An
+// instruction that computes the same thing as one in a block that dominates it becomes a copy of the earlier result,
+// and copy propagation and dead store elimination clean up the copies. Loads are only reused until something could
+// write to memory.
+func eliminateCommonSubexpressions(ssa *SSA_Function) bool {
+	state := Value_Numbering_State{ssa: ssa, canonical: make(map[string]Value_Tacky)}
+	state.numberBlock(ssa.cfg.entry, make(map[Expression_Key]Value_Tacky), make(map[Expression_Key]Value_Tacky))
+	return state.changed
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// The expressions of a block are available in every block it dominates. The loads are only passed on to a block that
+// can't be reached any other way, since a store on another path into the block could change them.
+func (state *Value_Numbering_State) numberBlock(block *Basic_Block, available map[Expression_Key]Value_Tacky, loads map[Expression_Key]Value_Tacky) {
+	added := []Expression_Key{}
+	replace := func(val Value_Tacky) Value_Tacky {
+		v, isVar := val.(*Variable_Value_Tacky)
+		if !isVar {
+			return val
+		}
+		earlier, found := state.canonical[v.name]
+		if !found {
+			return val
+		}
+		return earlier
+	}
+
+	for index, instr := range block.instructions {
+		instr = replaceInstructionUses(instr, replace)
+		block.instructions[index] = instr
+
+		switch instr.(type) {
+		case *Store_Instruction_Tacky, *Function_Call_Tacky:
+			loads = make(map[Expression_Key]Value_Tacky)
+			continue
+		}
+		dst, isVar := getInstructionDst(instr).(*Variable_Value_Tacky)
+		if !isVar {
+			continue
+		}
+		if !state.ssa.isSingleAssignment(dst) {
+			// a static or aliased variable can be read through a pointer
+			loads = make(map[Expression_Key]Value_Tacky)
+			continue
+		}
+
+		key, ok := state.getExpressionKey(instr)
+		if !ok {
+			continue
+		}
+		table := available
+		_, isLoad := instr.(*Load_Instruction_Tacky)
+		if isLoad {
+			table = loads
+		}
+		earlier, found := table[key]
+		if found {
+			block.instructions[index] = &Copy_Instruction_Tacky{src: earlier, dst: dst}
+			state.canonical[dst.name] = earlier
+			state.changed = true
+			continue
+		}
+		table[key] = dst
+		if !isLoad {
+			added = append(added, key)
+		}
+	}
+
+	for _, child := range state.ssa.children[block] {
+		childLoads := make(map[Expression_Key]Value_Tacky)
+		if (len(child.preds) == 1) && (child.preds[0] == block) {
+			for key, val := range loads {
+				childLoads[key] = val
+			}
+		}
+		state.numberBlock(child, available, childLoads)
+	}
+	for _, key := range added {
+		delete(available, key)
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// returns false if the instruction can't be numbered, either because of what it is or because a src could change
+func (state *Value_Numbering_State) getExpressionKey(instr Instruction_Tacky) (Expression_Key, bool) {
+	key := Expression_Key{typ: getInstructionDst(instr).getDataType()}
+	switch convertedInstr := instr.(type) {
+	case *Unary_Instruction_Tacky:
+		key.kind = "unary"
+		key.op = int(convertedInstr.unOp)
+	case *Binary_Instruction_Tacky:
+		key.kind = "binary"
+		key.op = int(convertedInstr.binOp)
+	case *Sign_Extend_Instruction_Tacky:
+		key.kind = "sign-extend"
+	case *Truncate_Instruction_Tacky:
+		key.kind = "truncate"
+	case *Zero_Extend_Instruction_Tacky:
+		key.kind = "zero-extend"
+	case *Double_To_Int_Instruction_Tacky:
+		key.kind = "double-to-int"
+	case *Double_To_UInt_Instruction_Tacky:
+		key.kind = "double-to-uint"
+	case *Int_To_Double_Instruction_Tacky:
+		key.kind = "int-to-double"
+	case *UInt_To_Double_Instruction_Tacky:
+		key.kind = "uint-to-double"
+	case *Load_Instruction_Tacky:
+		key.kind = "load"
+	default:
+		return key, false
+	}
+
+	srcs := []string{}
+	for _, val := range getInstructionUses(instr) {
+		switch convertedVal := val.(type) {
+		case *Constant_Value_Tacky:
+			srcs = append(srcs, fmt.Sprintf("%d:%s", convertedVal.typ, convertedVal.value))
+		case *Variable_Value_Tacky:
+			if !state.ssa.isSingleAssignment(convertedVal) {
+				return key, false
+			}
+			srcs = append(srcs, convertedVal.name)
+		}
+	}
+	key.src1 = srcs[0]
+	if len(srcs) > 1 {
+		key.src2 = srcs[1]
+		if isCommutative(BinaryOperatorType(key.op)) && (key.src1 > key.src2) {
+			key.src1, key.src2 = key.src2, key.src1
+		}
+	}
+	return key, true
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func isCommutative(binOp BinaryOperatorType) bool {
+	switch binOp {
+	case ADD_OPERATOR, MULTIPLY_OPERATOR, IS_EQUAL_OPERATOR, NOT_EQUAL_OPERATOR:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+/////////////////////////////////////////////////////////////////////////////////
+
+type WarningEnum int
+
+const (
+	NONE_WARNING WarningEnum = iota
+	CONVERSION_WARNING
+	SIGN_COMPARE_WARNING
+	UNUSED_VARIABLE_WARNING
+	UNUSED_PARAMETER_WARNING
+	UNUSED_FUNCTION_WARNING
+	SHADOW_WARNING
+	OVERFLOW_WARNING
+	RETURN_TYPE_WARNING
+	UNREACHABLE_CODE_WARNING
+	UNINITIALIZED_WARNING
+	MAYBE_UNINITIALIZED_WARNING
+)
+
+type Warning_Info struct {
+	// the name used on the command line, ex: -Wunused-variable or -Wno-unused-variable
+	name             string
+	enabledByDefault bool
+	inWall           bool
+	inWextra         bool
+}
+
+// roughly follows which group gcc puts each warning in
+var allWarnings = map[WarningEnum]Warning_Info{
+	CONVERSION_WARNING:          {name: "conversion"},
+	SIGN_COMPARE_WARNING:        {name: "sign-compare", inWextra: true},
+	UNUSED_VARIABLE_WARNING:     {name: "unused-variable", inWall: true},
+	UNUSED_PARAMETER_WARNING:    {name: "unused-parameter", inWextra: true},
+	UNUSED_FUNCTION_WARNING:     {name: "unused-function", inWall: true},
+	SHADOW_WARNING:              {name: "shadow"},
+	OVERFLOW_WARNING:            {name: "overflow", enabledByDefault: true},
+	RETURN_TYPE_WARNING:         {name: "return-type", enabledByDefault: true},
+	UNREACHABLE_CODE_WARNING:    {name: "unreachable-code", inWextra: true},
+	UNINITIALIZED_WARNING:       {name: "uninitialized", inWall: true},
+	MAYBE_UNINITIALIZED_WARNING: {name: "maybe-uninitialized", inWall: true},
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+type Warning_Settings struct {
+	wall    bool
+	wextra  bool
+	werror  bool
+	enabled map[WarningEnum]bool // only holds warnings that were explicitly turned on or off
+	isError map[WarningEnum]bool // only holds warnings that were explicitly made into errors or not
+}
+
+var warningSettings = Warning_Settings{enabled: make(map[WarningEnum]bool), isError: make(map[WarningEnum]bool)}
+
+// the number of warnings that were turned into errors by -Werror
+var warningErrorCount = 0
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func getWarningByName(name string) (WarningEnum, bool) {
+	for enum, info := range allWarnings {
+		if info.name == name {
+			return enum, true
+		}
+	}
+	return NONE_WARNING, false
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// returns false if the option is not a warning option that we know about
+func parseWarningOption(option string) bool {
+	switch option {
+	case "-Wall":
+		warningSettings.wall = true
+		return true
+	case "-Wextra":
+		warningSettings.wextra = true
+		return true
+	case "-Werror":
+		warningSettings.werror = true
+		return true
+	case "-Wno-error":
+		warningSettings.werror = false
+		return true
+	}
+
+	if name, found := strings.CutPrefix(option, "-Werror="); found {
+		// like gcc, -Werror=<name> also turns the warning on
+		enum, known := getWarningByName(name)
+		if known {
+			warningSettings.enabled[enum] = true
+			warningSettings.isError[enum] = true
+		}
+		return known
+	} else if name, found := strings.CutPrefix(option, "-Wno-error="); found {
+		enum, known := getWarningByName(name)
+		if known {
+			warningSettings.isError[enum] = false
+		}
+		return known
+	} else if name, found := strings.CutPrefix(option, "-Wno-"); found {
+		enum, known := getWarningByName(name)
+		if known {
+			warningSettings.enabled[enum] = false
+		}
+		return known
+	} else if name, found := strings.CutPrefix(option, "-W"); found {
+		enum, known := getWarningByName(name)
+		if known {
+			warningSettings.enabled[enum] = true
+		}
+		return known
+	}
+
+	return false
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func isWarningEnabled(warning WarningEnum) bool {
+	// a warning that was explicitly turned on or off takes priority over -Wall and -Wextra
+	enabled, explicit := warningSettings.enabled[warning]
+	if explicit {
+		return enabled
+	}
+
+	info := allWarnings[warning]
+	return info.enabledByDefault || (warningSettings.wall && info.inWall) || (warningSettings.wextra && info.inWextra)
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func isWarningError(warning WarningEnum) bool {
+	isError, explicit := warningSettings.isError[warning]
+	if explicit {
+		return isError
+	}
+	return warningSettings.werror
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func warn(warning WarningEnum, loc Source_Location, msg ...string) {
+	warnDiagnostic(warning, Diagnostic{message: strings.Join(msg, " "), start: loc})
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// for warnings that need more than a message and a location, like notes or fix-its
+func warnDiagnostic(warning WarningEnum, diag Diagnostic) {
+	if !isWarningEnabled(warning) {
+		return
+	}
+
+	name := allWarnings[warning].name
+	if isWarningError(warning) {
+		warningErrorCount++
+		diag.severity = ERROR_SEVERITY
+		diag.category = "-Werror=" + name
+	} else {
+		diag.severity = WARNING_SEVERITY
+		diag.category = "-W" + name
+	}
+	reportDiagnostic(diag)
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// called at the end of each analysis step, gcc keeps going after the first error so we do the same
+func exitIfWarningErrors() {
+	if warningErrorCount > 0 {
+		fail("all warnings being treated as errors,", strconv.Itoa(warningErrorCount), "error(s) found")
+	}
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+func (loc Source_Location) String() string {
+	if loc.line == 0 {
+		// the location is unknown
+		return loc.file
+	}
+	return loc.file + ":" + strconv.Itoa(loc.line) + ":" + strconv.Itoa(loc.col)
+}
+
+/////////////////////////////////////////////////////////////////////////////////
+
+// the type name as it would appear in C source code, used in warning messages
+func getTypeName(dTyp Data_Type) string {
+	switch dTyp.typ {
+	case INT_TYPE:
+		return "int"
+	case LONG_TYPE:
+		return "long"
+	case UNSIGNED_INT_TYPE:
+		return "unsigned int"
+	case UNSIGNED_LONG_TYPE:
+		return "unsigned long"
+	case DOUBLE_TYPE:
+		return "double"
+	case POINTER_TYPE:
+		if dTyp.refType == nil {
+			return "pointer"
+		}
+		return getTypeName(*dTyp.refType) + " *"
+	case FUNCTION_TYPE:
+		return "function"
+	}
+	return "unknown"
+}
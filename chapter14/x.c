@@ -0,0 +1,2 @@
+long f2(int v3) { long r = (long)(unsigned int)v3; return r; }
+int main(void) { long x = f2(-2147483630); return (int)(x / 1000000000l) + (int)(x % 256); }